@@ -2,18 +2,20 @@ module riguz.com/ygo
 
 go 1.23.2
 
-require golang.org/x/exp v0.0.0-20241009180824-f66d83c29e7c
+require (
+	github.com/matoous/go-nanoid/v2 v2.1.0
+	golang.org/x/exp v0.0.0-20241009180824-f66d83c29e7c
+)
 
 require (
 	github.com/google/go-cmp v0.6.0 // indirect
-	github.com/matoous/go-nanoid/v2 v2.1.0 // indirect
 	github.com/pkg/errors v0.9.1 // indirect
 )
 
 require (
 	github.com/davecgh/go-spew v1.1.1 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
-	github.com/stretchr/testify v1.9.0 // indirect
+	github.com/stretchr/testify v1.9.0
 	golang.org/x/text v0.20.0
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 	gotest.tools v2.2.0+incompatible
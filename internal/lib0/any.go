@@ -0,0 +1,454 @@
+package lib0
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"slices"
+	"strconv"
+)
+
+type AnyKind uint8
+
+const (
+	ANY_UNDEFINED AnyKind = iota
+	ANY_NULL
+	ANY_INTEGER
+	ANY_FLOAT32
+	ANY_FLOAT64
+	ANY_BIGINT
+	ANY_BOOL
+	ANY_STRING
+	ANY_OBJECT
+	ANY_ARRAY
+	ANY_BUFFER
+)
+
+func (k AnyKind) String() string {
+	switch k {
+	case ANY_UNDEFINED:
+		return "undefined"
+	case ANY_NULL:
+		return "null"
+	case ANY_INTEGER:
+		return "integer"
+	case ANY_FLOAT32:
+		return "float32"
+	case ANY_FLOAT64:
+		return "float64"
+	case ANY_BIGINT:
+		return "bigint"
+	case ANY_BOOL:
+		return "bool"
+	case ANY_STRING:
+		return "string"
+	case ANY_OBJECT:
+		return "object"
+	case ANY_ARRAY:
+		return "array"
+	case ANY_BUFFER:
+		return "buffer"
+	default:
+		return fmt.Sprintf("unknown(%d)", uint8(k))
+	}
+}
+
+// Any is a value of the lib0 any encoding, which is how Yjs stores
+// arbitrary JavaScript values. The zero value is undefined.
+//
+// Numbers keep the kind they were decoded with, so that decoding and encoding
+// an Any reproduces the exact same bytes. Object fields are kept in encoding
+// order for the same reason.
+type Any struct {
+	Kind AnyKind
+	// Int holds the value of ANY_INTEGER and ANY_BIGINT
+	Int int64
+	// Float holds the value of ANY_FLOAT32 and ANY_FLOAT64
+	Float  float64
+	Bool   bool
+	Str    string
+	Buffer []uint8
+	Array  []Any
+	Object []AnyField
+}
+
+type AnyField struct {
+	Key   string
+	Value Any
+}
+
+func NewAnyNull() Any                     { return Any{Kind: ANY_NULL} }
+func NewAnyInteger(num int64) Any         { return Any{Kind: ANY_INTEGER, Int: num} }
+func NewAnyBigInt(num int64) Any          { return Any{Kind: ANY_BIGINT, Int: num} }
+func NewAnyFloat32(num float32) Any       { return Any{Kind: ANY_FLOAT32, Float: float64(num)} }
+func NewAnyFloat64(num float64) Any       { return Any{Kind: ANY_FLOAT64, Float: num} }
+func NewAnyBool(b bool) Any               { return Any{Kind: ANY_BOOL, Bool: b} }
+func NewAnyString(str string) Any         { return Any{Kind: ANY_STRING, Str: str} }
+func NewAnyBuffer(buf []uint8) Any        { return Any{Kind: ANY_BUFFER, Buffer: buf} }
+func NewAnyArray(values ...Any) Any       { return Any{Kind: ANY_ARRAY, Array: values} }
+func NewAnyObject(fields ...AnyField) Any { return Any{Kind: ANY_OBJECT, Object: fields} }
+
+var F64_MAX_SAFE_INTEGER float64 = math.Pow(2, 53) - 1
+var F64_MIN_SAFE_INTEGER float64 = -F64_MAX_SAFE_INTEGER
+
+// NewAnyNumber picks the smallest kind able to hold num without loss, which
+// is what lib0 does when it writes a JavaScript number. Like yrs, safe
+// integers are always written as var ints.
+func NewAnyNumber(num float64) Any {
+	truncated := math.Trunc(num)
+	if truncated == num &&
+		truncated <= F64_MAX_SAFE_INTEGER &&
+		truncated >= F64_MIN_SAFE_INTEGER {
+		return NewAnyInteger(int64(truncated))
+	} else if float64(float32(num)) == num {
+		return NewAnyFloat32(float32(num))
+	} else {
+		return NewAnyFloat64(num)
+	}
+}
+
+// IsNumber reports whether the value is a JavaScript number, bigints are not
+// numbers.
+func (a Any) IsNumber() bool {
+	return a.Kind == ANY_INTEGER || a.Kind == ANY_FLOAT32 || a.Kind == ANY_FLOAT64
+}
+
+// Number returns the value of a number as float64.
+func (a Any) Number() float64 {
+	if a.Kind == ANY_INTEGER {
+		return float64(a.Int)
+	}
+	return a.Float
+}
+
+// Get returns the value of an object field.
+func (a Any) Get(key string) (Any, bool) {
+	for _, f := range a.Object {
+		if f.Key == key {
+			return f.Value, true
+		}
+	}
+	return Any{}, false
+}
+
+// Equal compares values the way JavaScript compares their content: numbers
+// are equal if they have the same value regardless of their kind, and the
+// order of object fields doesn't matter.
+func (a Any) Equal(other Any) bool {
+	if a.IsNumber() && other.IsNumber() {
+		if a.Kind == ANY_INTEGER && other.Kind == ANY_INTEGER {
+			return a.Int == other.Int
+		}
+		return a.Number() == other.Number()
+	}
+	if a.Kind != other.Kind {
+		return false
+	}
+	switch a.Kind {
+	case ANY_UNDEFINED, ANY_NULL:
+		return true
+	case ANY_BIGINT:
+		return a.Int == other.Int
+	case ANY_BOOL:
+		return a.Bool == other.Bool
+	case ANY_STRING:
+		return a.Str == other.Str
+	case ANY_BUFFER:
+		return bytes.Equal(a.Buffer, other.Buffer)
+	case ANY_ARRAY:
+		return slices.EqualFunc(a.Array, other.Array, Any.Equal)
+	case ANY_OBJECT:
+		if len(a.Object) != len(other.Object) {
+			return false
+		}
+		for _, f := range a.Object {
+			v, ok := other.Get(f.Key)
+			if !ok || !f.Value.Equal(v) {
+				return false
+			}
+		}
+		return true
+	default:
+		return false
+	}
+}
+
+// ToGo converts the value into plain Go values: Undefined{}, nil, int64
+// (integers and bigints), float32, float64, bool, string, []uint8, []any and
+// map[string]any.
+func (a Any) ToGo() any {
+	switch a.Kind {
+	case ANY_UNDEFINED:
+		return Undefined{}
+	case ANY_NULL:
+		return nil
+	case ANY_INTEGER, ANY_BIGINT:
+		return a.Int
+	case ANY_FLOAT32:
+		return float32(a.Float)
+	case ANY_FLOAT64:
+		return a.Float
+	case ANY_BOOL:
+		return a.Bool
+	case ANY_STRING:
+		return a.Str
+	case ANY_BUFFER:
+		return a.Buffer
+	case ANY_ARRAY:
+		arr := make([]any, len(a.Array))
+		for i, v := range a.Array {
+			arr[i] = v.ToGo()
+		}
+		return arr
+	case ANY_OBJECT:
+		obj := make(map[string]any, len(a.Object))
+		for _, f := range a.Object {
+			obj[f.Key] = f.Value.ToGo()
+		}
+		return obj
+	default:
+		return nil
+	}
+}
+
+// AnyFromGo converts plain Go values into an Any. Integers within the int32
+// range become integers and larger ones bigints, float64 values are stored
+// with the smallest kind that holds them. Map fields are sorted by key, so
+// the same map always encodes to the same bytes.
+func AnyFromGo(v any) (Any, error) {
+	switch t := v.(type) {
+	case Any:
+		return t, nil
+	case nil:
+		return NewAnyNull(), nil
+	case Undefined:
+		return Any{}, nil
+	case bool:
+		return NewAnyBool(t), nil
+	case string:
+		return NewAnyString(t), nil
+	case float32:
+		return NewAnyFloat32(t), nil
+	case float64:
+		return NewAnyNumber(t), nil
+	case int8:
+		return NewAnyInteger(int64(t)), nil
+	case int16:
+		return NewAnyInteger(int64(t)), nil
+	case int32:
+		return NewAnyInteger(int64(t)), nil
+	case int:
+		return anyFromInt64(int64(t)), nil
+	case int64:
+		return anyFromInt64(t), nil
+	case []uint8:
+		return NewAnyBuffer(t), nil
+	case []Any:
+		return NewAnyArray(t...), nil
+	case []any:
+		arr := make([]Any, len(t))
+		for i, e := range t {
+			value, err := AnyFromGo(e)
+			if err != nil {
+				return Any{}, err
+			}
+			arr[i] = value
+		}
+		return NewAnyArray(arr...), nil
+	case map[string]any:
+		keys := make([]string, 0, len(t))
+		for k := range t {
+			keys = append(keys, k)
+		}
+		slices.Sort(keys)
+		fields := make([]AnyField, len(keys))
+		for i, k := range keys {
+			value, err := AnyFromGo(t[k])
+			if err != nil {
+				return Any{}, err
+			}
+			fields[i] = AnyField{Key: k, Value: value}
+		}
+		return NewAnyObject(fields...), nil
+	default:
+		return Any{}, fmt.Errorf("unrecognized any payload type:%v", reflect.TypeOf(v))
+	}
+}
+
+func anyFromInt64(num int64) Any {
+	if num <= math.MaxInt32 && num >= math.MinInt32 {
+		return NewAnyInteger(num)
+	}
+	return NewAnyBigInt(num)
+}
+
+// MarshalJSON writes the value the way JSON.stringify does: undefined fields
+// are left out of objects and become null in arrays, NaN and infinities
+// become null and buffers are written as objects indexed by position.
+// Bigints, which JSON.stringify refuses, are written as plain numbers.
+func (a Any) MarshalJSON() ([]byte, error) {
+	buf := &bytes.Buffer{}
+	if err := a.writeJSON(buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (a Any) writeJSON(buf *bytes.Buffer) error {
+	switch a.Kind {
+	case ANY_UNDEFINED, ANY_NULL:
+		buf.WriteString("null")
+	case ANY_INTEGER, ANY_BIGINT:
+		buf.WriteString(strconv.FormatInt(a.Int, 10))
+	case ANY_FLOAT32, ANY_FLOAT64:
+		if math.IsNaN(a.Float) || math.IsInf(a.Float, 0) {
+			buf.WriteString("null")
+			return nil
+		}
+		num, err := json.Marshal(a.Float)
+		if err != nil {
+			return err
+		}
+		buf.Write(num)
+	case ANY_BOOL:
+		buf.WriteString(strconv.FormatBool(a.Bool))
+	case ANY_STRING:
+		return writeJSONString(buf, a.Str)
+	case ANY_BUFFER:
+		buf.WriteByte('{')
+		for i, b := range a.Buffer {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			fmt.Fprintf(buf, `"%d":%d`, i, b)
+		}
+		buf.WriteByte('}')
+	case ANY_ARRAY:
+		buf.WriteByte('[')
+		for i, v := range a.Array {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := v.writeJSON(buf); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+	case ANY_OBJECT:
+		buf.WriteByte('{')
+		first := true
+		for _, f := range a.Object {
+			if f.Value.Kind == ANY_UNDEFINED {
+				continue
+			}
+			if !first {
+				buf.WriteByte(',')
+			}
+			first = false
+			if err := writeJSONString(buf, f.Key); err != nil {
+				return err
+			}
+			buf.WriteByte(':')
+			if err := f.Value.writeJSON(buf); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('}')
+	default:
+		return fmt.Errorf("unknown any kind: %v", a.Kind)
+	}
+	return nil
+}
+
+func writeJSONString(buf *bytes.Buffer, str string) error {
+	encoder := json.NewEncoder(buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(str); err != nil {
+		return err
+	}
+	// Encode terminates every value with a newline
+	buf.Truncate(buf.Len() - 1)
+	return nil
+}
+
+// UnmarshalJSON reads a JSON value, object fields keep the order they have
+// in the JSON text. Numbers are stored like AnyFromGo stores float64 values.
+func (a *Any) UnmarshalJSON(data []byte) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	value, err := readJSON(decoder)
+	if err != nil {
+		return err
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return errors.New("invalid JSON: unexpected data after top-level value")
+	}
+	*a = value
+	return nil
+}
+
+func readJSON(decoder *json.Decoder) (Any, error) {
+	token, err := decoder.Token()
+	if err != nil {
+		return Any{}, err
+	}
+	switch t := token.(type) {
+	case nil:
+		return NewAnyNull(), nil
+	case bool:
+		return NewAnyBool(t), nil
+	case string:
+		return NewAnyString(t), nil
+	case json.Number:
+		num, err := t.Float64()
+		if err != nil {
+			return Any{}, err
+		}
+		return NewAnyNumber(num), nil
+	case json.Delim:
+		switch t {
+		case '[':
+			var arr []Any
+			for decoder.More() {
+				value, err := readJSON(decoder)
+				if err != nil {
+					return Any{}, err
+				}
+				arr = append(arr, value)
+			}
+			if _, err := decoder.Token(); err != nil {
+				return Any{}, err
+			}
+			return NewAnyArray(arr...), nil
+		case '{':
+			var fields []AnyField
+			for decoder.More() {
+				key, err := decoder.Token()
+				if err != nil {
+					return Any{}, err
+				}
+				value, err := readJSON(decoder)
+				if err != nil {
+					return Any{}, err
+				}
+				// like JSON.parse, a repeated key keeps its first position
+				// but takes the last value
+				i := slices.IndexFunc(fields, func(f AnyField) bool { return f.Key == key })
+				if i >= 0 {
+					fields[i].Value = value
+				} else {
+					fields = append(fields, AnyField{Key: key.(string), Value: value})
+				}
+			}
+			if _, err := decoder.Token(); err != nil {
+				return Any{}, err
+			}
+			return NewAnyObject(fields...), nil
+		}
+	}
+	return Any{}, fmt.Errorf("invalid JSON token: %v", token)
+}
@@ -0,0 +1,169 @@
+package lib0_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"math"
+	"strings"
+	"testing"
+
+	"gotest.tools/assert"
+	"riguz.com/ygo/internal/lib0"
+)
+
+// decoding and encoding must reproduce the input, whichever kind was used
+// for a number
+func TestAny_roundTrip(t *testing.T) {
+	var tests = []string{
+		"7f", "7e", "7d00", "7d41", "7dbfffffff0f",
+		// 2^32 as written by Yjs
+		"7c4f800000",
+		// 1 as float64
+		"7b3ff0000000000000",
+		"7a8000000000000000", "78", "79",
+		"770c48656c6c6f20776f726c6421", "74042a3b4c9d",
+		// object fields keep their order
+		"7602046e616d6577064a2e204d6573036167657d12",
+		"75037f7dbf037ccf000000",
+		// 64 elements, a length that would be negative zero as a var int
+		"7540" + strings.Repeat("7e", 64),
+	}
+	for _, tt := range tests {
+		t.Run(tt, func(t *testing.T) {
+			buf, _ := hex.DecodeString(tt)
+			r := lib0.NewBufferRead(bytes.NewBuffer(buf))
+			value, err := r.ReadAny()
+			assert.NilError(t, err)
+
+			w := lib0.NewBufferWrite()
+			assert.NilError(t, w.WriteAny(value))
+			assert.Equal(t, tt, hex.EncodeToString(w.ToBytes()))
+		})
+	}
+}
+
+func TestAny_number(t *testing.T) {
+	var tests = []struct {
+		num      float64
+		expected lib0.Any
+	}{
+		{0, lib0.NewAnyInteger(0)},
+		{-42, lib0.NewAnyInteger(-42)},
+		{math.Pow(2, 40), lib0.NewAnyInteger(1 << 40)},
+		{math.Pow(2, 60), lib0.NewAnyFloat32(float32(math.Pow(2, 60)))},
+		{1.5, lib0.NewAnyFloat32(1.5)},
+		{0.1, lib0.NewAnyFloat64(0.1)},
+	}
+	for _, tt := range tests {
+		assert.DeepEqual(t, tt.expected, lib0.NewAnyNumber(tt.num))
+	}
+}
+
+func TestAny_equal(t *testing.T) {
+	obj := lib0.NewAnyObject(
+		lib0.AnyField{Key: "a", Value: lib0.NewAnyInteger(1)},
+		lib0.AnyField{Key: "b", Value: lib0.NewAnyArray(lib0.NewAnyString("x"), lib0.NewAnyNull())},
+	)
+	reordered := lib0.NewAnyObject(
+		lib0.AnyField{Key: "b", Value: lib0.NewAnyArray(lib0.NewAnyString("x"), lib0.NewAnyNull())},
+		lib0.AnyField{Key: "a", Value: lib0.NewAnyFloat64(1)},
+	)
+	var tests = []struct {
+		a     lib0.Any
+		b     lib0.Any
+		equal bool
+	}{
+		{lib0.Any{}, lib0.Any{}, true},
+		{lib0.Any{}, lib0.NewAnyNull(), false},
+		{lib0.NewAnyInteger(2), lib0.NewAnyFloat32(2), true},
+		{lib0.NewAnyInteger(2), lib0.NewAnyBigInt(2), false},
+		{lib0.NewAnyFloat64(math.NaN()), lib0.NewAnyFloat64(math.NaN()), false},
+		{lib0.NewAnyBuffer([]uint8{1}), lib0.NewAnyBuffer([]uint8{1}), true},
+		{lib0.NewAnyArray(lib0.NewAnyBool(true)), lib0.NewAnyArray(lib0.NewAnyBool(false)), false},
+		{obj, reordered, true},
+		{obj, lib0.NewAnyObject(), false},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.equal, tt.a.Equal(tt.b))
+		assert.Equal(t, tt.equal, tt.b.Equal(tt.a))
+	}
+}
+
+func TestAny_fromGo(t *testing.T) {
+	value, err := lib0.AnyFromGo(map[string]any{
+		"b":   []any{int32(1), 2.5, nil, lib0.Undefined{}},
+		"a":   int64(math.MaxInt64),
+		"buf": []uint8{1},
+	})
+	assert.NilError(t, err)
+	assert.DeepEqual(t, lib0.NewAnyObject(
+		lib0.AnyField{Key: "a", Value: lib0.NewAnyBigInt(math.MaxInt64)},
+		lib0.AnyField{Key: "b", Value: lib0.NewAnyArray(
+			lib0.NewAnyInteger(1), lib0.NewAnyFloat32(2.5), lib0.NewAnyNull(), lib0.Any{})},
+		lib0.AnyField{Key: "buf", Value: lib0.NewAnyBuffer([]uint8{1})},
+	), value)
+
+	assert.DeepEqual(t, map[string]any{
+		"a":   int64(math.MaxInt64),
+		"b":   []any{int64(1), float32(2.5), nil, lib0.Undefined{}},
+		"buf": []uint8{1},
+	}, value.ToGo())
+
+	_, err = lib0.AnyFromGo(struct{}{})
+	assert.ErrorContains(t, err, "unrecognized any payload type")
+}
+
+func TestAny_json(t *testing.T) {
+	var tests = []struct {
+		json     string
+		expected lib0.Any
+	}{
+		{`null`, lib0.NewAnyNull()},
+		{`true`, lib0.NewAnyBool(true)},
+		{`-12`, lib0.NewAnyInteger(-12)},
+		{`1.0`, lib0.NewAnyInteger(1)},
+		{`0.1`, lib0.NewAnyFloat64(0.1)},
+		{`"<a>\n中"`, lib0.NewAnyString("<a>\n中")},
+		{`[1,"a",[]]`, lib0.NewAnyArray(lib0.NewAnyInteger(1), lib0.NewAnyString("a"), lib0.NewAnyArray())},
+		{`{"z":1,"a":{}}`, lib0.NewAnyObject(
+			lib0.AnyField{Key: "z", Value: lib0.NewAnyInteger(1)},
+			lib0.AnyField{Key: "a", Value: lib0.NewAnyObject()},
+		)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.json, func(t *testing.T) {
+			var value lib0.Any
+			assert.NilError(t, json.Unmarshal([]byte(tt.json), &value))
+			assert.DeepEqual(t, tt.expected, value)
+
+			// json.Marshal would escape html characters in the output
+			out, err := value.MarshalJSON()
+			assert.NilError(t, err)
+			assert.Equal(t, strings.ReplaceAll(tt.json, "1.0", "1"), string(out))
+		})
+	}
+
+	var value lib0.Any
+	assert.NilError(t, json.Unmarshal([]byte(`{"a":1,"b":2,"a":3}`), &value))
+	assert.DeepEqual(t, lib0.NewAnyObject(
+		lib0.AnyField{Key: "a", Value: lib0.NewAnyInteger(3)},
+		lib0.AnyField{Key: "b", Value: lib0.NewAnyInteger(2)},
+	), value)
+	assert.Assert(t, json.Unmarshal([]byte(`[1,`), &value) != nil)
+	assert.Assert(t, json.Unmarshal([]byte(`1 2`), &value) != nil)
+}
+
+// values JSON can't represent are written like JSON.stringify writes them
+func TestAny_jsonFromJS(t *testing.T) {
+	value := lib0.NewAnyObject(
+		lib0.AnyField{Key: "u", Value: lib0.Any{}},
+		lib0.AnyField{Key: "arr", Value: lib0.NewAnyArray(lib0.Any{}, lib0.NewAnyFloat64(math.Inf(1)))},
+		lib0.AnyField{Key: "buf", Value: lib0.NewAnyBuffer([]uint8{7, 8})},
+		lib0.AnyField{Key: "f", Value: lib0.NewAnyFloat32(0.1)},
+		lib0.AnyField{Key: "big", Value: lib0.NewAnyBigInt(math.MaxInt64)},
+	)
+	out, err := json.Marshal(value)
+	assert.NilError(t, err)
+	assert.Equal(t, `{"arr":[null,null],"buf":{"0":7,"1":8},"f":0.10000000149011612,"big":9223372036854775807}`, string(out))
+}
@@ -0,0 +1,45 @@
+package lib0_test
+
+import (
+	"encoding/hex"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gotest.tools/assert"
+	"riguz.com/ygo/internal/lib0"
+)
+
+// The fixtures are described in pkg/ygo/testdata/README.md.
+func TestConformance_any(t *testing.T) {
+	paths, err := filepath.Glob(filepath.Join("testdata", "any", "*.bin"))
+	assert.NilError(t, err)
+	assert.Assert(t, len(paths) > 0)
+	for _, path := range paths {
+		name := strings.TrimSuffix(filepath.Base(path), ".bin")
+		t.Run(name, func(t *testing.T) {
+			buf, err := os.ReadFile(path)
+			assert.NilError(t, err)
+			r := lib0.NewSliceRead(buf)
+			value, err := r.ReadAny()
+			assert.NilError(t, err)
+			assert.Equal(t, 0, r.Remaining())
+
+			w := lib0.NewBufferWrite()
+			assert.NilError(t, w.WriteAny(value))
+			assert.Equal(t, hex.EncodeToString(buf), hex.EncodeToString(w.ToBytes()))
+
+			expected, err := os.ReadFile(strings.TrimSuffix(path, ".bin") + ".json")
+			if errors.Is(err, fs.ErrNotExist) {
+				return
+			}
+			assert.NilError(t, err)
+			json, err := value.MarshalJSON()
+			assert.NilError(t, err)
+			assert.Equal(t, strings.TrimSpace(string(expected)), string(json))
+		})
+	}
+}
@@ -6,8 +6,65 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"math"
 )
 
+var (
+	// ErrUnexpectedEOF means the input ended in the middle of a value.
+	ErrUnexpectedEOF = errors.New("lib0: unexpected EOF")
+	// ErrLimitExceeded means a length, count or depth read from the input is
+	// larger than what the reader's Limits allow.
+	ErrLimitExceeded = errors.New("lib0: limit exceeded")
+	// ErrVarIntOverflow means a var int does not fit into 64 bits.
+	ErrVarIntOverflow = errors.New("lib0: varint overflows 64 bits")
+)
+
+// DecodeError is returned by the readers when decoding fails. Err is one of
+// ErrUnexpectedEOF, ErrLimitExceeded, ErrVarIntOverflow, or an error
+// returned by the underlying io.Reader, so it can be checked with errors.Is.
+type DecodeError struct {
+	// Offset is the position of the value that could not be decoded,
+	// relative to where the reader started.
+	Offset int64
+	Err    error
+	Detail string
+}
+
+func (e *DecodeError) Error() string {
+	if e.Detail != "" {
+		return fmt.Sprintf("%v at offset %d: %s", e.Err, e.Offset, e.Detail)
+	}
+	return fmt.Sprintf("%v at offset %d", e.Err, e.Offset)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// Limits bounds what a reader accepts from untrusted input. A zero field
+// means no limit.
+type Limits struct {
+	// MaxBufferLen is the maximum length of a byte array.
+	MaxBufferLen uint64
+	// MaxStringLen is the maximum length of a string, in bytes.
+	MaxStringLen uint64
+	// MaxAnyDepth is the maximum number of nested arrays and objects in an
+	// any value.
+	MaxAnyDepth int
+	// MaxElements is the maximum number of elements in a single array or
+	// object of an any value. Formats built on top of lib0 use it to bound
+	// their own counts as well.
+	MaxElements uint64
+}
+
+// DefaultLimits are the limits new readers start with.
+var DefaultLimits = Limits{
+	MaxBufferLen: 256 << 20,
+	MaxStringLen: 256 << 20,
+	MaxAnyDepth:  256,
+	MaxElements:  1 << 24,
+}
+
 type Read interface {
 	ReadUint8Array(len uint) ([]uint8, error)
 	ReadUint8() (uint8, error)
@@ -22,200 +79,506 @@ type Read interface {
 	ReadVarUint() (uint64, error)
 	ReadVarInt() (int64, error)
 	ReadVarString() (string, error)
-	ReadAny() (any, error)
+	ReadAny() (Any, error)
+	Limits() Limits
 }
 
-var _ Read = &BufferRead{}
+// source is where a reader gets its bytes from.
+type source interface {
+	io.ByteReader
+	// next returns the next n bytes.
+	next(n uint64) ([]uint8, error)
+	// offset returns the number of bytes consumed so far.
+	offset() int64
+}
 
-type BufferRead struct {
-	reader *bufio.Reader
+// reader holds the decoding logic shared by BufferRead and SliceRead.
+type reader struct {
+	src    source
+	limits Limits
 }
 
-func NewBufferRead(reader io.Reader) BufferRead {
-	return BufferRead{
-		reader: bufio.NewReader(reader),
+// Limits returns the limits currently in effect.
+func (r *reader) Limits() Limits {
+	return r.limits
+}
+
+// SetLimits replaces the limits of the reader.
+func (r *reader) SetLimits(limits Limits) {
+	r.limits = limits
+}
+
+// Offset returns the number of bytes consumed so far.
+func (r *reader) Offset() int64 {
+	return r.src.offset()
+}
+
+func (r *reader) fail(offset int64, err error, detail string) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		err = ErrUnexpectedEOF
 	}
+	return &DecodeError{Offset: offset, Err: err, Detail: detail}
 }
 
-func (r *BufferRead) ReadUint8Array(len uint) ([]uint8, error) {
-	buf := make([]uint8, len)
-	n, err := r.reader.Read(buf)
-	if err != nil {
-		return nil, err
+func (r *reader) checkLimit(offset int64, what string, value, limit uint64) error {
+	if limit > 0 && value > limit {
+		return r.fail(offset, ErrLimitExceeded, fmt.Sprintf("%s %d is larger than %d", what, value, limit))
 	}
-	if n != int(len) {
-		return nil, fmt.Errorf("unexpected EOF: expected to read %v but only got %v", len, n)
+	return nil
+}
+
+func (r *reader) next(n uint64) ([]uint8, error) {
+	offset := r.src.offset()
+	buf, err := r.src.next(n)
+	if err != nil {
+		return nil, r.fail(offset, err, "")
 	}
 	return buf, nil
 }
 
-func (r *BufferRead) ReadUint8() (uint8, error) {
-	buf, err := r.ReadUint8Array(1)
+func (r *reader) ReadUint8Array(len uint) ([]uint8, error) {
+	if err := r.checkLimit(r.src.offset(), "buffer length", uint64(len), r.limits.MaxBufferLen); err != nil {
+		return nil, err
+	}
+	return r.next(uint64(len))
+}
+
+func (r *reader) ReadUint8() (uint8, error) {
+	offset := r.src.offset()
+	b, err := r.src.ReadByte()
 	if err != nil {
-		return 0, err
+		return 0, r.fail(offset, err, "")
 	}
-	return buf[0], nil
+	return b, nil
 }
 
-func (r *BufferRead) ReadUint16() (uint16, error) {
-	var value uint16
-	err := binary.Read(r.reader, binary.LittleEndian, &value)
-	return value, err
+func (r *reader) ReadUint16() (uint16, error) {
+	buf, err := r.next(2)
+	if err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint16(buf), nil
 }
 
-func (r *BufferRead) ReadUint32() (uint32, error) {
-	var value uint32
-	err := binary.Read(r.reader, binary.LittleEndian, &value)
-	return value, err
+func (r *reader) ReadUint32() (uint32, error) {
+	buf, err := r.next(4)
+	if err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint32(buf), nil
 }
 
-func (r *BufferRead) ReadUint32BigEndian() (uint32, error) {
-	var value uint32
-	err := binary.Read(r.reader, binary.BigEndian, &value)
-	return value, err
+func (r *reader) ReadUint32BigEndian() (uint32, error) {
+	buf, err := r.next(4)
+	if err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint32(buf), nil
 }
 
-func (r *BufferRead) ReadUint64() (uint64, error) {
-	var value uint64
-	err := binary.Read(r.reader, binary.BigEndian, &value)
-	return value, err
+func (r *reader) ReadUint64() (uint64, error) {
+	buf, err := r.next(8)
+	if err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint64(buf), nil
 }
 
-func (r *BufferRead) ReadFloat32() (float32, error) {
-	var value float32
-	err := binary.Read(r.reader, binary.BigEndian, &value)
-	return value, err
+func (r *reader) ReadFloat32() (float32, error) {
+	num, err := r.ReadUint32BigEndian()
+	return math.Float32frombits(num), err
 }
 
-func (r *BufferRead) ReadFloat64() (float64, error) {
-	var value float64
-	err := binary.Read(r.reader, binary.BigEndian, &value)
-	return value, err
+func (r *reader) ReadFloat64() (float64, error) {
+	num, err := r.ReadUint64()
+	return math.Float64frombits(num), err
 }
 
-func (r *BufferRead) ReadInt64() (int64, error) {
-	var value int64
-	err := binary.Read(r.reader, binary.BigEndian, &value)
-	return value, err
+func (r *reader) ReadInt64() (int64, error) {
+	num, err := r.ReadUint64()
+	return int64(num), err
 }
 
-func (r *BufferRead) ReadVarUint() (uint64, error) {
-	return binary.ReadUvarint(r.reader)
+func (r *reader) ReadVarUint() (uint64, error) {
+	offset := r.src.offset()
+	var num uint64
+	shift := 0
+	for {
+		b, err := r.src.ReadByte()
+		if err != nil {
+			return 0, r.fail(offset, err, "")
+		}
+		chunk := uint64(b & 0b0111_1111)
+		if shift >= 64 || (shift > 57 && chunk>>(64-shift) != 0) {
+			return 0, r.fail(offset, ErrVarIntOverflow, "")
+		}
+		num |= chunk << shift
+		if b < 0b1000_0000 {
+			return num, nil
+		}
+		shift += 7
+	}
 }
 
-func (r *BufferRead) ReadVarUint8Array() ([]uint8, error) {
+func (r *reader) ReadVarUint8Array() ([]uint8, error) {
+	offset := r.src.offset()
 	len, err := r.ReadVarUint()
 	if err != nil {
 		return nil, err
 	}
-	return r.ReadUint8Array(uint(len))
+	if err := r.checkLimit(offset, "buffer length", len, r.limits.MaxBufferLen); err != nil {
+		return nil, err
+	}
+	return r.next(len)
 }
 
-func (r *BufferRead) ReadVarInt() (int64, error) {
-	firstByte, err := r.ReadUint8()
+func (r *reader) ReadVarInt() (int64, error) {
+	num, _, err := r.ReadVarIntWithSign()
+	return num, err
+}
+
+// ReadVarIntWithSign works like ReadVarInt, but also reports whether the
+// sign bit was set. This makes it possible to tell a negative zero (which
+// lib0 uses as a marker in its RLE encodings) apart from a plain zero.
+func (r *reader) ReadVarIntWithSign() (int64, bool, error) {
+	offset := r.src.offset()
+	b, err := r.src.ReadByte()
 	if err != nil {
-		return 0, err
+		return 0, false, r.fail(offset, err, "")
 	}
-	var num int64 = int64(firstByte & uint8(0b0011_1111))
-	isNegative := false
-	if firstByte&uint8(0b0100_0000) > 0 {
-		isNegative = true
-	}
-	if firstByte&uint8(0b1000_0000) == 0 {
-		if isNegative {
-			return -num, nil
-		} else {
-			return num, nil
-		}
-	}
-	len := 6
-	for {
-		byte, err := r.ReadUint8()
+	num := uint64(b & 0b0011_1111)
+	isNegative := b&0b0100_0000 != 0
+	shift := 6
+	for b&0b1000_0000 != 0 {
+		b, err = r.src.ReadByte()
 		if err != nil {
-			return 0, err
-		}
-		num |= (int64(byte) & int64(0b0111_1111)) << len
-		len += 7
-		if byte < uint8(0b1000_0000) {
-			if isNegative {
-				return -num, nil
-			} else {
-				return num, nil
-			}
+			return 0, false, r.fail(offset, err, "")
 		}
-		if len > 70 {
-			return 0, errors.New("varint size exceeded length of 70 bits")
+		chunk := uint64(b & 0b0111_1111)
+		if shift >= 64 || (shift > 57 && chunk>>(64-shift) != 0) {
+			return 0, false, r.fail(offset, ErrVarIntOverflow, "")
 		}
+		num |= chunk << shift
+		shift += 7
 	}
+	if num > math.MaxInt64 {
+		return 0, false, r.fail(offset, ErrVarIntOverflow, "")
+	}
+	if isNegative {
+		return -int64(num), true, nil
+	}
+	return int64(num), false, nil
 }
 
-func (r *BufferRead) ReadVarString() (string, error) {
-	buf, err := r.ReadVarUint8Array()
+func (r *reader) ReadVarString() (string, error) {
+	offset := r.src.offset()
+	len, err := r.ReadVarUint()
+	if err != nil {
+		return "", err
+	}
+	if err := r.checkLimit(offset, "string length", len, r.limits.MaxStringLen); err != nil {
+		return "", err
+	}
+	buf, err := r.next(len)
 	if err != nil {
 		return "", err
 	}
 	return string(buf), nil
 }
 
-func (r *BufferRead) ReadAny() (any, error) {
+func (r *reader) ReadAny() (Any, error) {
+	return r.readAny(0)
+}
+
+// readCount reads the element count of an array or object at the given
+// nesting depth and checks it against the limits.
+func (r *reader) readCount(depth int) (uint64, error) {
+	offset := r.src.offset()
+	if r.limits.MaxAnyDepth > 0 && depth > r.limits.MaxAnyDepth {
+		return 0, r.fail(offset, ErrLimitExceeded, fmt.Sprintf("any nesting depth is larger than %d", r.limits.MaxAnyDepth))
+	}
+	len, err := r.ReadVarUint()
+	if err != nil {
+		return 0, err
+	}
+	if err := r.checkLimit(offset, "element count", len, r.limits.MaxElements); err != nil {
+		return 0, err
+	}
+	return len, nil
+}
+
+func (r *reader) readAny(depth int) (Any, error) {
+	offset := r.src.offset()
 	t, err := r.ReadUint8()
 	if err != nil {
-		return nil, err
+		return Any{}, err
 	}
 	switch t {
 	case 127:
-		return Undefined{}, nil
+		return Any{}, nil
 	case 126:
-		return nil, nil
+		return NewAnyNull(), nil
 	case 125:
-		return r.ReadVarInt()
+		num, err := r.ReadVarInt()
+		return NewAnyInteger(num), err
 	case 124:
-		return r.ReadFloat32()
+		num, err := r.ReadFloat32()
+		return NewAnyFloat32(num), err
 	case 123:
-		return r.ReadFloat64()
+		num, err := r.ReadFloat64()
+		return NewAnyFloat64(num), err
 	case 122:
-		return r.ReadInt64()
+		num, err := r.ReadInt64()
+		return NewAnyBigInt(num), err
 	case 121:
-		return false, nil
+		return NewAnyBool(false), nil
 	case 120:
-		return true, nil
+		return NewAnyBool(true), nil
 	case 119:
-		return r.ReadVarString()
+		str, err := r.ReadVarString()
+		return NewAnyString(str), err
 	case 118:
-		len, err := r.ReadVarInt()
+		len, err := r.readCount(depth + 1)
 		if err != nil {
-			return nil, err
+			return Any{}, err
 		}
-		obj := map[string]any{}
-		for _ = range len {
+		var fields []AnyField
+		for range len {
 			key, err := r.ReadVarString()
 			if err != nil {
-				return nil, err
+				return Any{}, err
 			}
-			val, err := r.ReadAny()
+			val, err := r.readAny(depth + 1)
 			if err != nil {
-				return nil, err
+				return Any{}, err
 			}
-			obj[key] = val
+			fields = append(fields, AnyField{Key: key, Value: val})
 		}
-		return obj, nil
+		return NewAnyObject(fields...), nil
 	case 117:
-		len, err := r.ReadVarInt()
+		len, err := r.readCount(depth + 1)
 		if err != nil {
-			return nil, err
+			return Any{}, err
 		}
-		arr := make([]any, len)
-		for i := range len {
-			val, err := r.ReadAny()
+		var arr []Any
+		for range len {
+			val, err := r.readAny(depth + 1)
 			if err != nil {
-				return nil, err
+				return Any{}, err
 			}
-			arr[i] = val
+			arr = append(arr, val)
 		}
-		return arr, nil
+		return NewAnyArray(arr...), nil
 	case 116:
-		return r.ReadVarUint8Array()
+		buf, err := r.ReadVarUint8Array()
+		return NewAnyBuffer(buf), err
+	default:
+		return Any{}, r.fail(offset, fmt.Errorf("lib0: unknown any type %v", t), "")
+	}
+}
+
+var _ Read = &BufferRead{}
+
+type BufferRead struct {
+	reader
+	buffer *bufferSource
+}
+
+func NewBufferRead(r io.Reader) BufferRead {
+	src := &bufferSource{reader: bufio.NewReader(r)}
+	return BufferRead{
+		reader: reader{src: src, limits: DefaultLimits},
+		buffer: src,
+	}
+}
+
+// HasContent reports whether there are still bytes left to read.
+func (r *BufferRead) HasContent() bool {
+	_, err := r.buffer.reader.Peek(1)
+	return err == nil
+}
+
+// bufferReadChunk is how much a bufferSource allocates at once for a large
+// byte array. The length of an array comes from the input, so reading it in
+// chunks keeps the memory used bounded by the data that is actually there.
+const bufferReadChunk = 64 << 10
+
+type bufferSource struct {
+	reader *bufio.Reader
+	pos    int64
+}
+
+func (s *bufferSource) ReadByte() (byte, error) {
+	b, err := s.reader.ReadByte()
+	if err == nil {
+		s.pos++
+	}
+	return b, err
+}
+
+func (s *bufferSource) next(n uint64) ([]uint8, error) {
+	buf := make([]uint8, 0, min(n, bufferReadChunk))
+	for uint64(len(buf)) < n {
+		start := len(buf)
+		size := int(min(n-uint64(start), bufferReadChunk))
+		if cap(buf)-start < size {
+			buf = append(buf, make([]uint8, size)...)
+		} else {
+			buf = buf[:start+size]
+		}
+		read, err := io.ReadFull(s.reader, buf[start:])
+		s.pos += int64(read)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return buf, nil
+}
+
+func (s *bufferSource) offset() int64 {
+	return s.pos
+}
+
+var _ Read = &SliceRead{}
+
+// SliceRead reads directly from a byte slice. Unlike BufferRead nothing is
+// copied: the byte arrays it returns are sub-slices of the underlying
+// buffer, so they must not be modified while the buffer is in use.
+type SliceRead struct {
+	reader
+	slice *sliceSource
+}
+
+func NewSliceRead(buf []uint8) SliceRead {
+	src := &sliceSource{buf: buf}
+	return SliceRead{
+		reader: reader{src: src, limits: DefaultLimits},
+		slice:  src,
+	}
+}
+
+type sliceSource struct {
+	buf []uint8
+	pos int
+}
+
+func (s *sliceSource) ReadByte() (byte, error) {
+	if s.pos >= len(s.buf) {
+		return 0, io.EOF
+	}
+	b := s.buf[s.pos]
+	s.pos++
+	return b, nil
+}
+
+func (s *sliceSource) next(n uint64) ([]uint8, error) {
+	if n > uint64(len(s.buf)-s.pos) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	end := s.pos + int(n)
+	buf := s.buf[s.pos:end:end]
+	s.pos = end
+	return buf, nil
+}
+
+func (s *sliceSource) offset() int64 {
+	return int64(s.pos)
+}
+
+// Pos returns the number of bytes consumed so far.
+func (r *SliceRead) Pos() int {
+	return r.slice.pos
+}
+
+// Remaining returns the number of bytes left to read.
+func (r *SliceRead) Remaining() int {
+	return len(r.slice.buf) - r.slice.pos
+}
+
+// HasContent reports whether there are still bytes left to read.
+func (r *SliceRead) HasContent() bool {
+	return r.Remaining() > 0
+}
+
+// ReadByte implements io.ByteReader.
+func (r *SliceRead) ReadByte() (byte, error) {
+	return r.slice.ReadByte()
+}
+
+// Skip moves the read position n bytes forward.
+func (r *SliceRead) Skip(n uint) error {
+	_, err := r.next(uint64(n))
+	return err
+}
+
+// SkipVarUint skips a var uint without decoding it.
+func (r *SliceRead) SkipVarUint() error {
+	_, err := r.ReadVarUint()
+	return err
+}
+
+// SkipVarInt skips a var int without decoding it.
+func (r *SliceRead) SkipVarInt() error {
+	_, _, err := r.ReadVarIntWithSign()
+	return err
+}
+
+// SkipVarUint8Array skips a length prefixed byte array, or a var string.
+// Nothing is copied, so only the length limit of byte arrays applies.
+func (r *SliceRead) SkipVarUint8Array() error {
+	_, err := r.ReadVarUint8Array()
+	return err
+}
+
+// SkipAny skips an any value including all of its nested values, without
+// allocating them.
+func (r *SliceRead) SkipAny() error {
+	return r.skipAny(0)
+}
+
+func (r *SliceRead) skipAny(depth int) error {
+	offset := r.Offset()
+	t, err := r.ReadUint8()
+	if err != nil {
+		return err
+	}
+	switch t {
+	case 127, 126, 121, 120:
+		return nil
+	case 125:
+		return r.SkipVarInt()
+	case 124:
+		return r.Skip(4)
+	case 123, 122:
+		return r.Skip(8)
+	case 119, 116:
+		return r.SkipVarUint8Array()
+	case 118:
+		len, err := r.readCount(depth + 1)
+		if err != nil {
+			return err
+		}
+		for range len {
+			if err := r.SkipVarUint8Array(); err != nil {
+				return err
+			}
+			if err := r.skipAny(depth + 1); err != nil {
+				return err
+			}
+		}
+		return nil
+	case 117:
+		len, err := r.readCount(depth + 1)
+		if err != nil {
+			return err
+		}
+		for range len {
+			if err := r.skipAny(depth + 1); err != nil {
+				return err
+			}
+		}
+		return nil
 	default:
-		return nil, fmt.Errorf("unknown any type: %v", t)
+		return r.fail(offset, fmt.Errorf("lib0: unknown any type %v", t), "")
 	}
 }
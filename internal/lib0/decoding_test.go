@@ -3,8 +3,10 @@ package lib0_test
 import (
 	"bytes"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"testing"
+	"testing/iotest"
 
 	"gotest.tools/assert"
 	"riguz.com/ygo/internal/lib0"
@@ -326,7 +328,7 @@ func TestRead_anyBasic(t *testing.T) {
 			r := lib0.NewBufferRead(bytes.NewBuffer(buf))
 			value, err := r.ReadAny()
 			assert.NilError(t, err)
-			assert.Equal(t, tt.expected, value)
+			assert.Equal(t, tt.expected, value.ToGo())
 		})
 	}
 }
@@ -336,7 +338,7 @@ func TestRead_anyByteArray(t *testing.T) {
 	r := lib0.NewBufferRead(bytes.NewBuffer(buf))
 	value, err := r.ReadAny()
 	assert.NilError(t, err)
-	arr, ok := value.([]uint8)
+	arr, ok := value.ToGo().([]uint8)
 	assert.Equal(t, true, ok)
 	assert.Equal(t, hex.EncodeToString([]uint8{0x2a, 0x3b, 0x4c, 0x9d}), hex.EncodeToString(arr))
 }
@@ -346,7 +348,7 @@ func TestRead_anyMap(t *testing.T) {
 	r := lib0.NewBufferRead(bytes.NewBuffer(buf))
 	value, err := r.ReadAny()
 	assert.NilError(t, err)
-	obj, ok := value.(map[string]any)
+	obj, ok := value.ToGo().(map[string]any)
 	assert.Equal(t, true, ok)
 	assert.Equal(t, 0, len(obj))
 
@@ -354,7 +356,7 @@ func TestRead_anyMap(t *testing.T) {
 	r = lib0.NewBufferRead(bytes.NewBuffer(buf))
 	value, err = r.ReadAny()
 	assert.NilError(t, err)
-	obj, ok = value.(map[string]any)
+	obj, ok = value.ToGo().(map[string]any)
 	assert.Equal(t, true, ok)
 	assert.Equal(t, "J. Mes", obj["name"])
 	assert.Equal(t, int64(18), obj["age"])
@@ -365,9 +367,254 @@ func TestRead_anyAnyArray(t *testing.T) {
 	r := lib0.NewBufferRead(bytes.NewBuffer(buf))
 	value, err := r.ReadAny()
 	assert.NilError(t, err)
-	arr, ok := value.([]any)
+	arr, ok := value.ToGo().([]any)
 	assert.Equal(t, true, ok)
 	assert.Equal(t, lib0.Undefined{}, arr[0])
 	assert.Equal(t, int64(255), arr[1])
 	assert.Equal(t, float32(-2147483648), arr[2])
 }
+
+func TestRead_uint8arrayShortReads(t *testing.T) {
+	buf, _ := hex.DecodeString("3f4c5b2a")
+	r := lib0.NewBufferRead(iotest.OneByteReader(bytes.NewBuffer(buf)))
+	arr, err := r.ReadUint8Array(4)
+	assert.NilError(t, err)
+	assert.Equal(t, "3f4c5b2a", hex.EncodeToString(arr))
+
+	r = lib0.NewBufferRead(iotest.OneByteReader(bytes.NewBuffer(buf)))
+	_, err = r.ReadUint8Array(5)
+	assert.Assert(t, errors.Is(err, lib0.ErrUnexpectedEOF))
+}
+
+func TestSliceRead_uint8array(t *testing.T) {
+	var tests = []struct {
+		len         uint
+		expectedErr error
+		expected    string
+	}{
+		{0, nil, ""},
+		{1, nil, "3f"},
+		{4, nil, "3f4c5b2a"},
+		{5, lib0.ErrUnexpectedEOF, ""},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("read uint8array:%d", tt.len), func(t *testing.T) {
+			buf, _ := hex.DecodeString("3f4c5b2a")
+			r := lib0.NewSliceRead(buf)
+			arr, err := r.ReadUint8Array(tt.len)
+			assert.Assert(t, errors.Is(err, tt.expectedErr))
+			if err == nil {
+				assert.Equal(t, tt.expected, hex.EncodeToString(arr))
+				assert.Equal(t, int(tt.len), r.Pos())
+				assert.Equal(t, 4-int(tt.len), r.Remaining())
+			}
+		})
+	}
+
+	r := lib0.NewSliceRead([]uint8{})
+	_, err := r.ReadUint8Array(1)
+	assert.Assert(t, errors.Is(err, lib0.ErrUnexpectedEOF))
+}
+
+func TestSliceRead_zeroCopy(t *testing.T) {
+	buf, _ := hex.DecodeString("03010203")
+	r := lib0.NewSliceRead(buf)
+	arr, err := r.ReadVarUint8Array()
+	assert.NilError(t, err)
+	assert.Equal(t, false, r.HasContent())
+
+	buf[1] = 0xff
+	assert.Equal(t, uint8(0xff), arr[0])
+	// appending must not overwrite whatever follows in the buffer
+	assert.Equal(t, 3, cap(arr))
+}
+
+// every value BufferRead is able to read must be read the same by SliceRead
+func TestSliceRead_sameAsBufferRead(t *testing.T) {
+	var tests = []string{
+		"7f", "7e", "7d00", "7d41", "7dbfffffff0f", "7a8000000000000000",
+		"7c3fffffff", "7b7fefffffffffffff", "78", "79",
+		"770c48656c6c6f20776f726c6421", "74042a3b4c9d", "7600",
+		"7602046e616d6577064a2e204d6573036167657d12", "75037f7dbf037ccf000000",
+	}
+	for _, tt := range tests {
+		t.Run(tt, func(t *testing.T) {
+			buf, _ := hex.DecodeString(tt)
+			r := lib0.NewBufferRead(bytes.NewBuffer(buf))
+			expected, err := r.ReadAny()
+			assert.NilError(t, err)
+
+			s := lib0.NewSliceRead(buf)
+			value, err := s.ReadAny()
+			assert.NilError(t, err)
+			assert.DeepEqual(t, expected, value)
+			assert.Equal(t, 0, s.Remaining())
+
+			s = lib0.NewSliceRead(buf)
+			assert.NilError(t, s.SkipAny())
+			assert.Equal(t, len(buf), s.Pos())
+		})
+	}
+}
+
+func TestSliceRead_numbers(t *testing.T) {
+	buf, _ := hex.DecodeString("0100" + "01000000" + "00000001" + "0000000000000001" +
+		"3fffffff" + "7fefffffffffffff" + "ffffffffffffffff" + "ff01" + "c101")
+	r := lib0.NewSliceRead(buf)
+	u16, err := r.ReadUint16()
+	assert.NilError(t, err)
+	assert.Equal(t, uint16(1), u16)
+	u32, err := r.ReadUint32()
+	assert.NilError(t, err)
+	assert.Equal(t, uint32(1), u32)
+	u32, err = r.ReadUint32BigEndian()
+	assert.NilError(t, err)
+	assert.Equal(t, uint32(1), u32)
+	u64, err := r.ReadUint64()
+	assert.NilError(t, err)
+	assert.Equal(t, uint64(1), u64)
+	f32, err := r.ReadFloat32()
+	assert.NilError(t, err)
+	assert.Equal(t, float32(1.9999998807907104), f32)
+	f64, err := r.ReadFloat64()
+	assert.NilError(t, err)
+	assert.Equal(t, float64(1.7976931348623157e+308), f64)
+	i64, err := r.ReadInt64()
+	assert.NilError(t, err)
+	assert.Equal(t, int64(-1), i64)
+	vu, err := r.ReadVarUint()
+	assert.NilError(t, err)
+	assert.Equal(t, uint64(255), vu)
+	vi, err := r.ReadVarInt()
+	assert.NilError(t, err)
+	assert.Equal(t, int64(-65), vi)
+
+	_, err = r.ReadVarUint()
+	assert.Assert(t, errors.Is(err, lib0.ErrUnexpectedEOF))
+	r = lib0.NewSliceRead([]uint8{0xff})
+	_, err = r.ReadVarInt()
+	assert.Assert(t, errors.Is(err, lib0.ErrUnexpectedEOF))
+}
+
+func TestSliceRead_skip(t *testing.T) {
+	buf, _ := hex.DecodeString("ff01" + "c101" + "0548656c6c6f" + "2a")
+	r := lib0.NewSliceRead(buf)
+	assert.NilError(t, r.SkipVarUint())
+	assert.NilError(t, r.SkipVarInt())
+	assert.NilError(t, r.SkipVarUint8Array())
+	assert.Equal(t, 1, r.Remaining())
+	assert.NilError(t, r.Skip(1))
+	assert.Assert(t, errors.Is(r.Skip(1), lib0.ErrUnexpectedEOF))
+
+	r = lib0.NewSliceRead([]uint8{0x05, 0x01})
+	assert.Assert(t, errors.Is(r.SkipVarUint8Array(), lib0.ErrUnexpectedEOF))
+	r = lib0.NewSliceRead([]uint8{0x70})
+	assert.ErrorContains(t, r.SkipAny(), "unknown any type")
+}
+
+func TestRead_decodeErrors(t *testing.T) {
+	var tests = []struct {
+		name     string
+		hex      string
+		limits   lib0.Limits
+		read     func(r lib0.Read) error
+		expected error
+		offset   int64
+	}{
+		{"eof in uint32", "0a0b", lib0.DefaultLimits, func(r lib0.Read) error {
+			_, err := r.ReadUint32()
+			return err
+		}, lib0.ErrUnexpectedEOF, 0},
+		{"eof in var string", "01610561", lib0.DefaultLimits, func(r lib0.Read) error {
+			r.ReadVarString()
+			_, err := r.ReadVarString()
+			return err
+		}, lib0.ErrUnexpectedEOF, 3},
+		{"var uint overflow", "0080808080808080808002", lib0.DefaultLimits, func(r lib0.Read) error {
+			r.ReadUint8()
+			_, err := r.ReadVarUint()
+			return err
+		}, lib0.ErrVarIntOverflow, 1},
+		{"var uint too long", "8080808080808080808000", lib0.DefaultLimits, func(r lib0.Read) error {
+			_, err := r.ReadVarUint()
+			return err
+		}, lib0.ErrVarIntOverflow, 0},
+		{"var int overflow", "c080808080808080808001", lib0.DefaultLimits, func(r lib0.Read) error {
+			_, err := r.ReadVarInt()
+			return err
+		}, lib0.ErrVarIntOverflow, 0},
+		{"buffer length", "0003010203", lib0.Limits{MaxBufferLen: 2}, func(r lib0.Read) error {
+			r.ReadUint8()
+			_, err := r.ReadVarUint8Array()
+			return err
+		}, lib0.ErrLimitExceeded, 1},
+		{"fixed buffer length", "010203", lib0.Limits{MaxBufferLen: 2}, func(r lib0.Read) error {
+			_, err := r.ReadUint8Array(3)
+			return err
+		}, lib0.ErrLimitExceeded, 0},
+		{"forged buffer length", "ffffffff0f00", lib0.DefaultLimits, func(r lib0.Read) error {
+			_, err := r.ReadVarUint8Array()
+			return err
+		}, lib0.ErrLimitExceeded, 0},
+		{"string length", "770361626364", lib0.Limits{MaxStringLen: 2}, func(r lib0.Read) error {
+			_, err := r.ReadAny()
+			return err
+		}, lib0.ErrLimitExceeded, 1},
+		{"element count", "7501750300", lib0.Limits{MaxElements: 2}, func(r lib0.Read) error {
+			_, err := r.ReadAny()
+			return err
+		}, lib0.ErrLimitExceeded, 3},
+		{"any depth", "750175017501750178", lib0.Limits{MaxAnyDepth: 3}, func(r lib0.Read) error {
+			_, err := r.ReadAny()
+			return err
+		}, lib0.ErrLimitExceeded, 7},
+		{"any depth in object", "760101617601016276010163", lib0.Limits{MaxAnyDepth: 2}, func(r lib0.Read) error {
+			_, err := r.ReadAny()
+			return err
+		}, lib0.ErrLimitExceeded, 9},
+		{"within limits", "750175017501750178", lib0.Limits{MaxAnyDepth: 4}, func(r lib0.Read) error {
+			_, err := r.ReadAny()
+			return err
+		}, nil, 0},
+	}
+	for _, tt := range tests {
+		buf, _ := hex.DecodeString(tt.hex)
+		readers := map[string]func() lib0.Read{
+			"buffer": func() lib0.Read {
+				r := lib0.NewBufferRead(bytes.NewBuffer(buf))
+				r.SetLimits(tt.limits)
+				return &r
+			},
+			"slice": func() lib0.Read {
+				r := lib0.NewSliceRead(buf)
+				r.SetLimits(tt.limits)
+				return &r
+			},
+		}
+		for kind, newRead := range readers {
+			t.Run(kind+" "+tt.name, func(t *testing.T) {
+				err := tt.read(newRead())
+				if tt.expected == nil {
+					assert.NilError(t, err)
+					return
+				}
+				assert.Assert(t, errors.Is(err, tt.expected), "got %v", err)
+				var decodeErr *lib0.DecodeError
+				assert.Assert(t, errors.As(err, &decodeErr))
+				assert.Equal(t, tt.offset, decodeErr.Offset)
+			})
+		}
+	}
+}
+
+func TestSliceRead_skipLimits(t *testing.T) {
+	buf, _ := hex.DecodeString("750175017501750178")
+	r := lib0.NewSliceRead(buf)
+	r.SetLimits(lib0.Limits{MaxAnyDepth: 3})
+	err := r.SkipAny()
+	assert.Assert(t, errors.Is(err, lib0.ErrLimitExceeded))
+
+	r = lib0.NewSliceRead(buf)
+	assert.Equal(t, lib0.DefaultLimits, r.Limits())
+	assert.NilError(t, r.SkipAny())
+}
@@ -1,11 +1,14 @@
 package lib0
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
+	"io"
 	"math"
-	"reflect"
+	"sync"
 )
 
 type Undefined struct{}
@@ -33,18 +36,38 @@ type Write interface {
 	WriteVarUint8Array(buf []uint8) error
 	WriteVarString(str *string) error
 	WriteAny(a any) error
-	ToBytes() []byte
+	Flush() error
 }
 
 var _ Write = &BufferWrite{}
+var _ Write = &StreamWrite{}
 
+// byteWriter is where a writer puts its bytes, both bytes.Buffer and
+// bufio.Writer implement it.
+type byteWriter interface {
+	io.Writer
+	io.ByteWriter
+}
+
+// writer implements the encoding shared by BufferWrite and StreamWrite.
+type writer struct {
+	out byteWriter
+	// scratch is used to encode fixed size numbers and var ints without
+	// allocating
+	scratch [binary.MaxVarintLen64]byte
+}
+
+// BufferWrite accumulates everything in memory, see ToBytes.
 type BufferWrite struct {
+	writer
 	buffer *bytes.Buffer
 }
 
 func NewBufferWrite() BufferWrite {
+	buffer := &bytes.Buffer{}
 	writer := BufferWrite{
-		buffer: &bytes.Buffer{},
+		writer: writer{out: buffer},
+		buffer: buffer,
 	}
 	return writer
 }
@@ -53,87 +76,165 @@ func (w *BufferWrite) ToBytes() []byte {
 	return w.buffer.Bytes()
 }
 
-func (w *BufferWrite) WriteUint8Array(buf []uint8) error {
-	_, err := w.buffer.Write(buf)
+// Flush does nothing, everything is kept in memory anyway.
+func (w *BufferWrite) Flush() error {
+	return nil
+}
+
+const streamBufferSize = 32 * 1024
+
+var streamBufferPool = sync.Pool{
+	New: func() any {
+		return bufio.NewWriterSize(nil, streamBufferSize)
+	},
+}
+
+var ErrWriterClosed = errors.New("lib0: write to closed writer")
+
+type closedWriter struct{}
+
+func (closedWriter) Write([]byte) (int, error) { return 0, ErrWriterClosed }
+func (closedWriter) WriteByte(byte) error      { return ErrWriterClosed }
+
+// StreamWrite writes through to an io.Writer. Writes are buffered in a
+// pooled buffer, so Flush must be called once everything is written, and
+// Close to give the buffer back to the pool.
+type StreamWrite struct {
+	writer
+	stream *bufio.Writer
+}
+
+func NewStreamWrite(w io.Writer) StreamWrite {
+	stream := streamBufferPool.Get().(*bufio.Writer)
+	stream.Reset(w)
+	return StreamWrite{
+		writer: writer{out: stream},
+		stream: stream,
+	}
+}
+
+// ToBytes always returns nil, the bytes have been passed on to the
+// underlying io.Writer.
+func (w *StreamWrite) ToBytes() []byte {
+	return nil
+}
+
+// Flush writes all buffered bytes to the underlying io.Writer.
+func (w *StreamWrite) Flush() error {
+	if w.stream == nil {
+		return ErrWriterClosed
+	}
+	return w.stream.Flush()
+}
+
+// Close flushes the buffered bytes and returns the buffer to the pool, the
+// writer can't be used anymore afterwards. The underlying io.Writer is not
+// closed.
+func (w *StreamWrite) Close() error {
+	if w.stream == nil {
+		return nil
+	}
+	err := w.stream.Flush()
+	w.stream.Reset(nil)
+	streamBufferPool.Put(w.stream)
+	w.stream = nil
+	w.out = closedWriter{}
 	return err
 }
 
-func (w *BufferWrite) WriteUint8(value uint8) error {
-	_, err := w.buffer.Write([]byte{value})
+func (w *writer) WriteUint8Array(buf []uint8) error {
+	_, err := w.out.Write(buf)
 	return err
 }
 
-func (w *BufferWrite) WriteUint16(num uint16) error {
-	return binary.Write(w.buffer, binary.LittleEndian, num)
+func (w *writer) WriteUint8(value uint8) error {
+	return w.out.WriteByte(value)
+}
+
+func (w *writer) WriteUint16(num uint16) error {
+	binary.LittleEndian.PutUint16(w.scratch[:], num)
+	return w.WriteUint8Array(w.scratch[:2])
 }
 
-func (w *BufferWrite) WriteUint32(num uint32) error {
-	return binary.Write(w.buffer, binary.LittleEndian, num)
+func (w *writer) WriteUint32(num uint32) error {
+	binary.LittleEndian.PutUint32(w.scratch[:], num)
+	return w.WriteUint8Array(w.scratch[:4])
 }
 
-func (w *BufferWrite) WriteUint32BigEndian(num uint32) error {
-	return binary.Write(w.buffer, binary.BigEndian, num)
+func (w *writer) WriteUint32BigEndian(num uint32) error {
+	binary.BigEndian.PutUint32(w.scratch[:], num)
+	return w.WriteUint8Array(w.scratch[:4])
 }
 
-func (w *BufferWrite) WriteUint64(num uint64) error {
-	return binary.Write(w.buffer, binary.BigEndian, num)
+func (w *writer) WriteUint64(num uint64) error {
+	binary.BigEndian.PutUint64(w.scratch[:], num)
+	return w.WriteUint8Array(w.scratch[:8])
 }
 
-func (w *BufferWrite) WriteInt64(num int64) error {
-	return binary.Write(w.buffer, binary.BigEndian, num)
+func (w *writer) WriteInt64(num int64) error {
+	return w.WriteUint64(uint64(num))
 }
 
-func (w *BufferWrite) WriteFloat32(num float32) error {
-	return binary.Write(w.buffer, binary.BigEndian, num)
+func (w *writer) WriteFloat32(num float32) error {
+	return w.WriteUint32BigEndian(math.Float32bits(num))
 }
 
-func (w *BufferWrite) WriteFloat64(num float64) error {
-	return binary.Write(w.buffer, binary.BigEndian, num)
+func (w *writer) WriteFloat64(num float64) error {
+	return w.WriteUint64(math.Float64bits(num))
 }
 
-func (w *BufferWrite) WriteVarUint(num uint) error {
+func (w *writer) WriteVarUint(num uint) error {
 	return w.WriteVarUint64(uint64(num))
 }
 
-func (w *BufferWrite) WriteVarUint8(num uint8) error {
+func (w *writer) WriteVarUint8(num uint8) error {
 	return w.WriteVarUint64(uint64(num))
 }
 
-func (w *BufferWrite) WriteVarUint16(num uint16) error {
+func (w *writer) WriteVarUint16(num uint16) error {
 	return w.WriteVarUint64(uint64(num))
 }
 
-func (w *BufferWrite) WriteVarUint32(num uint32) error {
+func (w *writer) WriteVarUint32(num uint32) error {
 	return w.WriteVarUint64(uint64(num))
 }
 
-func (w *BufferWrite) WriteVarUint64(num uint64) error {
-	buf := make([]byte, binary.MaxVarintLen64)
-	n := binary.PutUvarint(buf, uint64(num))
-	return w.WriteUint8Array(buf[:n])
+func (w *writer) WriteVarUint64(num uint64) error {
+	n := binary.PutUvarint(w.scratch[:], num)
+	return w.WriteUint8Array(w.scratch[:n])
 }
 
-func (w *BufferWrite) WriteVarInt(num int) error {
+func (w *writer) WriteVarInt(num int) error {
 	return w.WriteVarInt64(int64(num))
 }
 
-func (w *BufferWrite) WriteVarInt8(num int8) error {
+func (w *writer) WriteVarInt8(num int8) error {
 	return w.WriteVarInt64(int64(num))
 }
 
-func (w *BufferWrite) WriteVarInt16(num int16) error {
+func (w *writer) WriteVarInt16(num int16) error {
 	return w.WriteVarInt64(int64(num))
 }
 
-func (w *BufferWrite) WriteVarInt32(num int32) error {
+func (w *writer) WriteVarInt32(num int32) error {
 	return w.WriteVarInt64(int64(num))
 }
 
-func (w *BufferWrite) WriteVarInt64(num int64) error {
+func (w *writer) WriteVarInt64(num int64) error {
 	isNegative := num < 0
 	if isNegative {
 		num = -num
 	}
+	return w.WriteVarIntWithSign(num, isNegative)
+}
+
+// WriteVarIntWithSign writes the absolute value num with an explicit sign
+// bit. This is how lib0 encodes a negative zero, which is used as a marker
+// in its RLE encodings.
+func (w *writer) WriteVarIntWithSign(num int64, isNegative bool) error {
+	if num < 0 {
+		num = -num
+	}
 	firstByte := uint8(int64(0b0011_1111) & num)
 	if num > int64(0b0011_1111) {
 		firstByte |= uint8(0b1000_0000) // continue reading or not
@@ -141,9 +242,9 @@ func (w *BufferWrite) WriteVarInt64(num int64) error {
 	if isNegative {
 		firstByte |= uint8(0b0100_0000) // is negative or not
 	}
-	if err := w.WriteUint8(firstByte); err != nil {
-		return err
-	}
+	n := 0
+	w.scratch[n] = firstByte
+	n++
 
 	num >>= 6
 	for num > 0 {
@@ -152,160 +253,109 @@ func (w *BufferWrite) WriteVarInt64(num int64) error {
 			b |= uint8(0b1000_0000)
 		}
 		b |= uint8(int64(0b0111_1111) & num)
-		if err := w.WriteUint8(b); err != nil {
-			return err
-		}
+		w.scratch[n] = b
+		n++
 		num >>= 7
 	}
-	return nil
+	return w.WriteUint8Array(w.scratch[:n])
 }
 
-func (w *BufferWrite) WriteVarUint8Array(buf []uint8) error {
+func (w *writer) WriteVarUint8Array(buf []uint8) error {
 	if err := w.WriteVarUint(uint(len(buf))); err != nil {
 		return err
 	}
 	return w.WriteUint8Array(buf)
 }
 
-func (w *BufferWrite) WriteVarString(str *string) error {
-	return w.WriteVarUint8Array([]byte(*str))
-}
-
-func (w *BufferWrite) WriteAny(a any) error {
-	switch t := a.(type) {
-	case string:
-		return w.writeString(t)
-	case float32:
-		return w.writeFloat32(t)
-	case float64:
-		return w.writeFloat64(t)
-	case int8:
-		return w.writeVarInt(int32(t))
-	case int16:
-		return w.writeVarInt(int32(t))
-	case int32:
-		return w.writeVarInt(t)
-	case int:
-		if t <= math.MaxInt32 && t >= math.MinInt32 {
-			return w.writeVarInt(int32(t))
-		} else {
-			return w.writeBigInt(int64(t))
-		}
-	case int64:
-		if t <= math.MaxInt32 && t >= math.MinInt32 {
-			return w.writeVarInt(int32(t))
-		} else {
-			return w.writeBigInt(int64(t))
-		}
-	case bool:
-		return w.writeBool(t)
-	case []uint8:
-		return w.writeUint8Array(t)
-	case []any:
-		return w.writeArray(t)
-	case map[string]any:
-		return w.writeObject(t)
-	case nil:
-		return w.WriteUint8(126)
-	case Undefined:
-		return w.WriteUint8(127)
-	default:
-		return fmt.Errorf("unrecognized any payload type:%v", reflect.TypeOf(a))
-	}
-}
-
-func (w *BufferWrite) writeVarInt(num int32) error {
-	if err := w.WriteUint8(125); err != nil {
+func (w *writer) WriteVarString(str *string) error {
+	if err := w.WriteVarUint(uint(len(*str))); err != nil {
 		return err
 	}
-	return w.WriteVarInt32(num)
+	_, err := io.WriteString(w.out, *str)
+	return err
 }
 
-func (w *BufferWrite) writeFloat32(num float32) error {
-	if err := w.WriteUint8(124); err != nil {
+// WriteAny writes either an Any or a plain Go value, which is converted with
+// AnyFromGo first.
+func (w *writer) WriteAny(a any) error {
+	value, err := AnyFromGo(a)
+	if err != nil {
 		return err
 	}
-	return w.WriteFloat32(num)
+	return w.writeAny(value)
 }
 
-var F64_MAX_SAFE_INTEGER float64 = math.Pow(2, 53) - 1
-var F64_MIN_SAFE_INTEGER float64 = -F64_MAX_SAFE_INTEGER
-
-func (w *BufferWrite) writeFloat64(num float64) error {
-	truncated := math.Trunc(num)
-	if truncated == num &&
-		truncated <= F64_MAX_SAFE_INTEGER &&
-		truncated >= F64_MIN_SAFE_INTEGER {
-		return w.WriteAny(int64(truncated))
-	} else if float64(float32(num)) == num {
-		return w.writeFloat32(float32(num))
-	} else {
+func (w *writer) writeAny(a Any) error {
+	switch a.Kind {
+	case ANY_UNDEFINED:
+		return w.WriteUint8(127)
+	case ANY_NULL:
+		return w.WriteUint8(126)
+	case ANY_INTEGER:
+		if err := w.WriteUint8(125); err != nil {
+			return err
+		}
+		return w.WriteVarInt64(a.Int)
+	case ANY_FLOAT32:
+		if err := w.WriteUint8(124); err != nil {
+			return err
+		}
+		return w.WriteFloat32(float32(a.Float))
+	case ANY_FLOAT64:
 		if err := w.WriteUint8(123); err != nil {
 			return err
 		}
-		return w.WriteFloat64(num)
-	}
-}
-
-func (w *BufferWrite) writeBigInt(num int64) error {
-	if err := w.WriteUint8(122); err != nil {
-		return err
-	}
-	return w.WriteInt64(num)
-}
-
-func (w *BufferWrite) writeBool(val bool) error {
-	var t uint8 = 121
-	if val {
-		t = 120
-	}
-
-	return w.WriteUint8(t)
-}
-
-func (w *BufferWrite) writeString(str string) error {
-	if err := w.WriteUint8(119); err != nil {
-		return err
-	}
-	return w.WriteVarString(&str)
-}
-
-func (w *BufferWrite) writeObject(obj map[string]any) error {
-	if err := w.WriteUint8(118); err != nil {
-		return err
-	}
-	if err := w.WriteVarUint(uint(len(obj))); err != nil {
-		return err
-	}
-	for k, v := range obj {
-		if err := w.WriteVarString(&k); err != nil {
+		return w.WriteFloat64(a.Float)
+	case ANY_BIGINT:
+		if err := w.WriteUint8(122); err != nil {
+			return err
+		}
+		return w.WriteInt64(a.Int)
+	case ANY_BOOL:
+		if a.Bool {
+			return w.WriteUint8(120)
+		}
+		return w.WriteUint8(121)
+	case ANY_STRING:
+		if err := w.WriteUint8(119); err != nil {
 			return err
 		}
-		if err := w.WriteAny(v); err != nil {
+		return w.WriteVarString(&a.Str)
+	case ANY_OBJECT:
+		if err := w.WriteUint8(118); err != nil {
 			return err
 		}
-	}
-	return nil
-}
-
-func (w *BufferWrite) writeArray(arr []any) error {
-	if err := w.WriteUint8(117); err != nil {
-		return err
-	}
-	if err := w.WriteVarUint(uint(len(arr))); err != nil {
-		return err
-	}
-	for _, a := range arr {
-		if err := w.WriteAny(a); err != nil {
+		if err := w.WriteVarUint(uint(len(a.Object))); err != nil {
 			return err
 		}
+		for _, f := range a.Object {
+			if err := w.WriteVarString(&f.Key); err != nil {
+				return err
+			}
+			if err := w.writeAny(f.Value); err != nil {
+				return err
+			}
+		}
+		return nil
+	case ANY_ARRAY:
+		if err := w.WriteUint8(117); err != nil {
+			return err
+		}
+		if err := w.WriteVarUint(uint(len(a.Array))); err != nil {
+			return err
+		}
+		for _, v := range a.Array {
+			if err := w.writeAny(v); err != nil {
+				return err
+			}
+		}
+		return nil
+	case ANY_BUFFER:
+		if err := w.WriteUint8(116); err != nil {
+			return err
+		}
+		return w.WriteVarUint8Array(a.Buffer)
+	default:
+		return fmt.Errorf("unknown any kind: %v", a.Kind)
 	}
-	return nil
-}
-
-func (w *BufferWrite) writeUint8Array(arr []byte) error {
-	if err := w.WriteUint8(116); err != nil {
-		return err
-	}
-	return w.WriteVarUint8Array(arr)
 }
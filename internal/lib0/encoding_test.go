@@ -1,7 +1,9 @@
 package lib0_test
 
 import (
+	"bytes"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"testing"
 
@@ -505,10 +507,15 @@ func TestWrite_any(t *testing.T) {
 		{"Hello world!", "770c48656c6c6f20776f726c6421"},
 		{[]uint8{0x2a, 0x3b, 0x4c, 0x9d}, "74042a3b4c9d"},
 		{map[string]any{}, "7600"},
+		// map fields are written in key order
 		{map[string]any{
 			"name": "J. Mes",
 			"age":  18,
-		}, "7602046e616d6577064a2e204d6573036167657d12"},
+		}, "7602036167657d12046e616d6577064a2e204d6573"},
+		{lib0.NewAnyObject(
+			lib0.AnyField{Key: "name", Value: lib0.NewAnyString("J. Mes")},
+			lib0.AnyField{Key: "age", Value: lib0.NewAnyInteger(18)},
+		), "7602046e616d6577064a2e204d6573036167657d12"},
 	}
 	for _, tt := range tests {
 		t.Run(fmt.Sprintf("write var any:%v = %v", tt.a, tt.expected), func(t *testing.T) {
@@ -544,3 +551,77 @@ func TestWrite_anyNumber(t *testing.T) {
 		})
 	}
 }
+
+func writeAll(t *testing.T, w lib0.Write) {
+	str := "Hello,中国！"
+	assert.NilError(t, w.WriteUint8(0x2a))
+	assert.NilError(t, w.WriteUint16(0x1234))
+	assert.NilError(t, w.WriteUint32(0x12345678))
+	assert.NilError(t, w.WriteUint32BigEndian(0x12345678))
+	assert.NilError(t, w.WriteUint64(0x123456789abcdef0))
+	assert.NilError(t, w.WriteInt64(-2))
+	assert.NilError(t, w.WriteFloat32(1.5))
+	assert.NilError(t, w.WriteFloat64(-0.1))
+	assert.NilError(t, w.WriteVarUint64(1<<60))
+	assert.NilError(t, w.WriteVarInt64(-1<<40))
+	assert.NilError(t, w.WriteVarString(&str))
+	assert.NilError(t, w.WriteVarUint8Array([]uint8{1, 2, 3}))
+	assert.NilError(t, w.WriteAny(map[string]any{"a": []any{1, "b", nil}}))
+}
+
+func TestStreamWrite_sameAsBufferWrite(t *testing.T) {
+	expected := lib0.NewBufferWrite()
+	writeAll(t, &expected)
+
+	out := &bytes.Buffer{}
+	w := lib0.NewStreamWrite(out)
+	writeAll(t, &w)
+	// everything is buffered until flushed
+	assert.Equal(t, 0, out.Len())
+	assert.Assert(t, w.ToBytes() == nil)
+	assert.NilError(t, w.Flush())
+	assert.Equal(t, hex.EncodeToString(expected.ToBytes()), hex.EncodeToString(out.Bytes()))
+
+	// the pooled buffer can be reused after Close
+	assert.NilError(t, w.Close())
+	assert.NilError(t, w.Close())
+	assert.Assert(t, errors.Is(w.WriteUint8(1), lib0.ErrWriterClosed))
+	assert.Assert(t, errors.Is(w.Flush(), lib0.ErrWriterClosed))
+
+	out = &bytes.Buffer{}
+	w = lib0.NewStreamWrite(out)
+	writeAll(t, &w)
+	assert.NilError(t, w.Close())
+	assert.Equal(t, hex.EncodeToString(expected.ToBytes()), hex.EncodeToString(out.Bytes()))
+}
+
+func TestStreamWrite_large(t *testing.T) {
+	buf := bytes.Repeat([]uint8{0xab}, 100*1024)
+	out := &bytes.Buffer{}
+	w := lib0.NewStreamWrite(out)
+	assert.NilError(t, w.WriteVarUint8Array(buf))
+	// writes larger than the buffer go straight through
+	assert.Assert(t, out.Len() >= len(buf))
+	assert.NilError(t, w.Close())
+	assert.Equal(t, len(buf)+3, out.Len())
+}
+
+type failingWriter struct {
+	err error
+}
+
+func (w failingWriter) Write([]byte) (int, error) {
+	return 0, w.err
+}
+
+func TestStreamWrite_error(t *testing.T) {
+	failure := errors.New("disk full")
+	w := lib0.NewStreamWrite(failingWriter{failure})
+	defer w.Close()
+	assert.NilError(t, w.WriteUint8(1))
+	assert.Assert(t, errors.Is(w.Flush(), failure))
+
+	w2 := lib0.NewStreamWrite(failingWriter{failure})
+	defer w2.Close()
+	assert.Assert(t, errors.Is(w2.WriteUint8Array(bytes.Repeat([]uint8{1}, 64*1024)), failure))
+}
@@ -0,0 +1,522 @@
+package lib0
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Marshal encodes v with the lib0 any encoding, see MarshalAny for how Go
+// values are mapped.
+func Marshal(v any) ([]byte, error) {
+	value, err := MarshalAny(v)
+	if err != nil {
+		return nil, err
+	}
+	w := NewBufferWrite()
+	if err := w.writeAny(value); err != nil {
+		return nil, err
+	}
+	return w.ToBytes(), nil
+}
+
+// Unmarshal decodes a lib0 any value into the value v points to, see
+// UnmarshalAny for how values are mapped.
+func Unmarshal(data []byte, v any) error {
+	r := NewSliceRead(data)
+	value, err := r.ReadAny()
+	if err != nil {
+		return err
+	}
+	if r.HasContent() {
+		return fmt.Errorf("lib0: %v unexpected bytes after any value", r.Remaining())
+	}
+	return UnmarshalAny(value, v)
+}
+
+// MarshalAny converts a Go value into an Any, much like encoding/json does:
+//   - structs become objects with their fields in declaration order. The
+//     field name can be changed with a `lib0:"name"` tag, `lib0:",omitempty"`
+//     leaves out empty values and `lib0:"-"` skips the field. The fields of
+//     embedded structs are inlined.
+//   - maps become objects sorted by key, keys must be strings or integers.
+//   - []byte and byte arrays become buffers, other slices and arrays become
+//     arrays.
+//   - time.Time becomes an RFC 3339 string.
+//   - nil pointers, interfaces, maps and slices become null.
+//
+// Numbers and other plain values are mapped like AnyFromGo maps them.
+func MarshalAny(v any) (Any, error) {
+	return marshalValue(reflect.ValueOf(v))
+}
+
+var anyType = reflect.TypeFor[Any]()
+var undefinedType = reflect.TypeFor[Undefined]()
+var timeType = reflect.TypeFor[time.Time]()
+
+func marshalValue(v reflect.Value) (Any, error) {
+	if !v.IsValid() {
+		return NewAnyNull(), nil
+	}
+	switch v.Type() {
+	case anyType:
+		return v.Interface().(Any), nil
+	case undefinedType:
+		return Any{}, nil
+	case timeType:
+		return NewAnyString(v.Interface().(time.Time).Format(time.RFC3339Nano)), nil
+	}
+	switch v.Kind() {
+	case reflect.Bool:
+		return NewAnyBool(v.Bool()), nil
+	case reflect.String:
+		return NewAnyString(v.String()), nil
+	case reflect.Int8, reflect.Int16, reflect.Int32:
+		return NewAnyInteger(v.Int()), nil
+	case reflect.Int, reflect.Int64:
+		return anyFromInt64(v.Int()), nil
+	case reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return NewAnyInteger(int64(v.Uint())), nil
+	case reflect.Uint, reflect.Uint64, reflect.Uintptr:
+		if v.Uint() > math.MaxInt64 {
+			return Any{}, fmt.Errorf("lib0: unsupported value %v, exceeds int64", v.Uint())
+		}
+		return anyFromInt64(int64(v.Uint())), nil
+	case reflect.Float32:
+		return NewAnyFloat32(float32(v.Float())), nil
+	case reflect.Float64:
+		return NewAnyNumber(v.Float()), nil
+	case reflect.Pointer, reflect.Interface:
+		if v.IsNil() {
+			return NewAnyNull(), nil
+		}
+		return marshalValue(v.Elem())
+	case reflect.Slice:
+		if v.IsNil() {
+			return NewAnyNull(), nil
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return NewAnyBuffer(v.Bytes()), nil
+		}
+		return marshalArray(v)
+	case reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			buf := make([]uint8, v.Len())
+			reflect.Copy(reflect.ValueOf(buf), v)
+			return NewAnyBuffer(buf), nil
+		}
+		return marshalArray(v)
+	case reflect.Map:
+		if v.IsNil() {
+			return NewAnyNull(), nil
+		}
+		return marshalMap(v)
+	case reflect.Struct:
+		return marshalStruct(v)
+	default:
+		return Any{}, fmt.Errorf("lib0: unsupported type %v", v.Type())
+	}
+}
+
+func marshalArray(v reflect.Value) (Any, error) {
+	arr := make([]Any, v.Len())
+	for i := range arr {
+		value, err := marshalValue(v.Index(i))
+		if err != nil {
+			return Any{}, err
+		}
+		arr[i] = value
+	}
+	return NewAnyArray(arr...), nil
+}
+
+func marshalMap(v reflect.Value) (Any, error) {
+	fields := make([]AnyField, 0, v.Len())
+	iter := v.MapRange()
+	for iter.Next() {
+		key, err := mapKeyString(iter.Key())
+		if err != nil {
+			return Any{}, err
+		}
+		value, err := marshalValue(iter.Value())
+		if err != nil {
+			return Any{}, err
+		}
+		fields = append(fields, AnyField{Key: key, Value: value})
+	}
+	slices.SortFunc(fields, func(a, b AnyField) int {
+		return strings.Compare(a.Key, b.Key)
+	})
+	return NewAnyObject(fields...), nil
+}
+
+func mapKeyString(key reflect.Value) (string, error) {
+	switch key.Kind() {
+	case reflect.String:
+		return key.String(), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(key.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return strconv.FormatUint(key.Uint(), 10), nil
+	default:
+		return "", fmt.Errorf("lib0: unsupported map key type %v", key.Type())
+	}
+}
+
+func marshalStruct(v reflect.Value) (Any, error) {
+	fields := cachedStructFields(v.Type())
+	values := make([]AnyField, 0, len(fields))
+	for _, f := range fields {
+		fv, ok := fieldByIndex(v, f.index)
+		if !ok || (f.omitEmpty && isEmptyValue(fv)) {
+			continue
+		}
+		value, err := marshalValue(fv)
+		if err != nil {
+			return Any{}, err
+		}
+		values = append(values, AnyField{Key: f.name, Value: value})
+	}
+	return NewAnyObject(values...), nil
+}
+
+// fieldByIndex is like reflect.Value.FieldByIndex, but reports nil embedded
+// pointers instead of panicking.
+func fieldByIndex(v reflect.Value, index []int) (reflect.Value, bool) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Pointer {
+			if v.IsNil() {
+				return reflect.Value{}, false
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v, true
+}
+
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64,
+		reflect.Interface, reflect.Pointer:
+		return v.IsZero()
+	}
+	return false
+}
+
+type structField struct {
+	name      string
+	index     []int
+	omitEmpty bool
+}
+
+var structFieldsCache sync.Map
+
+func cachedStructFields(t reflect.Type) []structField {
+	if fields, ok := structFieldsCache.Load(t); ok {
+		return fields.([]structField)
+	}
+	fields, _ := structFieldsCache.LoadOrStore(t, structFields(t, nil))
+	return fields.([]structField)
+}
+
+// structFields lists the fields of t with their tag options, the fields of
+// embedded structs without a tag name are inlined. An outer field hides an
+// inlined field of the same name.
+func structFields(t reflect.Type, index []int) []structField {
+	var fields []structField
+	var inlined []structField
+	for i := range t.NumField() {
+		f := t.Field(i)
+		tag := f.Tag.Get("lib0")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		fieldIndex := append(slices.Clone(index), i)
+		if f.Anonymous && name == "" {
+			ft := f.Type
+			if ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct && ft != timeType {
+				inlined = append(inlined, structFields(ft, fieldIndex)...)
+				continue
+			}
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fields = append(fields, structField{
+			name:      name,
+			index:     fieldIndex,
+			omitEmpty: opts == "omitempty",
+		})
+	}
+	for _, f := range inlined {
+		hidden := slices.ContainsFunc(fields, func(o structField) bool { return o.name == f.name })
+		if !hidden {
+			fields = append(fields, f)
+		}
+	}
+	return fields
+}
+
+// UnmarshalAny stores a into the value v points to. It's the reverse of
+// MarshalAny, and additionally:
+//   - an empty interface receives the value of Any.ToGo, an Any receives a
+//     as it is.
+//   - object fields are matched to struct fields by their exact name, fields
+//     without a match are ignored.
+//   - numbers can be stored in any integer or float type as long as they fit,
+//     integers also accept integral floats.
+//   - time.Time accepts RFC 3339 strings and numbers, which are taken as
+//     milliseconds since the Unix epoch like in JavaScript.
+//   - null and undefined set pointers, interfaces, maps and slices to nil and
+//     leave other values unchanged.
+//
+// Buffers are copied, so v doesn't share memory with a.
+func UnmarshalAny(a Any, v any) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() {
+		return fmt.Errorf("lib0: Unmarshal requires a non-nil pointer, got %v", reflect.TypeOf(v))
+	}
+	return unmarshalValue(a, rv.Elem())
+}
+
+func unmarshalTypeError(a Any, t reflect.Type) error {
+	return fmt.Errorf("lib0: cannot unmarshal %v into Go value of type %v", a.Kind, t)
+}
+
+func unmarshalValue(a Any, v reflect.Value) error {
+	switch v.Type() {
+	case anyType:
+		v.Set(reflect.ValueOf(a))
+		return nil
+	case timeType:
+		return unmarshalTime(a, v)
+	}
+	if a.Kind == ANY_NULL || a.Kind == ANY_UNDEFINED {
+		switch v.Kind() {
+		case reflect.Pointer, reflect.Interface, reflect.Map, reflect.Slice:
+			v.SetZero()
+		}
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.Pointer:
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return unmarshalValue(a, v.Elem())
+	case reflect.Interface:
+		if v.NumMethod() != 0 {
+			return unmarshalTypeError(a, v.Type())
+		}
+		v.Set(reflect.ValueOf(a.ToGo()))
+		return nil
+	case reflect.Bool:
+		if a.Kind != ANY_BOOL {
+			return unmarshalTypeError(a, v.Type())
+		}
+		v.SetBool(a.Bool)
+		return nil
+	case reflect.String:
+		if a.Kind != ANY_STRING {
+			return unmarshalTypeError(a, v.Type())
+		}
+		v.SetString(a.Str)
+		return nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		num, ok := anyToInt64(a)
+		if !ok || v.OverflowInt(num) {
+			return unmarshalTypeError(a, v.Type())
+		}
+		v.SetInt(num)
+		return nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		num, ok := anyToInt64(a)
+		if !ok || num < 0 || v.OverflowUint(uint64(num)) {
+			return unmarshalTypeError(a, v.Type())
+		}
+		v.SetUint(uint64(num))
+		return nil
+	case reflect.Float32, reflect.Float64:
+		var num float64
+		switch {
+		case a.IsNumber():
+			num = a.Number()
+		case a.Kind == ANY_BIGINT:
+			num = float64(a.Int)
+		default:
+			return unmarshalTypeError(a, v.Type())
+		}
+		if v.Kind() == reflect.Float32 && v.OverflowFloat(num) {
+			return unmarshalTypeError(a, v.Type())
+		}
+		v.SetFloat(num)
+		return nil
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 && a.Kind == ANY_BUFFER {
+			buf := reflect.MakeSlice(v.Type(), len(a.Buffer), len(a.Buffer))
+			reflect.Copy(buf, reflect.ValueOf(a.Buffer))
+			v.Set(buf)
+			return nil
+		}
+		if a.Kind != ANY_ARRAY {
+			return unmarshalTypeError(a, v.Type())
+		}
+		arr := reflect.MakeSlice(v.Type(), len(a.Array), len(a.Array))
+		for i, e := range a.Array {
+			if err := unmarshalValue(e, arr.Index(i)); err != nil {
+				return err
+			}
+		}
+		v.Set(arr)
+		return nil
+	case reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 && a.Kind == ANY_BUFFER {
+			v.SetZero()
+			reflect.Copy(v, reflect.ValueOf(a.Buffer))
+			return nil
+		}
+		if a.Kind != ANY_ARRAY {
+			return unmarshalTypeError(a, v.Type())
+		}
+		v.SetZero()
+		for i, e := range a.Array {
+			if i >= v.Len() {
+				break
+			}
+			if err := unmarshalValue(e, v.Index(i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	case reflect.Map:
+		if a.Kind != ANY_OBJECT {
+			return unmarshalTypeError(a, v.Type())
+		}
+		return unmarshalMap(a, v)
+	case reflect.Struct:
+		if a.Kind != ANY_OBJECT {
+			return unmarshalTypeError(a, v.Type())
+		}
+		return unmarshalStruct(a, v)
+	default:
+		return fmt.Errorf("lib0: unsupported type %v", v.Type())
+	}
+}
+
+func anyToInt64(a Any) (int64, bool) {
+	switch a.Kind {
+	case ANY_INTEGER, ANY_BIGINT:
+		return a.Int, true
+	case ANY_FLOAT32, ANY_FLOAT64:
+		if a.Float != math.Trunc(a.Float) || a.Float < math.MinInt64 || a.Float >= math.MaxInt64 {
+			return 0, false
+		}
+		return int64(a.Float), true
+	default:
+		return 0, false
+	}
+}
+
+func unmarshalTime(a Any, v reflect.Value) error {
+	switch {
+	case a.Kind == ANY_STRING:
+		t, err := time.Parse(time.RFC3339Nano, a.Str)
+		if err != nil {
+			return fmt.Errorf("lib0: cannot unmarshal %q into time.Time: %w", a.Str, err)
+		}
+		v.Set(reflect.ValueOf(t))
+		return nil
+	case a.IsNumber() || a.Kind == ANY_BIGINT:
+		var ms float64
+		if a.Kind == ANY_BIGINT {
+			ms = float64(a.Int)
+		} else {
+			ms = a.Number()
+		}
+		v.Set(reflect.ValueOf(time.UnixMilli(0).Add(time.Duration(ms * float64(time.Millisecond)))))
+		return nil
+	case a.Kind == ANY_NULL || a.Kind == ANY_UNDEFINED:
+		return nil
+	default:
+		return unmarshalTypeError(a, v.Type())
+	}
+}
+
+func unmarshalMap(a Any, v reflect.Value) error {
+	t := v.Type()
+	if v.IsNil() {
+		v.Set(reflect.MakeMapWithSize(t, len(a.Object)))
+	}
+	for _, f := range a.Object {
+		key := reflect.New(t.Key()).Elem()
+		switch key.Kind() {
+		case reflect.String:
+			key.SetString(f.Key)
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			num, err := strconv.ParseInt(f.Key, 10, 64)
+			if err != nil || key.OverflowInt(num) {
+				return fmt.Errorf("lib0: cannot unmarshal object key %q into Go value of type %v", f.Key, t.Key())
+			}
+			key.SetInt(num)
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+			num, err := strconv.ParseUint(f.Key, 10, 64)
+			if err != nil || key.OverflowUint(num) {
+				return fmt.Errorf("lib0: cannot unmarshal object key %q into Go value of type %v", f.Key, t.Key())
+			}
+			key.SetUint(num)
+		default:
+			return fmt.Errorf("lib0: unsupported map key type %v", t.Key())
+		}
+		value := reflect.New(t.Elem()).Elem()
+		if err := unmarshalValue(f.Value, value); err != nil {
+			return err
+		}
+		v.SetMapIndex(key, value)
+	}
+	return nil
+}
+
+func unmarshalStruct(a Any, v reflect.Value) error {
+	fields := cachedStructFields(v.Type())
+	for _, f := range a.Object {
+		i := slices.IndexFunc(fields, func(sf structField) bool { return sf.name == f.Key })
+		if i < 0 {
+			continue
+		}
+		fv := v
+		for j, x := range fields[i].index {
+			if j > 0 && fv.Kind() == reflect.Pointer {
+				if fv.IsNil() {
+					if !fv.CanSet() {
+						return fmt.Errorf("lib0: cannot set embedded pointer to unexported struct %v", fv.Type().Elem())
+					}
+					fv.Set(reflect.New(fv.Type().Elem()))
+				}
+				fv = fv.Elem()
+			}
+			fv = fv.Field(x)
+		}
+		if err := unmarshalValue(f.Value, fv); err != nil {
+			return err
+		}
+	}
+	return nil
+}
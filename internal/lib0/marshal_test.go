@@ -0,0 +1,169 @@
+package lib0_test
+
+import (
+	"encoding/hex"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"gotest.tools/assert"
+	"riguz.com/ygo/internal/lib0"
+)
+
+type marshalBase struct {
+	ID      string `lib0:"id"`
+	Version int
+}
+
+type marshalMessage struct {
+	marshalBase
+	Author    *string           `lib0:"author,omitempty"`
+	Text      string            `lib0:"text"`
+	Tags      []string          `lib0:"tags,omitempty"`
+	Reactions map[string]uint32 `lib0:"reactions"`
+	Avatar    []byte            `lib0:"avatar"`
+	Sent      time.Time         `lib0:"sent"`
+	Score     float64           `lib0:"score"`
+	Extra     any               `lib0:"extra"`
+	Raw       lib0.Any          `lib0:"raw"`
+	Internal  string            `lib0:"-"`
+	private   string
+}
+
+func TestMarshal_struct(t *testing.T) {
+	author := "J. Mes"
+	sent := time.Date(2024, 5, 1, 12, 30, 0, 500, time.UTC)
+	msg := marshalMessage{
+		marshalBase: marshalBase{ID: "m1", Version: 2},
+		Author:      &author,
+		Text:        "hi",
+		Reactions:   map[string]uint32{"👍": 2, "🎉": 1},
+		Avatar:      []byte{1, 2},
+		Sent:        sent,
+		Score:       0.5,
+		Extra:       []any{true, nil},
+		Raw:         lib0.NewAnyBigInt(7),
+		Internal:    "not written",
+		private:     "not written",
+	}
+	value, err := lib0.MarshalAny(msg)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, lib0.NewAnyObject(
+		lib0.AnyField{Key: "author", Value: lib0.NewAnyString("J. Mes")},
+		lib0.AnyField{Key: "text", Value: lib0.NewAnyString("hi")},
+		lib0.AnyField{Key: "reactions", Value: lib0.NewAnyObject(
+			lib0.AnyField{Key: "🎉", Value: lib0.NewAnyInteger(1)},
+			lib0.AnyField{Key: "👍", Value: lib0.NewAnyInteger(2)},
+		)},
+		lib0.AnyField{Key: "avatar", Value: lib0.NewAnyBuffer([]byte{1, 2})},
+		lib0.AnyField{Key: "sent", Value: lib0.NewAnyString("2024-05-01T12:30:00.0000005Z")},
+		lib0.AnyField{Key: "score", Value: lib0.NewAnyFloat32(0.5)},
+		lib0.AnyField{Key: "extra", Value: lib0.NewAnyArray(lib0.NewAnyBool(true), lib0.NewAnyNull())},
+		lib0.AnyField{Key: "raw", Value: lib0.NewAnyBigInt(7)},
+		// fields of embedded structs come last
+		lib0.AnyField{Key: "id", Value: lib0.NewAnyString("m1")},
+		lib0.AnyField{Key: "Version", Value: lib0.NewAnyInteger(2)},
+	), value)
+
+	buf, err := lib0.Marshal(msg)
+	assert.NilError(t, err)
+	var decoded marshalMessage
+	assert.NilError(t, lib0.Unmarshal(buf, &decoded))
+	msg.Internal = ""
+	msg.private = ""
+	assert.Assert(t, reflect.DeepEqual(msg, decoded), "%+v", decoded)
+}
+
+func TestMarshal_values(t *testing.T) {
+	var nilMap map[string]int
+	var tests = []struct {
+		value    any
+		expected string
+	}{
+		{nil, "7e"},
+		{(*int)(nil), "7e"},
+		{nilMap, "7e"},
+		{lib0.Undefined{}, "7f"},
+		{uint8(200), "7d8803"},
+		{int64(math.MaxInt64), "7a7fffffffffffffff"},
+		{float32(1.5), "7c3fc00000"},
+		{[3]byte{1, 2, 3}, "7403010203"},
+		{[]int{1, 2}, "75027d017d02"},
+		{map[int]bool{2: true, 10: false}, "760202313079013278"},
+		{struct{}{}, "7600"},
+	}
+	for _, tt := range tests {
+		buf, err := lib0.Marshal(tt.value)
+		assert.NilError(t, err)
+		assert.Equal(t, tt.expected, hex.EncodeToString(buf))
+	}
+
+	_, err := lib0.Marshal(make(chan int))
+	assert.ErrorContains(t, err, "unsupported type chan int")
+	_, err = lib0.Marshal(uint64(math.MaxUint64))
+	assert.ErrorContains(t, err, "exceeds int64")
+	_, err = lib0.Marshal(map[float64]int{1: 1})
+	assert.ErrorContains(t, err, "unsupported map key type")
+}
+
+func TestUnmarshal_values(t *testing.T) {
+	unmarshal := func(v any, hexValue string) error {
+		buf, _ := hex.DecodeString(hexValue)
+		return lib0.Unmarshal(buf, v)
+	}
+
+	var i8 int8
+	assert.NilError(t, unmarshal(&i8, "7d41"))
+	assert.Equal(t, int8(-1), i8)
+	assert.ErrorContains(t, unmarshal(&i8, "7d8803"), "cannot unmarshal integer into Go value of type int8")
+	var u uint
+	assert.ErrorContains(t, unmarshal(&u, "7d41"), "cannot unmarshal integer")
+	// integral floats, as Yjs writes large integers
+	var i64 int64
+	assert.NilError(t, unmarshal(&i64, "7c4f800000"))
+	assert.Equal(t, int64(1<<32), i64)
+	assert.ErrorContains(t, unmarshal(&i64, "7c3fc00000"), "cannot unmarshal float32")
+	var f float64
+	assert.NilError(t, unmarshal(&f, "7d02"))
+	assert.Equal(t, float64(2), f)
+
+	var arr [2]int
+	assert.NilError(t, unmarshal(&arr, "75037d017d027d03"))
+	assert.Equal(t, [2]int{1, 2}, arr)
+	var keys map[uint16]string
+	assert.NilError(t, unmarshal(&keys, "760102313077016f"))
+	assert.DeepEqual(t, map[uint16]string{10: "o"}, keys)
+
+	var anything any
+	assert.NilError(t, unmarshal(&anything, "75027d0177016f"))
+	assert.DeepEqual(t, []any{int64(1), "o"}, anything)
+	var raw lib0.Any
+	assert.NilError(t, unmarshal(&raw, "7c3fc00000"))
+	assert.DeepEqual(t, lib0.NewAnyFloat32(1.5), raw)
+
+	ptr := &i8
+	assert.NilError(t, unmarshal(&ptr, "7e"))
+	assert.Assert(t, ptr == nil)
+	assert.NilError(t, unmarshal(&ptr, "7d05"))
+	assert.Equal(t, int8(5), *ptr)
+	s := "unchanged"
+	assert.NilError(t, unmarshal(&s, "7f"))
+	assert.Equal(t, "unchanged", s)
+
+	var sent time.Time
+	assert.NilError(t, unmarshal(&sent, "7b4278f34221540000"))
+	assert.Equal(t, time.UnixMilli(1714566600000), sent)
+
+	assert.ErrorContains(t, unmarshal(&s, "7d01"), "cannot unmarshal integer into Go value of type string")
+	assert.ErrorContains(t, unmarshal(s, "7d01"), "non-nil pointer")
+	assert.ErrorContains(t, unmarshal(&s, "770161ff"), "unexpected bytes")
+}
+
+func TestUnmarshal_buffersAreCopied(t *testing.T) {
+	buf, _ := hex.DecodeString("7403010203")
+	var data []byte
+	assert.NilError(t, lib0.Unmarshal(buf, &data))
+	buf[2] = 0xff
+	assert.DeepEqual(t, []byte{1, 2, 3}, data)
+}
@@ -0,0 +1,39 @@
+package ygo
+
+// Array is a view of a Branch holding a sequence of values.
+type Array struct {
+	branch *Branch
+}
+
+func NewArray(branch *Branch) *Array {
+	return &Array{branch: branch}
+}
+
+func (a *Array) Branch() *Branch {
+	return a.branch
+}
+
+func (a *Array) Len() uint32 {
+	return a.branch.BlockLen
+}
+
+// Get returns the value at index, see ToSlice for the types of values.
+func (a *Array) Get(index uint32) (any, bool) {
+	for item := range a.branch.Items() {
+		if index < item.Length {
+			return contentValues(item.Content)[index], true
+		}
+		index -= item.Length
+	}
+	return nil, false
+}
+
+// ToSlice returns the values of the array: lib0.Any for JSON like values,
+// []uint8 for binary values and a view for nested types.
+func (a *Array) ToSlice() []any {
+	values := make([]any, 0, a.branch.BlockLen)
+	for item := range a.branch.Items() {
+		values = append(values, contentValues(item.Content)...)
+	}
+	return values
+}
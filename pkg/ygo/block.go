@@ -1,5 +1,15 @@
 package ygo
 
+import (
+	"fmt"
+	"math"
+	"slices"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"riguz.com/ygo/internal/lib0"
+)
+
 type ClientID uint64
 
 type ID struct {
@@ -13,30 +23,86 @@ type Block interface {
 	IsDeleted() bool
 	Id() ID
 	Len() uint32
-	SameType(other *Block) bool
+	SameType(other Block) bool
 	IsGc() bool
 	IsItem() bool
 	Contains(id ID) bool
+	Encode(encoder Encoder, offset uint32) error
+	// Splice cuts the block at offset, the block keeps the elements before
+	// offset and the remaining elements are returned as a new block.
+	Splice(offset uint32) Block
+	// TryMerge appends right to the block if both can be represented as a
+	// single block, it reports whether right was merged.
+	TryMerge(right Block) bool
 }
 
+var _ Block = &Item{}
+var _ Block = &GC{}
+var _ Block = &Skip{}
+
 type Item struct {
 	ID          ID
-	Len         uint32
-	Left        *Block
-	Right       *Block
+	Length      uint32
+	Left        *Item
+	Right       *Item
 	Origin      *ID
 	RightOrigin *ID
 	Content     ItemContent
 	Parent      TypePtr
 	ParentSub   *string
-	Moved       *Block
+	Moved       *Item
 	Info        ItemFlags
 }
 
+func NewItem(id ID, left *Item, origin *ID, right *Item, rightOrigin *ID,
+	parent TypePtr, parentSub *string, content ItemContent) *Item {
+	info := NewItemFlags(0)
+	if content.IsCountable() {
+		info.SetCountable()
+	}
+	return &Item{
+		ID:          id,
+		Length:      content.Len(),
+		Left:        left,
+		Right:       right,
+		Origin:      origin,
+		RightOrigin: rightOrigin,
+		Content:     content,
+		Parent:      parent,
+		ParentSub:   parentSub,
+		Info:        info,
+	}
+}
+
+func (i *Item) Id() ID {
+	return i.ID
+}
+
+func (i *Item) Len() uint32 {
+	return i.Length
+}
+
+func (i *Item) AsItem() (*Item, error) {
+	return i, nil
+}
+
+func (i *Item) SameType(other Block) bool {
+	_, ok := other.(*Item)
+	return ok
+}
+
+func (i *Item) IsGc() bool {
+	return false
+}
+
+func (i *Item) IsItem() bool {
+	return true
+}
+
 func (i *Item) Contains(id ID) bool {
 	return i.ID.Client == id.Client &&
 		id.Clock >= i.ID.Clock &&
-		id.Clock < i.ID.Clock+i.Len
+		id.Clock < i.ID.Clock+i.Length
 }
 
 func (i *Item) IsDeleted() bool {
@@ -54,7 +120,7 @@ func (i *Item) MarkAsDeleted() {
 func (i *Item) LastId() ID {
 	return ID{
 		Client: i.ID.Client,
-		Clock:  i.ID.Clock + i.Len - 1,
+		Clock:  i.ID.Clock + i.Length - 1,
 	}
 }
 
@@ -62,6 +128,7 @@ const (
 	HAS_ORIGIN       uint8 = 0b1000_0000
 	HAS_RIGHT_ORIGIN uint8 = 0b0100_0000
 	HAS_PARENT_SUB   uint8 = 0b0010_0000
+	CONTENT_REF_MASK uint8 = 0b0001_1111
 )
 
 func (i *Item) ItemInfo() uint8 {
@@ -77,11 +144,481 @@ func (i *Item) ItemInfo() uint8 {
 	if i.ParentSub != nil {
 		info |= HAS_PARENT_SUB
 	}
-	info |= i.Content.GetRefNumber() & 0b1111
+	info |= i.Content.GetRefNumber() & CONTENT_REF_MASK
 	return info
 }
 
+// Encode writes the item, skipping the first offset elements of its
+// content. A non-zero offset makes the previous element the new origin.
+func (i *Item) Encode(encoder Encoder, offset uint32) error {
+	origin := i.Origin
+	if offset > 0 {
+		origin = &ID{Client: i.ID.Client, Clock: i.ID.Clock + offset - 1}
+	}
+	info := i.Content.GetRefNumber() & CONTENT_REF_MASK
+	if origin != nil {
+		info |= HAS_ORIGIN
+	}
+	if i.RightOrigin != nil {
+		info |= HAS_RIGHT_ORIGIN
+	}
+	if i.ParentSub != nil {
+		info |= HAS_PARENT_SUB
+	}
+	if err := encoder.WriteInfo(info); err != nil {
+		return err
+	}
+	if origin != nil {
+		if err := encoder.WriteLeftId(*origin); err != nil {
+			return err
+		}
+	}
+	if i.RightOrigin != nil {
+		if err := encoder.WriteRightId(*i.RightOrigin); err != nil {
+			return err
+		}
+	}
+	if origin == nil && i.RightOrigin == nil {
+		switch {
+		case i.Parent.Named != nil:
+			if err := encoder.WriteParentInfo(true); err != nil {
+				return err
+			}
+			if err := encoder.WriteVarString(i.Parent.Named); err != nil {
+				return err
+			}
+		case i.Parent.ID != nil:
+			if err := encoder.WriteParentInfo(false); err != nil {
+				return err
+			}
+			if err := encoder.WriteLeftId(*i.Parent.ID); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("cannot encode item %v: unknown parent", i.ID)
+		}
+		if i.ParentSub != nil {
+			if err := encoder.WriteVarString(i.ParentSub); err != nil {
+				return err
+			}
+		}
+	}
+	return i.Content.Encode(encoder, offset)
+}
+
+// Splice cuts the item at offset. The right part continues where the item
+// ended in its parent, its origin is the last element of the left part.
+func (i *Item) Splice(offset uint32) Block {
+	if offset == 0 || offset >= i.Length {
+		return nil
+	}
+	right := &Item{
+		ID:          ID{Client: i.ID.Client, Clock: i.ID.Clock + offset},
+		Length:      i.Length - offset,
+		Left:        i,
+		Right:       i.Right,
+		Origin:      &ID{Client: i.ID.Client, Clock: i.ID.Clock + offset - 1},
+		RightOrigin: i.RightOrigin,
+		Content:     i.Content.Splice(offset),
+		Parent:      i.Parent,
+		ParentSub:   i.ParentSub,
+		Moved:       i.Moved,
+		Info:        i.Info,
+	}
+	if i.Right != nil {
+		i.Right.Left = right
+	} else if right.ParentSub != nil {
+		// the map of a type points to the last item of each key
+		if branch := right.Parent.Branch; branch != nil {
+			branch.Map[*right.ParentSub] = right
+		}
+	}
+	i.Right = right
+	i.Length = offset
+	return right
+}
+
+// TryMerge merges right into the item if right was inserted directly after
+// it and still follows it, and their contents can be merged.
+func (i *Item) TryMerge(right Block) bool {
+	if !i.SameType(right) {
+		return false
+	}
+	r := right.(*Item)
+	if i.ID.Client != r.ID.Client ||
+		i.ID.Clock+i.Length != r.ID.Clock ||
+		i.Right != r ||
+		r.Origin == nil || *r.Origin != i.LastId() ||
+		!equalIdPtr(i.RightOrigin, r.RightOrigin) ||
+		i.IsDeleted() != r.IsDeleted() ||
+		i.Moved != r.Moved ||
+		!i.Content.TryMerge(r.Content) {
+		return false
+	}
+	if r.Info.IsKeep() {
+		i.Info.Set(ITEM_FLAG_KEEP)
+	}
+	i.Right = r.Right
+	if i.Right != nil {
+		i.Right.Left = i
+	}
+	i.Length += r.Length
+	return true
+}
+
+// GetMissing returns the ID of a block the item refers to which isn't in
+// store yet, the item can't be integrated before that block arrives. Blocks
+// of the item's own client are integrated in order, so only references to
+// other clients are checked.
+func (i *Item) GetMissing(store *BlockStore) (ID, bool) {
+	refs := []*ID{i.Origin, i.RightOrigin, i.Parent.ID}
+	if c, ok := i.Content.(*MoveContent); ok {
+		refs = append(refs, &c.Start, &c.End)
+	}
+	for _, id := range refs {
+		if id != nil && id.Client != i.ID.Client && id.Clock >= store.GetState(id.Client) {
+			return *id, true
+		}
+	}
+	return ID{}, false
+}
+
+// Repair prepares a received item for Integrate, its references have to be
+// in store, see GetMissing. Left and Right are pointed to the items the
+// origins refer to, splitting the blocks containing them, and an item
+// whose parent wasn't encoded takes over the parent of its neighbours. If
+// the parent is gone, because it or a neighbour was garbage collected, the
+// parent is Unknown afterwards and the item has to be replaced by a GC
+// block.
+func (i *Item) Repair(store *BlockStore) error {
+	var left, right Block
+	if i.Origin != nil {
+		block, err := store.GetItemCleanEnd(*i.Origin)
+		if err != nil {
+			return err
+		}
+		left = block
+		i.Left, _ = block.(*Item)
+	}
+	if i.RightOrigin != nil {
+		block, err := store.GetItemCleanStart(*i.RightOrigin)
+		if err != nil {
+			return err
+		}
+		right = block
+		i.Right, _ = block.(*Item)
+	}
+	switch {
+	case (left != nil && i.Left == nil) || (right != nil && i.Right == nil):
+		// the neighbours were collected together with the parent
+		i.Parent = TypePtr{Unknown: &Unknown{}}
+	case i.Parent.Unknown != nil:
+		neighbour := i.Left
+		if neighbour == nil {
+			neighbour = i.Right
+		}
+		if neighbour != nil {
+			i.Parent = neighbour.Parent
+			i.ParentSub = neighbour.ParentSub
+		}
+	case i.Parent.ID != nil:
+		// the content of a collected type is gone as well
+		parent, ok := store.Get(*i.Parent.ID).(*Item)
+		if !ok {
+			i.Parent = TypePtr{Unknown: &Unknown{}}
+		} else if _, ok := parent.Content.(*TypeContent); !ok {
+			i.Parent = TypePtr{Unknown: &Unknown{}}
+		}
+	}
+	return nil
+}
+
+// Integrate links the item into its parent, skipping its first offset
+// elements which are known already. The item has to be repaired first, see
+// Repair. Items inserted concurrently at the same position
+// are ordered like in Yjs: an item is placed after the conflicting items
+// with a lower client ID, and after the items which were inserted after
+// those. The item doesn't become part of the store, that's up to the
+// caller.
+func (i *Item) Integrate(txn *Transaction, offset uint32) error {
+	store := &txn.store.Blocks
+	if offset > 0 {
+		i.ID.Clock += offset
+		left, err := store.GetItemCleanEnd(ID{Client: i.ID.Client, Clock: i.ID.Clock - 1})
+		if err != nil {
+			return err
+		}
+		i.Left, _ = left.(*Item)
+		origin := left.LastId()
+		i.Origin = &origin
+		i.Content = i.Content.Splice(offset)
+		i.Length -= offset
+	}
+	parent, err := txn.store.parentBranch(i)
+	if err != nil {
+		return err
+	}
+	i.Parent.Branch = parent
+
+	if (i.Left == nil && (i.Right == nil || i.Right.Left != nil)) || (i.Left != nil && i.Left.Right != i.Right) {
+		left := i.Left
+		var o *Item
+		switch {
+		case left != nil:
+			o = left.Right
+		case i.ParentSub != nil:
+			o = parent.firstEntry(*i.ParentSub)
+		default:
+			o = parent.Start
+		}
+		// Let c be in conflicting and b in beforeOrigin:
+		// ***{origin}bbbb{this}{c,b}{c,b}{o}***
+		// conflicting is a subset of beforeOrigin.
+		conflicting := make(map[*Item]struct{})
+		beforeOrigin := make(map[*Item]struct{})
+		for o != nil && o != i.Right {
+			beforeOrigin[o] = struct{}{}
+			conflicting[o] = struct{}{}
+			if equalIdPtr(i.Origin, o.Origin) {
+				if o.ID.Client < i.ID.Client {
+					left = o
+					clear(conflicting)
+				} else if equalIdPtr(i.RightOrigin, o.RightOrigin) {
+					// both are inserted between the same items, the
+					// lower client ID goes first
+					break
+				}
+			} else {
+				var origin *Item
+				if o.Origin != nil {
+					origin, _ = store.Get(*o.Origin).(*Item)
+				}
+				if _, ok := beforeOrigin[origin]; origin == nil || !ok {
+					break
+				}
+				if _, ok := conflicting[origin]; !ok {
+					left = o
+					clear(conflicting)
+				}
+			}
+			o = o.Right
+		}
+		i.Left = left
+	}
+
+	if i.Left != nil {
+		i.Right = i.Left.Right
+		i.Left.Right = i
+	} else if i.ParentSub != nil {
+		i.Right = parent.firstEntry(*i.ParentSub)
+	} else {
+		i.Right = parent.Start
+		parent.Start = i
+	}
+	if i.Right != nil {
+		i.Right.Left = i
+	} else if i.ParentSub != nil {
+		// the rightmost entry is the current value of the key
+		parent.Map[*i.ParentSub] = i
+		if i.Left != nil {
+			i.Left.Delete(txn)
+		}
+	}
+	if i.ParentSub == nil && i.IsCountable() && !i.IsDeleted() {
+		parent.BlockLen += i.Length
+		parent.ContentLen += contentLen(i.Content)
+	}
+	switch c := i.Content.(type) {
+	case *TypeContent:
+		if c.Branch == nil {
+			c.Branch = NewBranch(c.TypeRef)
+		}
+		c.Branch.Item = i
+	case *DeletedContent:
+		// deleted content stands in for content which is gone, so the item
+		// is deleted as soon as it arrives
+		i.MarkAsDeleted()
+		txn.DeleteSet.Insert(i.ID, i.Length)
+	}
+	txn.addChanged(parent, i.ParentSub)
+	if (parent.Item != nil && parent.Item.IsDeleted()) || (i.ParentSub != nil && i.Right != nil) {
+		// the parent is gone, or the entry was replaced concurrently
+		i.Delete(txn)
+	}
+	return nil
+}
+
+// Delete marks the item as deleted and adds it to the delete set of txn.
+// The item no longer counts towards the length of its parent, and if it
+// holds a type, the content of the type is deleted as well.
+func (i *Item) Delete(txn *Transaction) {
+	if i.IsDeleted() {
+		return
+	}
+	if parent := i.Parent.Branch; parent != nil && i.ParentSub == nil && i.IsCountable() {
+		parent.BlockLen -= i.Length
+		parent.ContentLen -= contentLen(i.Content)
+	}
+	i.MarkAsDeleted()
+	txn.DeleteSet.Insert(i.ID, i.Length)
+	if parent := i.Parent.Branch; parent != nil {
+		txn.addChanged(parent, i.ParentSub)
+	}
+	if c, ok := i.Content.(*TypeContent); ok && c.Branch != nil {
+		for item := c.Branch.Start; item != nil; item = item.Right {
+			item.Delete(txn)
+		}
+		for _, item := range c.Branch.Map {
+			item.Delete(txn)
+		}
+	}
+}
+
+// GC is a range of garbage collected blocks, only its length is retained.
 type GC struct {
+	ID     ID
+	Length uint32
+}
+
+func NewGC(id ID, len uint32) *GC {
+	return &GC{ID: id, Length: len}
+}
+
+func (g *GC) LastId() ID {
+	return ID{Client: g.ID.Client, Clock: g.ID.Clock + g.Length - 1}
+}
+
+func (g *GC) AsItem() (*Item, error) {
+	return nil, fmt.Errorf("block %v is not an item", g.ID)
+}
+
+func (g *GC) IsDeleted() bool {
+	return true
+}
+
+func (g *GC) Id() ID {
+	return g.ID
+}
+
+func (g *GC) Len() uint32 {
+	return g.Length
+}
+
+func (g *GC) SameType(other Block) bool {
+	_, ok := other.(*GC)
+	return ok
+}
+
+func (g *GC) IsGc() bool {
+	return true
+}
+
+func (g *GC) IsItem() bool {
+	return false
+}
+
+func (g *GC) Contains(id ID) bool {
+	return g.ID.Client == id.Client &&
+		id.Clock >= g.ID.Clock &&
+		id.Clock < g.ID.Clock+g.Length
+}
+
+func (g *GC) Encode(encoder Encoder, offset uint32) error {
+	if err := encoder.WriteInfo(BLOCK_GC_REF_NUMBER); err != nil {
+		return err
+	}
+	return encoder.WriteLen(g.Length - offset)
+}
+
+func (g *GC) Splice(offset uint32) Block {
+	if offset == 0 || offset >= g.Length {
+		return nil
+	}
+	right := NewGC(ID{Client: g.ID.Client, Clock: g.ID.Clock + offset}, g.Length-offset)
+	g.Length = offset
+	return right
+}
+
+func (g *GC) TryMerge(right Block) bool {
+	if !g.SameType(right) || right.Id() != (ID{Client: g.ID.Client, Clock: g.ID.Clock + g.Length}) {
+		return false
+	}
+	g.Length += right.Len()
+	return true
+}
+
+// Skip is a placeholder for a range of blocks that are not part of an
+// update. It only occurs in updates, never in a document's block store.
+type Skip struct {
+	ID     ID
+	Length uint32
+}
+
+func NewSkip(id ID, len uint32) *Skip {
+	return &Skip{ID: id, Length: len}
+}
+
+func (s *Skip) LastId() ID {
+	return ID{Client: s.ID.Client, Clock: s.ID.Clock + s.Length - 1}
+}
+
+func (s *Skip) AsItem() (*Item, error) {
+	return nil, fmt.Errorf("block %v is not an item", s.ID)
+}
+
+func (s *Skip) IsDeleted() bool {
+	return false
+}
+
+func (s *Skip) Id() ID {
+	return s.ID
+}
+
+func (s *Skip) Len() uint32 {
+	return s.Length
+}
+
+func (s *Skip) SameType(other Block) bool {
+	_, ok := other.(*Skip)
+	return ok
+}
+
+func (s *Skip) IsGc() bool {
+	return false
+}
+
+func (s *Skip) IsItem() bool {
+	return false
+}
+
+func (s *Skip) Contains(id ID) bool {
+	return s.ID.Client == id.Client &&
+		id.Clock >= s.ID.Clock &&
+		id.Clock < s.ID.Clock+s.Length
+}
+
+func (s *Skip) Encode(encoder Encoder, offset uint32) error {
+	if err := encoder.WriteInfo(BLOCK_SKIP_REF_NUMBER); err != nil {
+		return err
+	}
+	return encoder.WriteVarUint32(s.Length - offset)
+}
+
+func (s *Skip) Splice(offset uint32) Block {
+	if offset == 0 || offset >= s.Length {
+		return nil
+	}
+	right := NewSkip(ID{Client: s.ID.Client, Clock: s.ID.Clock + offset}, s.Length-offset)
+	s.Length = offset
+	return right
+}
+
+func (s *Skip) TryMerge(right Block) bool {
+	if !s.SameType(right) || right.Id() != (ID{Client: s.ID.Client, Clock: s.ID.Clock + s.Length}) {
+		return false
+	}
+	s.Length += right.Len()
+	return true
 }
 
 type BlockRange struct {
@@ -92,6 +629,15 @@ type BlockRange struct {
 type ItemContent interface {
 	GetRefNumber() uint8
 	IsCountable() bool
+	Len() uint32
+	Encode(encoder Encoder, offset uint32) error
+	// Splice cuts the content at offset, the content keeps the elements
+	// before offset and the remaining elements are returned. Contents of
+	// length 1 can't be spliced and return nil.
+	Splice(offset uint32) ItemContent
+	// TryMerge appends other to the content if both can be represented as
+	// a single content, it reports whether other was merged.
+	TryMerge(other ItemContent) bool
 }
 
 const (
@@ -109,7 +655,113 @@ const (
 	BLOCK_ITEM_MOVE_REF_NUMBER    uint8 = 11
 )
 
+// DecodeItemContent reads the content identified by the ref number, which
+// is stored in the lower bits of an item's info byte.
+func DecodeItemContent(decoder Decoder, ref uint8) (ItemContent, error) {
+	switch ref {
+	case BLOCK_ITEM_DELETED_REF_NUMBER:
+		len, err := decoder.ReadLen()
+		if err != nil {
+			return nil, err
+		}
+		return &DeletedContent{Length: len}, nil
+	case BLOCK_ITEM_JSON_REF_NUMBER:
+		len, err := decoder.ReadLen()
+		if err != nil {
+			return nil, err
+		}
+		if err := checkElements(decoder, "json content length", uint64(len)); err != nil {
+			return nil, err
+		}
+		// the length comes from the input, so grow the slice as values are
+		// actually read instead of allocating it upfront
+		var values []string
+		for range len {
+			str, err := decoder.ReadVarString()
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, str)
+		}
+		return &JsonContent{Values: values}, nil
+	case BLOCK_ITEM_BINARY_REF_NUMBER:
+		buf, err := decoder.ReadVarUint8Array()
+		if err != nil {
+			return nil, err
+		}
+		return &BinaryContent{Data: buf}, nil
+	case BLOCK_ITEM_STRING_REF_NUMBER:
+		str, err := decoder.ReadVarString()
+		if err != nil {
+			return nil, err
+		}
+		return &StringContent{Str: str}, nil
+	case BLOCK_ITEM_EMBED_REF_NUMBER:
+		embed, err := decoder.ReadJson()
+		if err != nil {
+			return nil, err
+		}
+		return &EmbedContent{Embed: embed}, nil
+	case BLOCK_ITEM_FORMAT_REF_NUMBER:
+		key, err := decoder.ReadKey()
+		if err != nil {
+			return nil, err
+		}
+		value, err := decoder.ReadJson()
+		if err != nil {
+			return nil, err
+		}
+		return &FormatContent{Key: *key, Value: value}, nil
+	case BLOCK_ITEM_TYPE_REF_NUMBER:
+		typeRef, err := decoder.ReadTypeRef()
+		if err != nil {
+			return nil, err
+		}
+		content := &TypeContent{TypeRef: typeRef}
+		if typeRef == TYPE_REFS_XML_ELEMENT || typeRef == TYPE_REFS_XML_HOOK {
+			name, err := decoder.ReadKey()
+			if err != nil {
+				return nil, err
+			}
+			content.Name = name
+		}
+		return content, nil
+	case BLOCK_ITEM_ANY_REF_NUMBER:
+		len, err := decoder.ReadLen()
+		if err != nil {
+			return nil, err
+		}
+		if err := checkElements(decoder, "any content length", uint64(len)); err != nil {
+			return nil, err
+		}
+		var values []lib0.Any
+		for range len {
+			value, err := decoder.ReadAny()
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, value)
+		}
+		return &AnyContent{Values: values}, nil
+	case BLOCK_ITEM_DOC_REF_NUMBER:
+		guid, err := decoder.ReadVarString()
+		if err != nil {
+			return nil, err
+		}
+		opts, err := decoder.ReadAny()
+		if err != nil {
+			return nil, err
+		}
+		return &DocContent{Guid: guid, Opts: opts}, nil
+	case BLOCK_ITEM_MOVE_REF_NUMBER:
+		return decodeMoveContent(decoder)
+	default:
+		return nil, fmt.Errorf("unknown item content ref number: %v", ref)
+	}
+}
+
 type AnyContent struct {
+	Values []lib0.Any
 }
 
 func (c *AnyContent) GetRefNumber() uint8 {
@@ -120,16 +772,67 @@ func (c *AnyContent) IsCountable() bool {
 	return true
 }
 
-type BinaryContent struct{}
+func (c *AnyContent) Len() uint32 {
+	return uint32(len(c.Values))
+}
+
+func (c *AnyContent) Encode(encoder Encoder, offset uint32) error {
+	if err := encoder.WriteLen(c.Len() - offset); err != nil {
+		return err
+	}
+	for _, v := range c.Values[offset:] {
+		if err := encoder.WriteAny(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *AnyContent) Splice(offset uint32) ItemContent {
+	right := &AnyContent{Values: slices.Clone(c.Values[offset:])}
+	c.Values = c.Values[:offset:offset]
+	return right
+}
+
+func (c *AnyContent) TryMerge(other ItemContent) bool {
+	if o, ok := other.(*AnyContent); ok {
+		c.Values = append(c.Values, o.Values...)
+		return true
+	}
+	return false
+}
+
+type BinaryContent struct {
+	Data []uint8
+}
 
 func (c *BinaryContent) GetRefNumber() uint8 {
 	return BLOCK_ITEM_BINARY_REF_NUMBER
 }
+
 func (c *BinaryContent) IsCountable() bool {
 	return true
 }
 
-type DeletedContent struct{}
+func (c *BinaryContent) Len() uint32 {
+	return 1
+}
+
+func (c *BinaryContent) Encode(encoder Encoder, offset uint32) error {
+	return encoder.WriteVarUint8Array(c.Data)
+}
+
+func (c *BinaryContent) Splice(offset uint32) ItemContent {
+	return nil
+}
+
+func (c *BinaryContent) TryMerge(other ItemContent) bool {
+	return false
+}
+
+type DeletedContent struct {
+	Length uint32
+}
 
 func (c *DeletedContent) GetRefNumber() uint8 {
 	return BLOCK_ITEM_DELETED_REF_NUMBER
@@ -139,7 +842,32 @@ func (c *DeletedContent) IsCountable() bool {
 	return false
 }
 
-type DocContent struct{}
+func (c *DeletedContent) Len() uint32 {
+	return c.Length
+}
+
+func (c *DeletedContent) Encode(encoder Encoder, offset uint32) error {
+	return encoder.WriteLen(c.Length - offset)
+}
+
+func (c *DeletedContent) Splice(offset uint32) ItemContent {
+	right := &DeletedContent{Length: c.Length - offset}
+	c.Length = offset
+	return right
+}
+
+func (c *DeletedContent) TryMerge(other ItemContent) bool {
+	if o, ok := other.(*DeletedContent); ok {
+		c.Length += o.Length
+		return true
+	}
+	return false
+}
+
+type DocContent struct {
+	Guid string
+	Opts lib0.Any
+}
 
 func (c *DocContent) GetRefNumber() uint8 {
 	return BLOCK_ITEM_DOC_REF_NUMBER
@@ -149,17 +877,72 @@ func (c *DocContent) IsCountable() bool {
 	return true
 }
 
-type JsonContent struct{}
+func (c *DocContent) Len() uint32 {
+	return 1
+}
+
+func (c *DocContent) Encode(encoder Encoder, offset uint32) error {
+	if err := encoder.WriteVarString(&c.Guid); err != nil {
+		return err
+	}
+	return encoder.WriteAny(c.Opts)
+}
+
+func (c *DocContent) Splice(offset uint32) ItemContent {
+	return nil
+}
+
+func (c *DocContent) TryMerge(other ItemContent) bool {
+	return false
+}
+
+// JsonContent is the legacy content type for JSON values, each value is
+// kept as the JSON string it was encoded with.
+type JsonContent struct {
+	Values []string
+}
 
 func (c *JsonContent) GetRefNumber() uint8 {
-	return BLOCK_ITEM_DOC_REF_NUMBER
+	return BLOCK_ITEM_JSON_REF_NUMBER
 }
 
 func (c *JsonContent) IsCountable() bool {
 	return true
 }
 
-type EmbedContent struct{}
+func (c *JsonContent) Len() uint32 {
+	return uint32(len(c.Values))
+}
+
+func (c *JsonContent) Encode(encoder Encoder, offset uint32) error {
+	if err := encoder.WriteLen(c.Len() - offset); err != nil {
+		return err
+	}
+	for i := range c.Values[offset:] {
+		if err := encoder.WriteVarString(&c.Values[int(offset)+i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *JsonContent) Splice(offset uint32) ItemContent {
+	right := &JsonContent{Values: slices.Clone(c.Values[offset:])}
+	c.Values = c.Values[:offset:offset]
+	return right
+}
+
+func (c *JsonContent) TryMerge(other ItemContent) bool {
+	if o, ok := other.(*JsonContent); ok {
+		c.Values = append(c.Values, o.Values...)
+		return true
+	}
+	return false
+}
+
+type EmbedContent struct {
+	Embed lib0.Any
+}
 
 func (c *EmbedContent) GetRefNumber() uint8 {
 	return BLOCK_ITEM_EMBED_REF_NUMBER
@@ -169,7 +952,26 @@ func (c *EmbedContent) IsCountable() bool {
 	return true
 }
 
-type FormatContent struct{}
+func (c *EmbedContent) Len() uint32 {
+	return 1
+}
+
+func (c *EmbedContent) Encode(encoder Encoder, offset uint32) error {
+	return encoder.WriteJson(c.Embed)
+}
+
+func (c *EmbedContent) Splice(offset uint32) ItemContent {
+	return nil
+}
+
+func (c *EmbedContent) TryMerge(other ItemContent) bool {
+	return false
+}
+
+type FormatContent struct {
+	Key   string
+	Value lib0.Any
+}
 
 func (c *FormatContent) GetRefNumber() uint8 {
 	return BLOCK_ITEM_FORMAT_REF_NUMBER
@@ -179,7 +981,28 @@ func (c *FormatContent) IsCountable() bool {
 	return false
 }
 
-type StringContent struct{}
+func (c *FormatContent) Len() uint32 {
+	return 1
+}
+
+func (c *FormatContent) Encode(encoder Encoder, offset uint32) error {
+	if err := encoder.WriteKey(&c.Key); err != nil {
+		return err
+	}
+	return encoder.WriteJson(c.Value)
+}
+
+func (c *FormatContent) Splice(offset uint32) ItemContent {
+	return nil
+}
+
+func (c *FormatContent) TryMerge(other ItemContent) bool {
+	return false
+}
+
+type StringContent struct {
+	Str string
+}
 
 func (c *StringContent) GetRefNumber() uint8 {
 	return BLOCK_ITEM_STRING_REF_NUMBER
@@ -189,7 +1012,84 @@ func (c *StringContent) IsCountable() bool {
 	return true
 }
 
-type TypeContent struct{}
+// Len returns the length of the string in UTF-16 code units, the same way
+// it's measured by Yjs.
+func (c *StringContent) Len() uint32 {
+	return utf16Len(c.Str)
+}
+
+func (c *StringContent) Encode(encoder Encoder, offset uint32) error {
+	if offset == 0 {
+		return encoder.WriteVarString(&c.Str)
+	}
+	_, str := splitUtf16(c.Str, offset)
+	return encoder.WriteVarString(&str)
+}
+
+// Splice cuts the string at offset, which is measured in UTF-16 code units.
+// Like Yjs, a surrogate pair cut in half is replaced by a U+FFFD on both
+// sides, which keeps the lengths of both halves intact.
+func (c *StringContent) Splice(offset uint32) ItemContent {
+	left, right := splitUtf16(c.Str, offset)
+	c.Str = left
+	return &StringContent{Str: right}
+}
+
+// contentLen returns the length of content counted towards the ContentLen
+// of its parent, which measures strings in UTF-8 bytes.
+func contentLen(content ItemContent) uint32 {
+	if c, ok := content.(*StringContent); ok {
+		return uint32(len(c.Str))
+	}
+	return content.Len()
+}
+
+// utf16Len returns the number of UTF-16 code units needed to represent str.
+// Invalid UTF-8 bytes count as one unit each, as they decode to U+FFFD.
+func utf16Len(str string) uint32 {
+	var n uint32
+	for _, r := range str {
+		n += uint32(utf16.RuneLen(r))
+	}
+	return n
+}
+
+// splitUtf16 splits str at an offset measured in UTF-16 code units, without
+// converting the halves that are left untouched. If the offset falls inside
+// a surrogate pair, each half of the pair becomes a U+FFFD.
+func splitUtf16(str string, offset uint32) (string, string) {
+	var units uint32
+	for i, r := range str {
+		if units == offset {
+			return str[:i], str[i:]
+		}
+		n := uint32(utf16.RuneLen(r))
+		if units+n > offset {
+			_, size := utf8.DecodeRuneInString(str[i:])
+			return str[:i] + string(utf8.RuneError), string(utf8.RuneError) + str[i+size:]
+		}
+		units += n
+	}
+	return str, ""
+}
+
+func (c *StringContent) TryMerge(other ItemContent) bool {
+	if o, ok := other.(*StringContent); ok {
+		c.Str += o.Str
+		return true
+	}
+	return false
+}
+
+type TypeContent struct {
+	TypeRef uint8
+	// Name is the node name of an xml element, or the hook name of an
+	// xml hook.
+	Name *string
+	// Branch holds the content of the type, it's created when the item is
+	// integrated.
+	Branch *Branch
+}
 
 func (c *TypeContent) GetRefNumber() uint8 {
 	return BLOCK_ITEM_TYPE_REF_NUMBER
@@ -199,7 +1099,86 @@ func (c *TypeContent) IsCountable() bool {
 	return true
 }
 
-type MoveContent struct{}
+func (c *TypeContent) Len() uint32 {
+	return 1
+}
+
+func (c *TypeContent) Encode(encoder Encoder, offset uint32) error {
+	if err := encoder.WriteTypeRef(c.TypeRef); err != nil {
+		return err
+	}
+	if c.TypeRef == TYPE_REFS_XML_ELEMENT || c.TypeRef == TYPE_REFS_XML_HOOK {
+		if c.Name == nil {
+			return fmt.Errorf("type %v requires a name", c.TypeRef)
+		}
+		return encoder.WriteKey(c.Name)
+	}
+	return nil
+}
+
+func (c *TypeContent) Splice(offset uint32) ItemContent {
+	return nil
+}
+
+func (c *TypeContent) TryMerge(other ItemContent) bool {
+	return false
+}
+
+const (
+	MOVE_FLAG_COLLAPSED   int64 = 0b0000_0001
+	MOVE_FLAG_START_AFTER int64 = 0b0000_0010
+	MOVE_FLAG_END_AFTER   int64 = 0b0000_0100
+)
+
+// MoveContent is the yrs move extension, it moves the range between Start
+// and End to the position of the item that carries it.
+type MoveContent struct {
+	Start      ID
+	End        ID
+	StartAfter bool
+	EndAfter   bool
+	Priority   int32
+}
+
+func decodeMoveContent(decoder Decoder) (*MoveContent, error) {
+	flags, err := decoder.ReadVarInt()
+	if err != nil {
+		return nil, err
+	}
+	start, err := decodeMoveId(decoder)
+	if err != nil {
+		return nil, err
+	}
+	end := start
+	if flags&MOVE_FLAG_COLLAPSED == 0 {
+		end, err = decodeMoveId(decoder)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &MoveContent{
+		Start:      start,
+		End:        end,
+		StartAfter: flags&MOVE_FLAG_START_AFTER != 0,
+		EndAfter:   flags&MOVE_FLAG_END_AFTER != 0,
+		Priority:   int32(flags >> 6),
+	}, nil
+}
+
+func decodeMoveId(decoder Decoder) (ID, error) {
+	client, err := decoder.ReadVarUint()
+	if err != nil {
+		return ID{}, err
+	}
+	clock, err := decoder.ReadVarUint()
+	if err != nil {
+		return ID{}, err
+	}
+	if clock > math.MaxUint32 {
+		return ID{}, fmt.Errorf("var int exceeds max uint32 range: %v", clock)
+	}
+	return ID{Client: ClientID(client), Clock: uint32(clock)}, nil
+}
 
 func (c *MoveContent) GetRefNumber() uint8 {
 	return BLOCK_ITEM_MOVE_REF_NUMBER
@@ -209,6 +1188,53 @@ func (c *MoveContent) IsCountable() bool {
 	return false
 }
 
+func (c *MoveContent) Len() uint32 {
+	return 1
+}
+
+func (c *MoveContent) IsCollapsed() bool {
+	return c.Start == c.End
+}
+
+func (c *MoveContent) Encode(encoder Encoder, offset uint32) error {
+	flags := int64(c.Priority) << 6
+	if c.IsCollapsed() {
+		flags |= MOVE_FLAG_COLLAPSED
+	}
+	if c.StartAfter {
+		flags |= MOVE_FLAG_START_AFTER
+	}
+	if c.EndAfter {
+		flags |= MOVE_FLAG_END_AFTER
+	}
+	if err := encoder.WriteVarInt64(flags); err != nil {
+		return err
+	}
+	if err := encoder.WriteVarUint64(uint64(c.Start.Client)); err != nil {
+		return err
+	}
+	if err := encoder.WriteVarUint32(c.Start.Clock); err != nil {
+		return err
+	}
+	if !c.IsCollapsed() {
+		if err := encoder.WriteVarUint64(uint64(c.End.Client)); err != nil {
+			return err
+		}
+		if err := encoder.WriteVarUint32(c.End.Clock); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *MoveContent) Splice(offset uint32) ItemContent {
+	return nil
+}
+
+func (c *MoveContent) TryMerge(other ItemContent) bool {
+	return false
+}
+
 const (
 	ITEM_FLAG_MARKED    uint8 = 0b0000_1000
 	ITEM_FLAG_DELETED   uint8 = 0b0000_0100
@@ -1,27 +1,383 @@
 package ygo
 
+import (
+	"fmt"
+	"math"
+	"slices"
+
+	"riguz.com/ygo/pkg/ygo/common"
+)
+
+// BlockStore holds the blocks of a document, per client ordered by clock.
 type BlockStore struct {
-	clients map[ClientID]ClientBlockList
+	clients map[ClientID]*ClientBlockList
+}
+
+func NewBlockStore() BlockStore {
+	return BlockStore{
+		clients: make(map[ClientID]*ClientBlockList),
+	}
+}
+
+func (b *BlockStore) IsEmpty() bool {
+	return len(b.clients) == 0
+}
+
+// Clients returns the clients with blocks in the store, in descending order.
+func (b *BlockStore) Clients() []ClientID {
+	return sortedClients(b.clients)
+}
+
+// GetClient returns the blocks of client, or nil if there are none.
+func (b *BlockStore) GetClient(client ClientID) *ClientBlockList {
+	return b.clients[client]
+}
+
+// Push appends block to the blocks of its client. The block has to start
+// exactly where the blocks of the client end.
+func (b *BlockStore) Push(block Block) error {
+	id := block.Id()
+	list, ok := b.clients[id.Client]
+	if !ok {
+		list = &ClientBlockList{}
+		b.clients[id.Client] = list
+	}
+	if state := list.GetState(); id.Clock != state {
+		return fmt.Errorf("cannot push block %v, the state of client %v is %v", id, id.Client, state)
+	}
+	list.Push(block)
+	return nil
+}
+
+// Squash merges adjacent blocks of every client, see
+// ClientBlockList.Squash.
+func (b *BlockStore) Squash() {
+	for _, list := range b.clients {
+		list.Squash()
+	}
+}
+
+// GarbageCollect drops the content of the deleted items in ds, so only
+// their length is retained. Items of a deleted type become GC blocks, the
+// other items keep their place in their parent with DeletedContent. Items
+// flagged with ITEM_FLAG_KEEP are left as they are.
+func (b *BlockStore) GarbageCollect(ds *DeleteSet) {
+	for _, client := range ds.Clients() {
+		list, ok := b.clients[client]
+		if !ok {
+			continue
+		}
+		for _, r := range ds.Get(client) {
+			index, ok := list.FindIndex(uint32(r.Start))
+			if !ok {
+				continue
+			}
+			for ; index < list.Len(); index++ {
+				block := list.Get(index)
+				if uint64(block.Id().Clock) >= r.End {
+					break
+				}
+				item, ok := block.(*Item)
+				if !ok || !item.IsDeleted() || item.Info.IsKeep() {
+					continue
+				}
+				b.collect(item, b.isParentCollected(item))
+			}
+		}
+	}
+}
+
+// collect drops the content of a deleted item, the item becomes a GC block
+// if its parent is collected as well. The children of a type are collected
+// with it, including the ones deleted by earlier transactions.
+func (b *BlockStore) collect(item *Item, parentCollected bool) {
+	if c, ok := item.Content.(*TypeContent); ok && c.Branch != nil {
+		for child := c.Branch.Start; child != nil; child = child.Right {
+			b.collect(child, true)
+		}
+		for _, child := range c.Branch.Map {
+			for ; child != nil; child = child.Left {
+				b.collect(child, true)
+			}
+		}
+		c.Branch.Start = nil
+		c.Branch.Map = make(map[string]*Item)
+		c.Branch.BlockLen = 0
+		c.Branch.ContentLen = 0
+	}
+	if parentCollected {
+		if list, ok := b.clients[item.ID.Client]; ok {
+			if index, ok := list.FindIndex(item.ID.Clock); ok && list.list[index] == item {
+				list.list[index] = NewGC(item.ID, item.Length)
+			}
+		}
+	} else if _, ok := item.Content.(*DeletedContent); !ok {
+		item.Content = &DeletedContent{Length: item.Length}
+		item.Info.ClearCountable()
+	}
+}
+
+// isParentCollected reports whether the type containing item is deleted and
+// collected, or is going to be.
+func (b *BlockStore) isParentCollected(item *Item) bool {
+	for {
+		if item.Parent.ID != nil {
+			switch parent := b.Get(*item.Parent.ID).(type) {
+			case *GC:
+				return true
+			case *Item:
+				return parent.IsDeleted() && !parent.Info.IsKeep()
+			default:
+				return false
+			}
+		}
+		if item.Parent.Unknown == nil {
+			// a root type, they are never deleted
+			return false
+		}
+		// the parent was not encoded as it's the same as the neighbours'
+		var neighbour Block
+		switch {
+		case item.Left != nil:
+			neighbour = item.Left
+		case item.Origin != nil:
+			neighbour = b.Get(*item.Origin)
+		case item.Right != nil:
+			neighbour = item.Right
+		case item.RightOrigin != nil:
+			neighbour = b.Get(*item.RightOrigin)
+		}
+		next, ok := neighbour.(*Item)
+		if !ok {
+			return false
+		}
+		item = next
+	}
+}
+
+// SquashDeleteSet merges the blocks around the ranges of ds with their left
+// neighbours, which is where deleting or collecting them allows merging.
+func (b *BlockStore) SquashDeleteSet(ds *DeleteSet) {
+	for _, client := range ds.Clients() {
+		list, ok := b.clients[client]
+		if !ok {
+			continue
+		}
+		ranges := ds.Get(client)
+		for i := len(ranges) - 1; i >= 0; i-- {
+			r := ranges[i]
+			last, ok := list.FindIndex(uint32(r.End - 1))
+			if !ok {
+				last = list.Len() - 1
+			}
+			// the block following the range may be merged with it as well
+			for index := min(last+1, list.Len()-1); index > 0 && uint64(list.Get(index).Id().Clock) >= r.Start; index-- {
+				list.SquashLeft(index)
+			}
+		}
+	}
+}
+
+// SquashSince merges the blocks written after before with their left
+// neighbours, which is where a transaction appending blocks allows merging.
+func (b *BlockStore) SquashSince(before *StateVector) {
+	for client, list := range b.clients {
+		clock := before.Get(client)
+		if clock == list.GetState() {
+			continue
+		}
+		first, ok := list.FindIndex(clock)
+		if !ok {
+			first = list.Len()
+		}
+		// the first new block may be merged with the one before it as well
+		for index := list.Len() - 1; index >= max(first, 1); index-- {
+			list.SquashLeft(index)
+		}
+	}
+}
+
+// GetState returns the next clock expected from client.
+func (b *BlockStore) GetState(client ClientID) uint32 {
+	if list, ok := b.clients[client]; ok {
+		return list.GetState()
+	}
+	return 0
 }
 
 func (b *BlockStore) GetStateVector() StateVector {
-	panic("not implemented")
+	return NewStateVectorFrom(b)
 }
 
+// Get returns the block containing id, or nil if there is none.
+func (b *BlockStore) Get(id ID) Block {
+	list, ok := b.clients[id.Client]
+	if !ok {
+		return nil
+	}
+	index, ok := list.FindIndex(id.Clock)
+	if !ok {
+		return nil
+	}
+	return list.Get(index)
+}
+
+// GetItemCleanStart returns the block starting at id. An item containing id
+// further in is split, and its right part is returned. Like in Yjs, GC
+// blocks are returned as they are.
+func (b *BlockStore) GetItemCleanStart(id ID) (Block, error) {
+	list, index, err := b.find(id)
+	if err != nil {
+		return nil, err
+	}
+	block := list.Get(index)
+	if item, ok := block.(*Item); ok && id.Clock > item.ID.Clock {
+		right := item.Splice(id.Clock - item.ID.Clock)
+		list.Insert(index+1, right)
+		return right, nil
+	}
+	return block, nil
+}
+
+// GetItemCleanEnd returns the block ending at id. An item continuing after
+// id is split, and its left part is returned. Like in Yjs, GC blocks are
+// returned as they are.
+func (b *BlockStore) GetItemCleanEnd(id ID) (Block, error) {
+	list, index, err := b.find(id)
+	if err != nil {
+		return nil, err
+	}
+	block := list.Get(index)
+	if item, ok := block.(*Item); ok && id.Clock != item.LastId().Clock {
+		right := item.Splice(id.Clock - item.ID.Clock + 1)
+		list.Insert(index+1, right)
+	}
+	return block, nil
+}
+
+func (b *BlockStore) find(id ID) (*ClientBlockList, int, error) {
+	list, ok := b.clients[id.Client]
+	if !ok {
+		return nil, 0, fmt.Errorf("no blocks of client %v", id.Client)
+	}
+	index, ok := list.FindIndex(id.Clock)
+	if !ok {
+		return nil, 0, fmt.Errorf("no block contains %v", id)
+	}
+	return list, index, nil
+}
+
+// ClientBlockList holds the blocks of a single client, ordered by clock and
+// without gaps between them.
 type ClientBlockList struct {
-	list []*Block
+	list []Block
+}
+
+func (c *ClientBlockList) Len() int {
+	return len(c.list)
 }
 
+// GetState returns the clock following the last block.
 func (c *ClientBlockList) GetState() uint32 {
-	panic("not implemented")
-	//item := c.Get(len(c.list) - 1)
-	//return item.Id()
+	if len(c.list) == 0 {
+		return 0
+	}
+	last := c.list[len(c.list)-1]
+	return last.Id().Clock + last.Len()
 }
 
-func (c *ClientBlockList) Get(index int) *Block {
+func (c *ClientBlockList) Get(index int) Block {
 	return c.list[index]
 }
 
+func (c *ClientBlockList) Push(block Block) {
+	c.list = append(c.list, block)
+}
+
+// Insert puts block at index, moving the following blocks to the right.
+func (c *ClientBlockList) Insert(index int, block Block) {
+	c.list = slices.Insert(c.list, index, block)
+}
+
+// SquashLeft merges the block at index into the block before it, if
+// possible. It reports whether the block was merged and removed.
+func (c *ClientBlockList) SquashLeft(index int) bool {
+	if index <= 0 || index >= len(c.list) {
+		return false
+	}
+	if !mergeBlocks(c.list[index-1], c.list[index]) {
+		return false
+	}
+	c.list = slices.Delete(c.list, index, index+1)
+	return true
+}
+
+// mergeBlocks merges right into left, see Block.TryMerge. The map of a type
+// points to the last item of each key, so it points to left afterwards if it
+// pointed to right.
+func mergeBlocks(left, right Block) bool {
+	if !left.TryMerge(right) {
+		return false
+	}
+	if r, ok := right.(*Item); ok && r.ParentSub != nil {
+		if branch := r.Parent.Branch; branch != nil && branch.Map[*r.ParentSub] == r {
+			branch.Map[*r.ParentSub] = left.(*Item)
+		}
+	}
+	return true
+}
+
+// Squash merges all adjacent blocks which can be merged, so the number of
+// blocks follows the number of edits rather than the number of elements.
+func (c *ClientBlockList) Squash() {
+	if len(c.list) == 0 {
+		return
+	}
+	j := 0
+	for i := 1; i < len(c.list); i++ {
+		if !mergeBlocks(c.list[j], c.list[i]) {
+			j++
+			c.list[j] = c.list[i]
+		}
+	}
+	clear(c.list[j+1:])
+	c.list = c.list[:j+1]
+}
+
+// FindIndex returns the index of the block containing clock. Like Yjs it
+// starts with a guess assuming evenly sized blocks, and continues with a
+// binary search.
+func (c *ClientBlockList) FindIndex(clock uint32) (int, bool) {
+	if len(c.list) == 0 {
+		return 0, false
+	}
+	left := 0
+	right := len(c.list) - 1
+	last := c.list[right]
+	lastClock := last.Id().Clock
+	if lastClock == clock {
+		return right, true
+	}
+	if clock >= lastClock+last.Len() {
+		return 0, false
+	}
+	pivot := int(uint64(clock) * uint64(right) / uint64(lastClock+last.Len()-1))
+	for left <= right {
+		block := c.list[pivot]
+		start := block.Id().Clock
+		if start <= clock {
+			if clock < start+block.Len() {
+				return pivot, true
+			}
+			left = pivot + 1
+		} else {
+			right = pivot - 1
+		}
+		pivot = (left + right) / 2
+	}
+	return 0, false
+}
+
 type StateVector struct {
 	vector map[ClientID]uint32
 }
@@ -51,8 +407,11 @@ func (s *StateVector) Get(clientId ClientID) uint32 {
 	return s.vector[clientId]
 }
 
+// Contains reports whether the clock of id has been observed. The state of a
+// client is the next clock expected from it, so the state itself is not
+// contained.
 func (s *StateVector) Contains(id ID) bool {
-	return id.Clock <= s.Get(id.Client)
+	return id.Clock < s.Get(id.Client)
 }
 
 func (s *StateVector) IncreaseBy(clientId ClientID, delta uint32) {
@@ -83,17 +442,131 @@ func (s *StateVector) Merge(other *StateVector) {
 	}
 }
 
+// Encode writes the state vector with clients in descending order, the same
+// order Yjs uses. A zero clock is the same as a missing client and is left
+// out, so equal state vectors always encode to equal bytes.
 func (s *StateVector) Encode(encoder Encoder) error {
-	if err := encoder.WriteVarInt(len(s.vector)); err != nil {
+	clients := slices.DeleteFunc(sortedClients(s.vector), func(client ClientID) bool {
+		return s.vector[client] == 0
+	})
+	if err := encoder.WriteVarUint64(uint64(len(clients))); err != nil {
 		return err
 	}
-	for client, clock := range s.vector {
+	for _, client := range clients {
 		if err := encoder.WriteVarUint64(uint64(client)); err != nil {
 			return err
 		}
-		if err := encoder.WriteVarUint32(clock); err != nil {
+		if err := encoder.WriteVarUint32(s.vector[client]); err != nil {
 			return err
 		}
 	}
 	return nil
 }
+
+// EncodeV1 encodes the state vector, state vectors are always V1 encoded.
+func (s *StateVector) EncodeV1() ([]uint8, error) {
+	encoder := NewEncoderV1()
+	if err := s.Encode(&encoder); err != nil {
+		return nil, err
+	}
+	return encoder.ToBytes(), nil
+}
+
+func (s *StateVector) Decode(decoder Decoder) error {
+	if s.vector == nil {
+		s.vector = make(map[ClientID]uint32)
+	}
+	len, err := readCount(decoder, "client count")
+	if err != nil {
+		return err
+	}
+	for range len {
+		client, err := decoder.ReadVarUint()
+		if err != nil {
+			return err
+		}
+		clock, err := decoder.ReadVarUint()
+		if err != nil {
+			return err
+		}
+		if clock > math.MaxUint32 {
+			return fmt.Errorf("var int exceeds max uint32 range: %v", clock)
+		}
+		s.vector[ClientID(client)] = uint32(clock)
+	}
+	return nil
+}
+
+// DecodeV1 reads a state vector, state vectors are always V1 encoded.
+func (s *StateVector) DecodeV1(buf []uint8) error {
+	decoder := NewDecoderV1FromBytes(buf)
+	return s.Decode(&decoder)
+}
+
+// DecodeStateVector reads a V1 encoded state vector.
+func DecodeStateVector(buf []uint8) (StateVector, error) {
+	sv := NewStateVector()
+	if err := sv.DecodeV1(buf); err != nil {
+		return StateVector{}, err
+	}
+	return sv, nil
+}
+
+// compare reports whether some client of s is behind other and whether some
+// client of s is ahead of other. Missing clients count as clock 0.
+func (s *StateVector) compare(other *StateVector) (behind bool, ahead bool) {
+	for client, clock := range s.vector {
+		if clock > other.Get(client) {
+			ahead = true
+		} else if clock < other.Get(client) {
+			behind = true
+		}
+	}
+	for client, clock := range other.vector {
+		if clock > s.Get(client) {
+			behind = true
+		}
+	}
+	return behind, ahead
+}
+
+// Equal reports whether both state vectors have observed the same clocks.
+func (s *StateVector) Equal(other *StateVector) bool {
+	behind, ahead := s.compare(other)
+	return !behind && !ahead
+}
+
+// Dominates reports whether s has observed everything other has observed.
+// Equal state vectors dominate each other.
+func (s *StateVector) Dominates(other *StateVector) bool {
+	_, ahead := other.compare(s)
+	return !ahead
+}
+
+// HappenedBefore reports whether other has observed everything s has
+// observed, and something more.
+func (s *StateVector) HappenedBefore(other *StateVector) bool {
+	behind, ahead := s.compare(other)
+	return behind && !ahead
+}
+
+// Concurrent reports whether both state vectors have observed something the
+// other one has not.
+func (s *StateVector) Concurrent(other *StateVector) bool {
+	behind, ahead := s.compare(other)
+	return behind && ahead
+}
+
+// Missing returns the clock ranges other has observed but s has not, which
+// is what has to be sent to bring s up to date with other. A state vector
+// only describes a prefix of the clocks of each client, so every set holds a
+// single range.
+func (s *StateVector) Missing(other *StateVector) map[ClientID]common.RangeSet {
+	missing := make(map[ClientID]common.RangeSet)
+	for client, clock := range other.vector {
+		if local := s.Get(client); local < clock {
+			missing[client] = common.NewRangeSet(common.NewRange(uint64(local), uint64(clock)))
+		}
+	}
+	return missing
+}
@@ -0,0 +1,400 @@
+package ygo_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"riguz.com/ygo/pkg/ygo"
+	"riguz.com/ygo/pkg/ygo/common"
+)
+
+func stateVector(clocks map[ygo.ClientID]uint32) *ygo.StateVector {
+	sv := ygo.NewStateVector()
+	for client, clock := range clocks {
+		sv.SetMax(client, clock)
+	}
+	return &sv
+}
+
+func TestStateVector_encode(t *testing.T) {
+	sv := stateVector(map[ygo.ClientID]uint32{1: 3, 300: 1, 2: 200})
+	for range 10 {
+		buf, err := sv.EncodeV1()
+		assert.Nil(t, err)
+		assert.Equal(t, "03ac020102c8010103", hex.EncodeToString(buf))
+	}
+
+	buf, err := hex.DecodeString("03ac020102c8010103")
+	assert.Nil(t, err)
+	decoded, err := ygo.DecodeStateVector(buf)
+	assert.Nil(t, err)
+	assert.True(t, decoded.Equal(sv))
+	assert.Equal(t, 3, decoded.Len())
+
+	_, err = ygo.DecodeStateVector([]uint8{0x02, 0x01})
+	assert.NotNil(t, err)
+}
+
+func TestStateVector_compare(t *testing.T) {
+	tests := []struct {
+		name           string
+		a              map[ygo.ClientID]uint32
+		b              map[ygo.ClientID]uint32
+		equal          bool
+		dominates      bool
+		happenedBefore bool
+		concurrent     bool
+	}{
+		{"empty", nil, nil, true, true, false, false},
+		{"equal", map[ygo.ClientID]uint32{1: 2, 2: 3}, map[ygo.ClientID]uint32{1: 2, 2: 3}, true, true, false, false},
+		{"zero clock equals missing client", map[ygo.ClientID]uint32{1: 2, 2: 0}, map[ygo.ClientID]uint32{1: 2}, true, true, false, false},
+		{"ahead", map[ygo.ClientID]uint32{1: 3, 2: 3}, map[ygo.ClientID]uint32{1: 2, 2: 3}, false, true, false, false},
+		{"extra client", map[ygo.ClientID]uint32{1: 2, 2: 3}, map[ygo.ClientID]uint32{1: 2}, false, true, false, false},
+		{"behind", map[ygo.ClientID]uint32{1: 2}, map[ygo.ClientID]uint32{1: 2, 2: 1}, false, false, true, false},
+		{"concurrent", map[ygo.ClientID]uint32{1: 3, 2: 1}, map[ygo.ClientID]uint32{1: 2, 2: 2}, false, false, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, b := stateVector(tt.a), stateVector(tt.b)
+			assert.Equal(t, tt.equal, a.Equal(b))
+			assert.Equal(t, tt.equal, b.Equal(a))
+			assert.Equal(t, tt.dominates, a.Dominates(b))
+			assert.Equal(t, tt.happenedBefore, a.HappenedBefore(b))
+			assert.Equal(t, tt.concurrent, a.Concurrent(b))
+			assert.Equal(t, tt.concurrent, b.Concurrent(a))
+			bufA, err := a.EncodeV1()
+			assert.Nil(t, err)
+			bufB, err := b.EncodeV1()
+			assert.Nil(t, err)
+			assert.Equal(t, tt.equal, bytes.Equal(bufA, bufB))
+		})
+	}
+}
+
+func TestStateVector_missing(t *testing.T) {
+	a := stateVector(map[ygo.ClientID]uint32{1: 3, 2: 1})
+	b := stateVector(map[ygo.ClientID]uint32{1: 2, 2: 5, 3: 4})
+
+	assert.Equal(t, map[ygo.ClientID]common.RangeSet{
+		2: common.NewRangeSet(common.NewRange(1, 5)),
+		3: common.NewRangeSet(common.NewRange(0, 4)),
+	}, a.Missing(b))
+	assert.Equal(t, map[ygo.ClientID]common.RangeSet{
+		1: common.NewRangeSet(common.NewRange(2, 3)),
+	}, b.Missing(a))
+	assert.Empty(t, a.Missing(a))
+}
+
+// blockStore pushes blocks of client 1 with the given lengths, alternating
+// between items and GC blocks.
+func blockStore(t *testing.T, lengths ...uint32) *ygo.BlockStore {
+	store := ygo.NewBlockStore()
+	var clock uint32
+	for i, len := range lengths {
+		var block ygo.Block
+		if i%2 == 0 {
+			block = textItem(1, clock, nil, strings.Repeat("x", int(len)))
+		} else {
+			block = ygo.NewGC(ygo.ID{Client: 1, Clock: clock}, len)
+		}
+		assert.Nil(t, store.Push(block))
+		clock += len
+	}
+	return &store
+}
+
+func TestBlockStore_push(t *testing.T) {
+	store := blockStore(t, 3, 2, 5)
+	assert.Equal(t, uint32(10), store.GetState(1))
+	assert.Equal(t, uint32(0), store.GetState(2))
+	assert.Nil(t, store.Push(ygo.NewGC(ygo.ID{Client: 2, Clock: 0}, 4)))
+
+	sv := store.GetStateVector()
+	assert.True(t, sv.Equal(stateVector(map[ygo.ClientID]uint32{1: 10, 2: 4})))
+	assert.Equal(t, []ygo.ClientID{2, 1}, store.Clients())
+
+	assert.NotNil(t, store.Push(ygo.NewGC(ygo.ID{Client: 1, Clock: 11}, 1)))
+	assert.NotNil(t, store.Push(ygo.NewGC(ygo.ID{Client: 1, Clock: 9}, 1)))
+	assert.Equal(t, uint32(10), store.GetState(1))
+}
+
+func TestClientBlockList_findIndex(t *testing.T) {
+	lengths := []uint32{1, 4, 2, 1, 1, 7, 3, 1}
+	store := blockStore(t, lengths...)
+	list := store.GetClient(1)
+	assert.Equal(t, len(lengths), list.Len())
+
+	var clock uint32
+	for i, len := range lengths {
+		for c := clock; c < clock+len; c++ {
+			index, ok := list.FindIndex(c)
+			assert.True(t, ok)
+			assert.Equal(t, i, index, "clock %v", c)
+		}
+		clock += len
+	}
+	_, ok := list.FindIndex(clock)
+	assert.False(t, ok)
+	_, ok = (&ygo.ClientBlockList{}).FindIndex(0)
+	assert.False(t, ok)
+}
+
+func TestBlockStore_get(t *testing.T) {
+	store := blockStore(t, 3, 2)
+	assert.Equal(t, ygo.ID{Client: 1, Clock: 0}, store.Get(ygo.ID{Client: 1, Clock: 2}).Id())
+	assert.True(t, store.Get(ygo.ID{Client: 1, Clock: 4}).IsGc())
+	assert.Nil(t, store.Get(ygo.ID{Client: 1, Clock: 5}))
+	assert.Nil(t, store.Get(ygo.ID{Client: 2, Clock: 0}))
+}
+
+func TestBlockStore_getItemClean(t *testing.T) {
+	store := blockStore(t, 5, 2)
+
+	block, err := store.GetItemCleanStart(ygo.ID{Client: 1, Clock: 2})
+	assert.Nil(t, err)
+	right, err := block.AsItem()
+	assert.Nil(t, err)
+	assert.Equal(t, ygo.ID{Client: 1, Clock: 2}, right.ID)
+	assert.Equal(t, "xxx", right.Content.(*ygo.StringContent).Str)
+	assert.Equal(t, &ygo.ID{Client: 1, Clock: 1}, right.Origin)
+
+	block, err = store.GetItemCleanEnd(ygo.ID{Client: 1, Clock: 3})
+	assert.Nil(t, err)
+	assert.Equal(t, right, block)
+	assert.Equal(t, uint32(2), right.Len())
+	assert.Equal(t, uint32(1), right.Right.Len())
+	assert.Equal(t, right, right.Right.Left)
+
+	// already clean
+	block, err = store.GetItemCleanStart(ygo.ID{Client: 1, Clock: 2})
+	assert.Nil(t, err)
+	assert.Equal(t, right, block)
+	block, err = store.GetItemCleanEnd(ygo.ID{Client: 1, Clock: 4})
+	assert.Nil(t, err)
+	assert.Equal(t, right.Right, block)
+
+	// GC blocks are not split
+	block, err = store.GetItemCleanStart(ygo.ID{Client: 1, Clock: 6})
+	assert.Nil(t, err)
+	assert.Equal(t, ygo.ID{Client: 1, Clock: 5}, block.Id())
+
+	list := store.GetClient(1)
+	assert.Equal(t, 4, list.Len())
+	var clock uint32
+	for i := range list.Len() {
+		assert.Equal(t, clock, list.Get(i).Id().Clock)
+		clock += list.Get(i).Len()
+	}
+	assert.Equal(t, uint32(7), store.GetState(1))
+
+	_, err = store.GetItemCleanStart(ygo.ID{Client: 1, Clock: 7})
+	assert.NotNil(t, err)
+	_, err = store.GetItemCleanEnd(ygo.ID{Client: 2, Clock: 0})
+	assert.NotNil(t, err)
+}
+
+func TestBlockStore_getItemCleanMapEntry(t *testing.T) {
+	root := "map"
+	key := "key"
+	first := ygo.ID{Client: 1, Clock: 0}
+	store := ygo.NewDocStore()
+	applyV1(t, store, encodeV1(t, []ygo.Block{
+		ygo.NewItem(first, nil, nil, nil, nil,
+			ygo.TypePtr{Named: &root}, &key, &ygo.AnyContent{Values: anyValues(1, 2)}),
+	}, nil))
+	branch := rootType(t, store, "map")
+
+	block, err := store.Blocks.GetItemCleanStart(ygo.ID{Client: 1, Clock: 1})
+	assert.Nil(t, err)
+	// the right part is the last item of the key now
+	assert.Same(t, block, branch.Map["key"])
+	assert.Equal(t, first, block.(*ygo.Item).Left.ID)
+}
+
+// typeRun pushes a run of linked items of client 1, each inserted right
+// after the previous one.
+func typeRun(t *testing.T, store *ygo.BlockStore, strs ...string) []*ygo.Item {
+	var items []*ygo.Item
+	for _, str := range strs {
+		var origin *ygo.ID
+		var left *ygo.Item
+		if len(items) > 0 {
+			left = items[len(items)-1]
+			last := left.LastId()
+			origin = &last
+		}
+		item := textItem(1, store.GetState(1), origin, str)
+		if left != nil {
+			item.Left = left
+			left.Right = item
+		}
+		assert.Nil(t, store.Push(item))
+		items = append(items, item)
+	}
+	return items
+}
+
+func TestClientBlockList_squash(t *testing.T) {
+	store := ygo.NewBlockStore()
+	items := typeRun(t, &store, "a", "b", "c", "d", "e")
+	items[2].MarkAsDeleted()
+	items[3].MarkAsDeleted()
+
+	store.Squash()
+	list := store.GetClient(1)
+	assert.Equal(t, 3, list.Len())
+	assert.Equal(t, "ab", items[0].Content.(*ygo.StringContent).Str)
+	assert.Equal(t, uint32(2), items[0].Len())
+	assert.Equal(t, "cd", items[2].Content.(*ygo.StringContent).Str)
+	assert.Equal(t, items[2], items[0].Right)
+	assert.Equal(t, items[0], items[2].Left)
+	assert.Equal(t, items[4], list.Get(2))
+	assert.Equal(t, uint32(5), store.GetState(1))
+}
+
+func TestClientBlockList_squashLeft(t *testing.T) {
+	store := ygo.NewBlockStore()
+	items := typeRun(t, &store, "a", "b")
+	// not inserted right after "b"
+	unrelated := textItem(1, 2, nil, "c")
+	items[1].Right = unrelated
+	unrelated.Left = items[1]
+	assert.Nil(t, store.Push(unrelated))
+	assert.Nil(t, store.Push(ygo.NewGC(ygo.ID{Client: 1, Clock: 3}, 2)))
+	assert.Nil(t, store.Push(ygo.NewGC(ygo.ID{Client: 1, Clock: 5}, 1)))
+
+	list := store.GetClient(1)
+	assert.False(t, list.SquashLeft(0))
+	assert.False(t, list.SquashLeft(2))
+	assert.False(t, list.SquashLeft(3))
+	assert.True(t, list.SquashLeft(4))
+	assert.Equal(t, uint32(3), list.Get(3).Len())
+	assert.True(t, list.SquashLeft(1))
+	assert.Equal(t, 3, list.Len())
+	assert.Equal(t, "ab", items[0].Content.(*ygo.StringContent).Str)
+	assert.Equal(t, unrelated, items[0].Right)
+	assert.Equal(t, items[0], unrelated.Left)
+	assert.False(t, list.SquashLeft(5))
+}
+
+func TestBlock_tryMergeMismatch(t *testing.T) {
+	store := ygo.NewBlockStore()
+	items := typeRun(t, &store, "a", "b")
+	items[1].Content = &ygo.AnyContent{Values: anyValues(1)}
+	assert.False(t, items[0].TryMerge(items[1]))
+	assert.False(t, items[0].TryMerge(ygo.NewGC(ygo.ID{Client: 1, Clock: 1}, 1)))
+
+	gc := ygo.NewGC(ygo.ID{Client: 1, Clock: 0}, 2)
+	assert.False(t, gc.TryMerge(ygo.NewGC(ygo.ID{Client: 1, Clock: 3}, 1)))
+	assert.False(t, gc.TryMerge(ygo.NewGC(ygo.ID{Client: 2, Clock: 2}, 1)))
+	assert.True(t, gc.TryMerge(ygo.NewGC(ygo.ID{Client: 1, Clock: 2}, 1)))
+	assert.Equal(t, uint32(3), gc.Len())
+}
+
+func deleteSet(client ygo.ClientID, clock uint32, len uint32) *ygo.DeleteSet {
+	ds := ygo.NewDeleteSet()
+	ds.Insert(ygo.ID{Client: client, Clock: clock}, len)
+	return &ds
+}
+
+func TestBlockStore_garbageCollect(t *testing.T) {
+	store := ygo.NewBlockStore()
+	items := typeRun(t, &store, "a", "bc", "d", "e")
+	items[1].MarkAsDeleted()
+	items[2].MarkAsDeleted()
+	items[3].MarkAsDeleted()
+	items[3].Info.Set(ygo.ITEM_FLAG_KEEP)
+
+	ds := deleteSet(1, 1, 4)
+	store.GarbageCollect(ds)
+	assert.Equal(t, &ygo.DeletedContent{Length: 2}, items[1].Content)
+	assert.False(t, items[1].IsCountable())
+	assert.Equal(t, &ygo.DeletedContent{Length: 1}, items[2].Content)
+	assert.Equal(t, "e", items[3].Content.(*ygo.StringContent).Str)
+	assert.Equal(t, "a", items[0].Content.(*ygo.StringContent).Str)
+
+	store.SquashDeleteSet(ds)
+	list := store.GetClient(1)
+	assert.Equal(t, 3, list.Len())
+	assert.Equal(t, &ygo.DeletedContent{Length: 3}, items[1].Content)
+	assert.Equal(t, items[3], items[1].Right)
+	assert.Equal(t, uint32(5), store.GetState(1))
+}
+
+func TestBlockStore_garbageCollectDeletedType(t *testing.T) {
+	store := ygo.NewBlockStore()
+	root := "array"
+	array := ygo.NewItem(ygo.ID{Client: 1, Clock: 0}, nil, nil, nil, nil,
+		ygo.TypePtr{Named: &root}, nil, &ygo.TypeContent{TypeRef: ygo.TYPE_REFS_ARRAY})
+	parent := array.ID
+	first := ygo.NewItem(ygo.ID{Client: 1, Clock: 1}, nil, nil, nil, nil,
+		ygo.TypePtr{ID: &parent}, nil, &ygo.AnyContent{Values: anyValues(1, 2)})
+	origin := first.LastId()
+	second := ygo.NewItem(ygo.ID{Client: 1, Clock: 3}, first, &origin, nil, nil,
+		ygo.TypePtr{Unknown: &ygo.Unknown{}}, nil, &ygo.AnyContent{Values: anyValues(3)})
+	first.Right = second
+	for _, item := range []*ygo.Item{array, first, second} {
+		item.MarkAsDeleted()
+		assert.Nil(t, store.Push(item))
+	}
+
+	ds := deleteSet(1, 0, 4)
+	store.GarbageCollect(ds)
+	store.SquashDeleteSet(ds)
+	list := store.GetClient(1)
+	assert.Equal(t, 2, list.Len())
+	assert.Equal(t, &ygo.DeletedContent{Length: 1}, array.Content)
+	assert.Equal(t, ygo.NewGC(ygo.ID{Client: 1, Clock: 1}, 3), list.Get(1))
+
+	// a kept type keeps its children as well
+	store = ygo.NewBlockStore()
+	array.Info.Set(ygo.ITEM_FLAG_KEEP)
+	array.Content = &ygo.TypeContent{TypeRef: ygo.TYPE_REFS_ARRAY}
+	first.Content = &ygo.AnyContent{Values: anyValues(1, 2)}
+	for _, item := range []*ygo.Item{array, first} {
+		assert.Nil(t, store.Push(item))
+	}
+	store.GarbageCollect(deleteSet(1, 0, 3))
+	assert.Equal(t, &ygo.TypeContent{TypeRef: ygo.TYPE_REFS_ARRAY}, array.Content)
+	assert.Equal(t, &ygo.DeletedContent{Length: 2}, first.Content)
+	assert.Equal(t, first, store.GetClient(1).Get(1))
+}
+
+func TestBlockStore_garbageCollectNestedTypes(t *testing.T) {
+	root := "array"
+	key := "k"
+	list := "l"
+	mapType := ygo.ID{Client: 1, Clock: 0}
+	arrayType := ygo.ID{Client: 1, Clock: 3}
+	store := ygo.NewDocStore()
+	cleanup := func(buf []uint8) {
+		before := store.Blocks.GetStateVector()
+		deleted := applyV1(t, store, buf)
+		store.Cleanup(&deleted, &before, true)
+	}
+	cleanup(encodeV1(t, []ygo.Block{
+		ygo.NewItem(mapType, nil, nil, nil, nil,
+			ygo.TypePtr{Named: &root}, nil, &ygo.TypeContent{TypeRef: ygo.TYPE_REFS_MAP}),
+		ygo.NewItem(ygo.ID{Client: 1, Clock: 1}, nil, nil, nil, nil,
+			ygo.TypePtr{ID: &mapType}, &key, &ygo.AnyContent{Values: anyValues(1)}),
+		// replaces the entry before it, which is deleted right away
+		ygo.NewItem(ygo.ID{Client: 1, Clock: 2}, nil, &ygo.ID{Client: 1, Clock: 1}, nil, nil,
+			ygo.TypePtr{Unknown: &ygo.Unknown{}}, &key, &ygo.AnyContent{Values: anyValues(2)}),
+		ygo.NewItem(arrayType, nil, nil, nil, nil,
+			ygo.TypePtr{ID: &mapType}, &list, &ygo.TypeContent{TypeRef: ygo.TYPE_REFS_ARRAY}),
+		ygo.NewItem(ygo.ID{Client: 1, Clock: 4}, nil, nil, nil, nil,
+			ygo.TypePtr{ID: &arrayType}, nil, &ygo.AnyContent{Values: anyValues(3)}),
+	}, nil))
+	cleanup(encodeV1(t, nil, []ygo.BlockRange{{ID: ygo.ID{Client: 1, Clock: 4}, Len: 1}}))
+	assert.Equal(t, &ygo.DeletedContent{Length: 1}, store.Blocks.Get(ygo.ID{Client: 1, Clock: 4}).(*ygo.Item).Content)
+
+	// the entries deleted before are collected with their type
+	cleanup(encodeV1(t, nil, []ygo.BlockRange{{ID: mapType, Len: 1}}))
+	blocks := store.Blocks.GetClient(1)
+	assert.Equal(t, 2, blocks.Len())
+	assert.Equal(t, &ygo.DeletedContent{Length: 1}, blocks.Get(0).(*ygo.Item).Content)
+	assert.Equal(t, ygo.NewGC(ygo.ID{Client: 1, Clock: 1}, 4), blocks.Get(1))
+}
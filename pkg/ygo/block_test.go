@@ -1,9 +1,15 @@
 package ygo_test
 
 import (
+	"bytes"
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"riguz.com/ygo/internal/lib0"
 	"riguz.com/ygo/pkg/ygo"
 )
 
@@ -34,3 +40,563 @@ func TestItemFlags_Clear(t *testing.T) {
 	flags.Clear(ygo.ITEM_FLAG_COUNTABLE)
 	assert.Equal(t, false, flags.IsCountable())
 }
+
+func mustAny(v any) lib0.Any {
+	value, err := lib0.AnyFromGo(v)
+	if err != nil {
+		panic(err)
+	}
+	return value
+}
+
+func anyValues(values ...any) []lib0.Any {
+	result := make([]lib0.Any, len(values))
+	for i, v := range values {
+		result[i] = mustAny(v)
+	}
+	return result
+}
+
+func allContents() []ygo.ItemContent {
+	name := "p"
+	return []ygo.ItemContent{
+		&ygo.DeletedContent{Length: 5},
+		&ygo.JsonContent{Values: []string{`{"a":1}`, "[true]", "undefined"}},
+		&ygo.BinaryContent{Data: []uint8{1, 2, 3}},
+		&ygo.StringContent{Str: "Hello,中国！"},
+		&ygo.EmbedContent{Embed: mustAny(map[string]any{"image": "a.png"})},
+		&ygo.FormatContent{Key: "bold", Value: lib0.NewAnyBool(true)},
+		&ygo.TypeContent{TypeRef: ygo.TYPE_REFS_TEXT},
+		&ygo.TypeContent{TypeRef: ygo.TYPE_REFS_XML_ELEMENT, Name: &name},
+		&ygo.AnyContent{Values: anyValues(int64(1), "two", nil, []any{true}, map[string]any{"k": float32(3.5)})},
+		&ygo.DocContent{Guid: "guid", Opts: mustAny(map[string]any{"gc": true})},
+		&ygo.MoveContent{Start: ygo.ID{Client: 1, Clock: 2}, End: ygo.ID{Client: 1, Clock: 2}, EndAfter: true},
+		&ygo.MoveContent{Start: ygo.ID{Client: 1, Clock: 2}, End: ygo.ID{Client: 3, Clock: 4}, StartAfter: true, Priority: -3},
+	}
+}
+
+func TestItemContent_roundTrip(t *testing.T) {
+	for _, content := range allContents() {
+		encoderV1 := ygo.NewEncoderV1()
+		assert.Nil(t, content.Encode(&encoderV1, 0))
+		decoderV1 := ygo.NewDecoderV1(bytes.NewReader(encoderV1.ToBytes()))
+		decoded, err := ygo.DecodeItemContent(&decoderV1, content.GetRefNumber())
+		assert.Nil(t, err)
+		assert.Equal(t, content, decoded)
+
+		encoderV2 := ygo.NewEncoderV2()
+		assert.Nil(t, content.Encode(&encoderV2, 0))
+		bufV2, err := encoderV2.ToBytes()
+		assert.Nil(t, err)
+		decoderV2, err := ygo.NewDecoderV2(bytes.NewReader(bufV2))
+		assert.Nil(t, err)
+		decoded, err = ygo.DecodeItemContent(&decoderV2, content.GetRefNumber())
+		assert.Nil(t, err)
+		assert.Equal(t, content, decoded)
+	}
+}
+
+func TestItemContent_encodeWithOffset(t *testing.T) {
+	tests := []struct {
+		content  ygo.ItemContent
+		offset   uint32
+		expected ygo.ItemContent
+	}{
+		{&ygo.DeletedContent{Length: 5}, 2, &ygo.DeletedContent{Length: 3}},
+		{&ygo.StringContent{Str: "abc"}, 1, &ygo.StringContent{Str: "bc"}},
+		{&ygo.AnyContent{Values: anyValues(int64(1), "two")}, 1, &ygo.AnyContent{Values: anyValues("two")}},
+		{&ygo.JsonContent{Values: []string{"1", "2", "3"}}, 2, &ygo.JsonContent{Values: []string{"3"}}},
+	}
+	for _, tt := range tests {
+		encoder := ygo.NewEncoderV1()
+		assert.Nil(t, tt.content.Encode(&encoder, tt.offset))
+		decoder := ygo.NewDecoderV1(bytes.NewReader(encoder.ToBytes()))
+		decoded, err := ygo.DecodeItemContent(&decoder, tt.content.GetRefNumber())
+		assert.Nil(t, err)
+		assert.Equal(t, tt.expected, decoded)
+	}
+}
+
+func TestItemContent_decodeUnknownRef(t *testing.T) {
+	decoder := ygo.NewDecoderV1(bytes.NewReader([]uint8{0}))
+	_, err := ygo.DecodeItemContent(&decoder, 12)
+	assert.NotNil(t, err)
+}
+
+func TestItemContent_spliceAndMerge(t *testing.T) {
+	tests := []struct {
+		content ygo.ItemContent
+		left    ygo.ItemContent
+		right   ygo.ItemContent
+	}{
+		{&ygo.DeletedContent{Length: 5}, &ygo.DeletedContent{Length: 2}, &ygo.DeletedContent{Length: 3}},
+		{&ygo.StringContent{Str: "abcd"}, &ygo.StringContent{Str: "a"}, &ygo.StringContent{Str: "bcd"}},
+		{&ygo.AnyContent{Values: anyValues(int64(1), "two", nil)},
+			&ygo.AnyContent{Values: anyValues(int64(1), "two")}, &ygo.AnyContent{Values: anyValues(nil)}},
+		{&ygo.JsonContent{Values: []string{"1", "2"}},
+			&ygo.JsonContent{Values: []string{"1"}}, &ygo.JsonContent{Values: []string{"2"}}},
+	}
+	for _, tt := range tests {
+		length := tt.content.Len()
+		right := tt.content.Splice(tt.left.Len())
+		assert.Equal(t, tt.left, tt.content)
+		assert.Equal(t, tt.right, right)
+		assert.Equal(t, length, tt.content.Len()+right.Len())
+
+		assert.True(t, tt.content.TryMerge(right))
+		assert.Equal(t, length, tt.content.Len())
+		// the merged content must not share its backing array with the
+		// spliced right side
+		assert.Equal(t, tt.right, right)
+	}
+
+	for _, content := range allContents()[2:] {
+		if content.Len() != 1 {
+			continue
+		}
+		assert.Nil(t, content.Splice(0))
+		assert.False(t, content.TryMerge(content))
+	}
+	assert.False(t, (&ygo.StringContent{Str: "a"}).TryMerge(&ygo.AnyContent{Values: anyValues("b")}))
+}
+
+func TestStringContent_len(t *testing.T) {
+	tests := []struct {
+		str string
+		len uint32
+	}{
+		{"", 0},
+		{"abc", 3},
+		{"中国！", 3},
+		{"𐐷", 2},
+		{"a😀b👍🏽", 8},
+		{"\xff", 1},
+	}
+	for _, tt := range tests {
+		content := ygo.StringContent{Str: tt.str}
+		assert.Equal(t, tt.len, content.Len(), tt.str)
+	}
+}
+
+func TestStringContent_splice(t *testing.T) {
+	tests := []struct {
+		str    string
+		offset uint32
+		left   string
+		right  string
+	}{
+		{"abc", 0, "", "abc"},
+		{"abc", 3, "abc", ""},
+		{"中国！", 1, "中", "国！"},
+		{"a😀b", 1, "a", "😀b"},
+		{"a😀b", 3, "a😀", "b"},
+		// splitting a surrogate pair replaces both halves, like Yjs does
+		{"a😀b", 2, "a�", "�b"},
+		{"😀😀", 1, "�", "�😀"},
+		{"😀😀", 3, "😀�", "�"},
+	}
+	for _, tt := range tests {
+		content := &ygo.StringContent{Str: tt.str}
+		length := content.Len()
+		right := content.Splice(tt.offset)
+		assert.Equal(t, tt.left, content.Str)
+		assert.Equal(t, tt.right, right.(*ygo.StringContent).Str)
+		assert.Equal(t, tt.offset, content.Len())
+		assert.Equal(t, length, content.Len()+right.Len())
+
+		encoder := ygo.NewEncoderV1()
+		assert.Nil(t, (&ygo.StringContent{Str: tt.str}).Encode(&encoder, tt.offset))
+		decoder := ygo.NewDecoderV1(bytes.NewReader(encoder.ToBytes()))
+		decoded, err := ygo.DecodeItemContent(&decoder, ygo.BLOCK_ITEM_STRING_REF_NUMBER)
+		assert.Nil(t, err)
+		assert.Equal(t, tt.right, decoded.(*ygo.StringContent).Str)
+	}
+}
+
+func TestStringContent_lossless(t *testing.T) {
+	str := "Hello,中国！𐐷😀 👍🏽\xff"
+	content := &ygo.StringContent{Str: str}
+	right := content.Splice(9)
+	assert.True(t, content.TryMerge(right))
+	assert.Equal(t, str, content.Str)
+
+	// the V2 string column is read as UTF-16, so only the invalid byte is
+	// replaced
+	encoder := ygo.NewEncoderV2()
+	assert.Nil(t, content.Encode(&encoder, 0))
+	buf, err := encoder.ToBytes()
+	assert.Nil(t, err)
+	decoder, err := ygo.NewDecoderV2(bytes.NewReader(buf))
+	assert.Nil(t, err)
+	decoded, err := ygo.DecodeItemContent(&decoder, ygo.BLOCK_ITEM_STRING_REF_NUMBER)
+	assert.Nil(t, err)
+	assert.Equal(t, "Hello,中国！𐐷😀 👍🏽�", decoded.(*ygo.StringContent).Str)
+}
+
+// visibleText returns the string of the items of branch which are not
+// deleted.
+func visibleText(branch *ygo.Branch) string {
+	var sb strings.Builder
+	for item := branch.Start; item != nil; item = item.Right {
+		if !item.IsDeleted() {
+			sb.WriteString(item.Content.(*ygo.StringContent).Str)
+		}
+	}
+	return sb.String()
+}
+
+func rootType(t *testing.T, store *ygo.DocStore, name string) *ygo.Branch {
+	branch, err := store.GetOrCreateType(name, ygo.TYPE_REFS_UNDEFINED)
+	assert.Nil(t, err)
+	return branch
+}
+
+func applyV1(t *testing.T, store *ygo.DocStore, buf []uint8) ygo.DeleteSet {
+	update := ygo.NewUpdate()
+	assert.Nil(t, update.DecodeV1(buf))
+	deleted, err := store.ApplyUpdate(update)
+	assert.Nil(t, err)
+	return deleted
+}
+
+// permutations returns every order of the numbers 0 to n-1.
+func permutations(n int) [][]int {
+	if n == 0 {
+		return [][]int{{}}
+	}
+	var result [][]int
+	for _, p := range permutations(n - 1) {
+		for i := 0; i <= len(p); i++ {
+			order := slices.Insert(slices.Clone(p), i, n-1)
+			result = append(result, order)
+		}
+	}
+	return result
+}
+
+func TestItem_integrateConcurrent(t *testing.T) {
+	a := ygo.ID{Client: 1, Clock: 0}
+	b := ygo.ID{Client: 1, Clock: 1}
+	x := ygo.ID{Client: 2, Clock: 0}
+	item := func(id ygo.ID, origin *ygo.ID, rightOrigin *ygo.ID, str string) *ygo.Item {
+		root := "text"
+		return ygo.NewItem(id, nil, origin, nil, rightOrigin,
+			ygo.TypePtr{Named: &root}, nil, &ygo.StringContent{Str: str})
+	}
+	updates := [][]uint8{
+		encodeV1(t, []ygo.Block{item(a, nil, nil, "ab")}, nil),
+		// "x" and then "w" are inserted between "a" and "b" by client 2
+		encodeV1(t, []ygo.Block{item(x, &a, &b, "x"), item(ygo.ID{Client: 2, Clock: 1}, &x, &b, "w")}, nil),
+		// client 3 inserts "y" at the same position concurrently
+		encodeV1(t, []ygo.Block{item(ygo.ID{Client: 3, Clock: 0}, &a, &b, "y")}, nil),
+		// client 4 inserts "z" in front and deletes "b"
+		encodeV1(t, []ygo.Block{item(ygo.ID{Client: 4, Clock: 0}, nil, &a, "z")},
+			[]ygo.BlockRange{{ID: b, Len: 1}}),
+	}
+	for _, order := range permutations(len(updates)) {
+		store := ygo.NewDocStore()
+		for _, i := range order {
+			applyV1(t, store, updates[i])
+		}
+		assert.Nil(t, store.PendingStructs, "order %v", order)
+		assert.Nil(t, store.PendingDeleteSet, "order %v", order)
+		branch := rootType(t, store, "text")
+		assert.Equal(t, "zaxwy", visibleText(branch), "order %v", order)
+		assert.Equal(t, uint32(5), branch.BlockLen, "order %v", order)
+		assert.Equal(t, uint32(5), branch.ContentLen, "order %v", order)
+	}
+}
+
+func TestItem_integrateMapEntries(t *testing.T) {
+	entry := func(id ygo.ID, origin *ygo.ID, value any) *ygo.Item {
+		root := "map"
+		key := "key"
+		return ygo.NewItem(id, nil, origin, nil, nil,
+			ygo.TypePtr{Named: &root}, &key, &ygo.AnyContent{Values: anyValues(value)})
+	}
+	first := ygo.ID{Client: 1, Clock: 0}
+	second := ygo.ID{Client: 2, Clock: 0}
+	updates := [][]uint8{
+		encodeV1(t, []ygo.Block{entry(first, nil, 1)}, nil),
+		encodeV1(t, []ygo.Block{entry(second, nil, 2)}, nil),
+	}
+	for _, order := range permutations(len(updates)) {
+		store := ygo.NewDocStore()
+		deleted := ygo.NewDeleteSet()
+		for _, i := range order {
+			ds := applyV1(t, store, updates[i])
+			deleted.Merge(&ds)
+		}
+		// the set by the higher client ID wins
+		branch := rootType(t, store, "map")
+		current := branch.Map["key"]
+		assert.Equal(t, second, current.ID, "order %v", order)
+		assert.False(t, current.IsDeleted())
+		assert.True(t, current.Left.IsDeleted())
+		assert.True(t, deleted.Contains(first))
+		assert.Equal(t, uint32(0), branch.BlockLen)
+
+		// an entry set after both replaces the winner, the key is copied
+		// from its origin
+		applyV1(t, store, encodeV1(t, []ygo.Block{entry(ygo.ID{Client: 1, Clock: 1}, &second, 3)}, nil))
+		current = branch.Map["key"]
+		assert.Equal(t, ygo.ID{Client: 1, Clock: 1}, current.ID)
+		assert.Equal(t, "key", *current.ParentSub)
+		assert.True(t, current.Left.IsDeleted())
+	}
+}
+
+func TestItem_integrateDeletedContent(t *testing.T) {
+	root := "array"
+	store := ygo.NewDocStore()
+	deleted := applyV1(t, store, encodeV1(t, []ygo.Block{
+		ygo.NewItem(ygo.ID{Client: 1, Clock: 0}, nil, nil, nil, nil,
+			ygo.TypePtr{Named: &root}, nil, &ygo.AnyContent{Values: anyValues(1)}),
+		ygo.NewItem(ygo.ID{Client: 1, Clock: 1}, nil, &ygo.ID{Client: 1, Clock: 0}, nil, nil,
+			ygo.TypePtr{Unknown: &ygo.Unknown{}}, nil, &ygo.DeletedContent{Length: 2}),
+	}, nil))
+	assert.True(t, store.Blocks.Get(ygo.ID{Client: 1, Clock: 1}).IsDeleted())
+	assert.Equal(t, ranges(1, 3), deleted.Get(1))
+	assert.Equal(t, uint32(1), rootType(t, store, "array").BlockLen)
+
+	// the encoded state keeps the deletion
+	ds := ygo.NewDeleteSetFromStore(&store.Blocks)
+	assert.Equal(t, ranges(1, 3), ds.Get(1))
+}
+
+func TestItem_deleteType(t *testing.T) {
+	root := "array"
+	array := ygo.ID{Client: 1, Clock: 0}
+	store := ygo.NewDocStore()
+	applyV1(t, store, encodeV1(t, []ygo.Block{
+		ygo.NewItem(array, nil, nil, nil, nil,
+			ygo.TypePtr{Named: &root}, nil, &ygo.TypeContent{TypeRef: ygo.TYPE_REFS_ARRAY}),
+		ygo.NewItem(ygo.ID{Client: 1, Clock: 1}, nil, nil, nil, nil,
+			ygo.TypePtr{ID: &array}, nil, &ygo.AnyContent{Values: anyValues(1, 2)}),
+	}, nil))
+	branch := rootType(t, store, root)
+	nested := branch.Start.Content.(*ygo.TypeContent).Branch
+	assert.Equal(t, uint32(1), branch.BlockLen)
+	assert.Equal(t, uint32(2), nested.BlockLen)
+	assert.Equal(t, branch.Start, nested.Item)
+
+	deleted := applyV1(t, store, encodeV1(t, nil, []ygo.BlockRange{{ID: array, Len: 1}}))
+	assert.Equal(t, uint32(0), branch.BlockLen)
+	assert.Equal(t, uint32(0), nested.BlockLen)
+	assert.True(t, nested.Start.IsDeleted())
+	assert.True(t, deleted.Contains(ygo.ID{Client: 1, Clock: 2}))
+
+	// items inserted into the deleted type are deleted right away
+	deleted = applyV1(t, store, encodeV1(t, []ygo.Block{
+		ygo.NewItem(ygo.ID{Client: 2, Clock: 0}, nil, nil, nil, nil,
+			ygo.TypePtr{ID: &array}, nil, &ygo.AnyContent{Values: anyValues(3)}),
+	}, nil))
+	assert.True(t, deleted.Contains(ygo.ID{Client: 2, Clock: 0}))
+	assert.Equal(t, uint32(0), nested.BlockLen)
+}
+
+// replica edits the "text" root of its own store, every edit is encoded as
+// an update.
+type replica struct {
+	client ygo.ClientID
+	store  *ygo.DocStore
+}
+
+func (r *replica) insert(t *testing.T, index uint32, str string) []uint8 {
+	var left *ygo.Item
+	right := rootType(t, r.store, "text").Start
+	for right != nil {
+		if !right.IsDeleted() {
+			if index < right.Length {
+				if index > 0 {
+					split, err := r.store.Blocks.GetItemCleanStart(ygo.ID{Client: right.ID.Client, Clock: right.ID.Clock + index})
+					assert.Nil(t, err)
+					left, right = right, split.(*ygo.Item)
+				}
+				break
+			}
+			index -= right.Length
+		}
+		left, right = right, right.Right
+	}
+	var origin, rightOrigin *ygo.ID
+	if left != nil {
+		id := left.LastId()
+		origin = &id
+	}
+	if right != nil {
+		rightOrigin = &right.ID
+	}
+	root := "text"
+	item := ygo.NewItem(ygo.ID{Client: r.client, Clock: r.store.Blocks.GetState(r.client)}, nil, origin, nil, rightOrigin,
+		ygo.TypePtr{Named: &root}, nil, &ygo.StringContent{Str: str})
+	buf := encodeV1(t, []ygo.Block{item}, nil)
+	applyV1(t, r.store, buf)
+	return buf
+}
+
+func (r *replica) delete(t *testing.T, index uint32) []uint8 {
+	for item := rootType(t, r.store, "text").Start; item != nil; item = item.Right {
+		if item.IsDeleted() {
+			continue
+		}
+		if index < item.Length {
+			id := ygo.ID{Client: item.ID.Client, Clock: item.ID.Clock + index}
+			buf := encodeV1(t, nil, []ygo.BlockRange{{ID: id, Len: 1}})
+			applyV1(t, r.store, buf)
+			return buf
+		}
+		index -= item.Length
+	}
+	t.Fatalf("no element at %v", index)
+	return nil
+}
+
+func TestItem_integrateConverges(t *testing.T) {
+	// 1792315242924995786 once left blocks pending which could be
+	// integrated
+	for _, seed := range []uint64{1, 2, 3, 4, 5, 42, 1792315242924995786} {
+		t.Run(fmt.Sprint(seed), func(t *testing.T) {
+			testConverges(t, seed)
+		})
+	}
+}
+
+func testConverges(t *testing.T, seed uint64) {
+	rng := rand.New(rand.NewPCG(seed, seed))
+	replicas := make([]*replica, 3)
+	for i := range replicas {
+		replicas[i] = &replica{client: ygo.ClientID(i + 1), store: ygo.NewDocStore()}
+	}
+	var log [][]uint8
+	received := make([]int, len(replicas))
+	for range 30 {
+		// every replica edits without knowing the edits of the others
+		for _, r := range replicas {
+			for range rng.IntN(4) {
+				length := rootType(t, r.store, "text").BlockLen
+				if length > 0 && rng.IntN(3) == 0 {
+					log = append(log, r.delete(t, rng.Uint32N(length)))
+				} else {
+					str := strings.Repeat(string(rune('a'+rng.IntN(26))), 1+rng.IntN(3))
+					log = append(log, r.insert(t, rng.Uint32N(length+1), str))
+				}
+			}
+		}
+		// then receives the updates it hasn't seen in any order
+		for i, r := range replicas {
+			for _, j := range rng.Perm(len(log) - received[i]) {
+				applyV1(t, r.store, log[received[i]+j])
+			}
+			received[i] = len(log)
+		}
+		expected := visibleText(rootType(t, replicas[0].store, "text"))
+		for _, r := range replicas {
+			branch := rootType(t, r.store, "text")
+			assert.Nil(t, r.store.PendingStructs)
+			assert.Equal(t, expected, visibleText(branch))
+			assert.Equal(t, uint32(len(expected)), branch.BlockLen)
+			assert.Equal(t, uint32(len(expected)), branch.ContentLen)
+		}
+		if t.Failed() {
+			t.FailNow()
+		}
+	}
+
+	// a new replica receiving all updates in any order ends up the same
+	store := ygo.NewDocStore()
+	for _, i := range rng.Perm(len(log)) {
+		applyV1(t, store, log[i])
+	}
+	assert.Nil(t, store.PendingStructs)
+	assert.Nil(t, store.PendingDeleteSet)
+	assert.Equal(t, visibleText(rootType(t, replicas[0].store, "text")), visibleText(rootType(t, store, "text")))
+}
+
+func TestItem_getMissing(t *testing.T) {
+	store := ygo.NewBlockStore()
+	assert.Nil(t, store.Push(textItem(1, 0, nil, "ab")))
+	known := ygo.ID{Client: 1, Clock: 1}
+	missing := ygo.ID{Client: 1, Clock: 2}
+	own := ygo.ID{Client: 2, Clock: 0}
+	root := "text"
+	tests := []struct {
+		name        string
+		origin      *ygo.ID
+		rightOrigin *ygo.ID
+		parent      ygo.TypePtr
+		content     ygo.ItemContent
+		expected    *ygo.ID
+	}{
+		{"known origin", &known, nil, ygo.TypePtr{Unknown: &ygo.Unknown{}}, &ygo.StringContent{Str: "x"}, nil},
+		{"own client", &own, nil, ygo.TypePtr{Unknown: &ygo.Unknown{}}, &ygo.StringContent{Str: "x"}, nil},
+		{"origin", &missing, nil, ygo.TypePtr{Unknown: &ygo.Unknown{}}, &ygo.StringContent{Str: "x"}, &missing},
+		{"right origin", &known, &missing, ygo.TypePtr{Unknown: &ygo.Unknown{}}, &ygo.StringContent{Str: "x"}, &missing},
+		{"parent", nil, nil, ygo.TypePtr{ID: &missing}, &ygo.StringContent{Str: "x"}, &missing},
+		{"move", nil, nil, ygo.TypePtr{Named: &root}, &ygo.MoveContent{Start: known, End: missing}, &missing},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			item := ygo.NewItem(ygo.ID{Client: 2, Clock: 1}, nil, tt.origin, nil, tt.rightOrigin, tt.parent, nil, tt.content)
+			id, ok := item.GetMissing(&store)
+			assert.Equal(t, tt.expected != nil, ok)
+			if tt.expected != nil {
+				assert.Equal(t, *tt.expected, id)
+			}
+		})
+	}
+}
+
+func TestItem_repair(t *testing.T) {
+	store := ygo.NewBlockStore()
+	assert.Nil(t, store.Push(textItem(1, 0, nil, "abc")))
+	item := ygo.NewItem(ygo.ID{Client: 2, Clock: 0}, nil, &ygo.ID{Client: 1, Clock: 0}, nil, &ygo.ID{Client: 1, Clock: 1},
+		ygo.TypePtr{Unknown: &ygo.Unknown{}}, nil, &ygo.StringContent{Str: "x"})
+	assert.Nil(t, item.Repair(&store))
+
+	// "abc" is split between the origins
+	assert.Equal(t, 2, store.GetClient(1).Len())
+	assert.Equal(t, "a", item.Left.Content.(*ygo.StringContent).Str)
+	assert.Equal(t, "bc", item.Right.Content.(*ygo.StringContent).Str)
+	assert.Equal(t, item.Left.Parent, item.Parent)
+	assert.Equal(t, "text", *item.Parent.Named)
+	assert.Nil(t, item.ParentSub)
+}
+
+func TestItem_repairCollected(t *testing.T) {
+	root := "array"
+	array := ygo.ID{Client: 1, Clock: 0}
+	store := ygo.NewDocStore()
+	applyV1(t, store, encodeV1(t, []ygo.Block{
+		ygo.NewItem(array, nil, nil, nil, nil,
+			ygo.TypePtr{Named: &root}, nil, &ygo.TypeContent{TypeRef: ygo.TYPE_REFS_ARRAY}),
+		ygo.NewItem(ygo.ID{Client: 1, Clock: 1}, nil, nil, nil, nil,
+			ygo.TypePtr{ID: &array}, nil, &ygo.AnyContent{Values: anyValues(1, 2)}),
+	}, nil))
+	before := store.Blocks.GetStateVector()
+	deleted := applyV1(t, store, encodeV1(t, nil, []ygo.BlockRange{{ID: array, Len: 1}}))
+	store.Cleanup(&deleted, &before, true)
+	assert.True(t, store.Blocks.Get(ygo.ID{Client: 1, Clock: 1}).IsGc())
+
+	tests := []struct {
+		name string
+		item *ygo.Item
+	}{
+		{"origin", ygo.NewItem(ygo.ID{Client: 2, Clock: 0}, nil, &ygo.ID{Client: 1, Clock: 1}, nil, nil,
+			ygo.TypePtr{Unknown: &ygo.Unknown{}}, nil, &ygo.AnyContent{Values: anyValues(3)})},
+		{"right origin", ygo.NewItem(ygo.ID{Client: 3, Clock: 0}, nil, nil, nil, &ygo.ID{Client: 1, Clock: 2},
+			ygo.TypePtr{Unknown: &ygo.Unknown{}}, nil, &ygo.AnyContent{Values: anyValues(3)})},
+		{"parent", ygo.NewItem(ygo.ID{Client: 4, Clock: 0}, nil, nil, nil, nil,
+			ygo.TypePtr{ID: &array}, nil, &ygo.AnyContent{Values: anyValues(3)})},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// the parent of the item is gone, it's kept as a GC block
+			applyV1(t, store, encodeV1(t, []ygo.Block{tt.item}, nil))
+			assert.Nil(t, store.PendingStructs)
+			block := store.Blocks.Get(tt.item.ID)
+			assert.True(t, block.IsGc())
+			assert.Equal(t, uint32(1), block.Len())
+		})
+	}
+}
@@ -1,5 +1,16 @@
 package common
 
+import (
+	"cmp"
+	"fmt"
+	"iter"
+	"math"
+	"slices"
+
+	"riguz.com/ygo/internal/lib0"
+)
+
+// Range is a half-open range of clocks, [Start, End).
 type Range struct {
 	Start uint64
 	End   uint64
@@ -19,6 +30,7 @@ type Fragmented struct {
 
 var _ OrderRange = &Range{}
 var _ OrderRange = &Fragmented{}
+var _ OrderRange = &RangeSet{}
 
 func NewRange(start uint64, end uint64) Range {
 	return Range{Start: start, End: end}
@@ -32,7 +44,7 @@ func NewFragmented(r []Range) Fragmented {
 
 func isRangeCovered(oldRange *Range, newVec *[]Range) bool {
 	for _, newRange := range *newVec {
-		if newRange.Contains(oldRange.Start) && newRange.Contains(oldRange.End) {
+		if newRange.Start <= oldRange.Start && oldRange.End <= newRange.End {
 			return true
 		}
 	}
@@ -48,45 +60,17 @@ func CheckRangeCovered(oldVect *[]Range, newVec *[]Range) bool {
 	return true
 }
 
-// diff_range returns the difference between the old range and the new
-// range. current range must be covered by the new range
+// DiffRange returns the clocks of the new ranges which are not part of the
+// old ranges. Every old range must be covered by a new range, otherwise the
+// result is empty.
 func DiffRange(oldVec *[]Range, newVec *[]Range) []Range {
 	if !CheckRangeCovered(oldVec, newVec) {
 		return []Range{}
 	}
-
-	diffs := []Range{}
-	oldIndex := 0
-	for _, n := range *newVec {
-		/**
-		----------- ---------------- ----
-		             ~~~~   ~~~~~~    ~~
-					    overlaps
-		*/
-		overlapRanges := []Range{}
-		for oldIndex < len(*oldVec) && (*oldVec)[oldIndex].Start <= n.End {
-			overlapRanges = append(overlapRanges, (*oldVec)[oldIndex])
-			oldIndex += 1
-		}
-		if len(overlapRanges) == 0 {
-			diffs = append(diffs, n)
-		} else {
-			lastEnd := overlapRanges[0].Start
-			if lastEnd > n.Start {
-				diffs = append(diffs, NewRange(n.Start, lastEnd))
-			}
-			for _, o := range overlapRanges {
-				if o.Start > lastEnd {
-					diffs = append(diffs, NewRange(lastEnd, o.Start))
-				}
-				lastEnd = o.End
-			}
-			if n.End > lastEnd {
-				diffs = append(diffs, NewRange(lastEnd, n.End))
-			}
-		}
-	}
-	return diffs
+	oldSet := NewRangeSet(*oldVec...)
+	newSet := NewRangeSet(*newVec...)
+	diff := newSet.Subtract(&oldSet)
+	return diff.GetRanges()
 }
 
 func (r *Range) GetRanges() []Range {
@@ -101,8 +85,16 @@ func (r *Range) RangesLength() int {
 	return 1
 }
 
+// Len returns the number of clocks in the range.
+func (r *Range) Len() uint64 {
+	if r.IsEmpty() {
+		return 0
+	}
+	return r.End - r.Start
+}
+
 func (r *Range) Contains(clock uint64) bool {
-	return clock >= r.Start && clock <= r.End
+	return clock >= r.Start && clock < r.End
 }
 
 func (r *Range) DiffRange(newRange OrderRange) []Range {
@@ -138,111 +130,223 @@ func (r *Fragmented) DiffRange(newRange OrderRange) []Range {
 	return DiffRange(&oldVec, &newVec)
 }
 
-// func (r *IdRange) IsContinuous() bool {
-// 	return r.continuous != nil
-// }
-
-// func (r *IdRange) IsFragmented() bool {
-// 	return r.fragmented != nil
-// }
-
-// func (r *IdRange) Invert() IdRange {
-// 	if r.IsContinuous() {
-// 		return NewContinuous(0, r.continuous.Start)
-// 	} else {
-// 		inv := []Range{}
-// 		var start uint32 = 0
-// 		for _, i := range *r.fragmented {
-// 			if i.Start > start {
-// 				inv = append(inv, NewRange(start, i.Start))
-// 			}
-// 			start = i.End
-// 		}
-// 		len := len(inv)
-// 		switch len {
-// 		case 0:
-// 			return NewContinuous(0, 0)
-// 		case 1:
-// 			return NewContinuous(inv[0].Start, inv[0].End)
-// 		default:
-// 			return NewFragmented(&inv)
-// 		}
-// 	}
-// }
-
-// func (r *IdRange) Contains(clock uint32) bool {
-// 	if r.IsContinuous() {
-// 		return r.continuous.Contains(clock)
-// 	} else {
-// 		for _, i := range *r.fragmented {
-// 			if i.Contains(clock) {
-// 				return true
-// 			}
-// 		}
-// 		return false
-// 	}
-// }
-
-// func (r *IdRange) pushContinuous(rg Range) {
-// 	if r.continuous.End >= r.continuous.Start {
-// 		if rg.Start > r.continuous.End {
-// 			//     start     end
-// 			//                   rg.start      rg.end
-// 			r.fragmented = &[]Range{*r.continuous, rg}
-// 			r.continuous = nil
-// 		} else {
-// 			if rg.Start < r.continuous.Start {
-// 				r.continuous.Start = rg.Start
-// 			}
-// 			if rg.End > r.continuous.End {
-// 				r.continuous.End = rg.End
-// 			}
-// 		}
-
-// 	} else {
-// 		//             start     end
-// 		//     rg.end
-// 		r.fragmented = &[]Range{rg, *r.continuous}
-// 		r.continuous = nil
-// 	}
-// }
-
-// func (r *IdRange) pushFragmented(rg Range) {
-// 	if len((*r.fragmented)) == 0 {
-// 		r.fragmented = nil
-// 		r.continuous = &rg
-// 	} else {
-// 		lastIdx := len((*r.fragmented)) - 1
-// 		last := &(*r.fragmented)[lastIdx]
-// 		if !tryJoin(last, &rg) {
-// 			ranges := append((*r.fragmented), rg)
-// 			r.fragmented = &ranges
-// 		}
-// 	}
-// }
-
-// func (r *IdRange) push(rg Range) {
-// 	if r.IsContinuous() {
-// 		r.pushContinuous(rg)
-// 	} else {
-// 		r.pushFragmented(rg)
-// 	}
-// }
-
-// func tryJoin(a *Range, b *Range) bool {
-// 	if disjoint(a, b) {
-// 		return false
-// 	} else {
-// 		if b.Start < a.Start {
-// 			a.Start = b.Start
-// 		}
-// 		if b.End > a.End {
-// 			a.End = b.End
-// 		}
-// 		return true
-// 	}
-// }
-
-// func disjoint(a *Range, b *Range) bool {
-// 	return a.Start > b.End || b.Start > a.End
-// }
+// RangeSet is a set of clocks. It's kept as sorted half-open ranges which
+// neither overlap nor touch each other, so every set has exactly one
+// representation.
+type RangeSet struct {
+	ranges []Range
+}
+
+// NewRangeSet returns the set of clocks in any of the given ranges.
+func NewRangeSet(ranges ...Range) RangeSet {
+	s := RangeSet{}
+	for _, r := range ranges {
+		s.Push(r)
+	}
+	return s
+}
+
+// Push adds the clocks of r, joining it with the ranges it overlaps or
+// touches. Appending in order, which is the common case, is O(1).
+func (s *RangeSet) Push(r Range) {
+	if r.IsEmpty() {
+		return
+	}
+	n := len(s.ranges)
+	if n == 0 || s.ranges[n-1].End < r.Start {
+		s.ranges = append(s.ranges, r)
+		return
+	}
+	// the first range which ends at or after r starts, it's joined with r
+	first, _ := slices.BinarySearchFunc(s.ranges, r.Start, func(e Range, start uint64) int {
+		return cmp.Compare(e.End, start)
+	})
+	// the ranges from first up to last start at or before r ends
+	last := first
+	for last < n && s.ranges[last].Start <= r.End {
+		last++
+	}
+	if first == last {
+		s.ranges = slices.Insert(s.ranges, first, r)
+		return
+	}
+	joined := NewRange(min(r.Start, s.ranges[first].Start), max(r.End, s.ranges[last-1].End))
+	s.ranges[first] = joined
+	s.ranges = slices.Delete(s.ranges, first+1, last)
+}
+
+func (s *RangeSet) GetRanges() []Range {
+	return s.ranges
+}
+
+// All iterates over the ranges in ascending order.
+func (s *RangeSet) All() iter.Seq[Range] {
+	return slices.Values(s.ranges)
+}
+
+// Clocks iterates over every clock of the set in ascending order.
+func (s *RangeSet) Clocks() iter.Seq[uint64] {
+	return func(yield func(uint64) bool) {
+		for _, r := range s.ranges {
+			for clock := r.Start; clock < r.End; clock++ {
+				if !yield(clock) {
+					return
+				}
+			}
+		}
+	}
+}
+
+func (s *RangeSet) IsEmpty() bool {
+	return len(s.ranges) == 0
+}
+
+func (s *RangeSet) RangesLength() int {
+	return len(s.ranges)
+}
+
+// Len returns the number of clocks in the set.
+func (s *RangeSet) Len() uint64 {
+	var len uint64
+	for _, r := range s.ranges {
+		len += r.End - r.Start
+	}
+	return len
+}
+
+func (s *RangeSet) Contains(clock uint64) bool {
+	i, found := slices.BinarySearchFunc(s.ranges, clock, func(r Range, clock uint64) int {
+		return cmp.Compare(r.Start, clock)
+	})
+	return found || (i > 0 && clock < s.ranges[i-1].End)
+}
+
+func (s *RangeSet) DiffRange(newRange OrderRange) []Range {
+	oldVec := s.GetRanges()
+	newVec := newRange.GetRanges()
+	return DiffRange(&oldVec, &newVec)
+}
+
+// Union returns the clocks in s or other.
+func (s *RangeSet) Union(other *RangeSet) RangeSet {
+	result := RangeSet{ranges: slices.Clone(s.ranges)}
+	for _, r := range other.ranges {
+		result.Push(r)
+	}
+	return result
+}
+
+// Intersection returns the clocks in both s and other.
+func (s *RangeSet) Intersection(other *RangeSet) RangeSet {
+	result := RangeSet{}
+	i, j := 0, 0
+	for i < len(s.ranges) && j < len(other.ranges) {
+		a, b := s.ranges[i], other.ranges[j]
+		if start, end := max(a.Start, b.Start), min(a.End, b.End); start < end {
+			result.ranges = append(result.ranges, NewRange(start, end))
+		}
+		if a.End < b.End {
+			i++
+		} else {
+			j++
+		}
+	}
+	return result
+}
+
+// Subtract returns the clocks in s but not in other.
+func (s *RangeSet) Subtract(other *RangeSet) RangeSet {
+	result := RangeSet{}
+	j := 0
+	for _, r := range s.ranges {
+		start := r.Start
+		for j < len(other.ranges) && other.ranges[j].End <= start {
+			j++
+		}
+		for k := j; k < len(other.ranges) && other.ranges[k].Start < r.End; k++ {
+			if other.ranges[k].Start > start {
+				result.ranges = append(result.ranges, NewRange(start, other.ranges[k].Start))
+			}
+			start = max(start, other.ranges[k].End)
+		}
+		if start < r.End {
+			result.ranges = append(result.ranges, NewRange(start, r.End))
+		}
+	}
+	return result
+}
+
+// Invert returns the gaps of the set: the clocks from 0 up to the end of
+// the last range which are not in the set.
+func (s *RangeSet) Invert() RangeSet {
+	result := RangeSet{}
+	var start uint64
+	for _, r := range s.ranges {
+		if r.Start > start {
+			result.ranges = append(result.ranges, NewRange(start, r.Start))
+		}
+		start = r.End
+	}
+	return result
+}
+
+// RangeEncoder is implemented by the update encoders, a range set is
+// written like the ranges of a single client of a delete set.
+type RangeEncoder interface {
+	ResetDsCurVal()
+	WriteVarUint(num uint) error
+	WriteDsClock(clock uint32) error
+	WriteDsLen(len uint32) error
+}
+
+// RangeDecoder is implemented by the update decoders.
+type RangeDecoder interface {
+	ResetDsCurVal()
+	ReadVarUint() (uint64, error)
+	ReadDsClock() (uint32, error)
+	ReadDsLen() (uint32, error)
+	Limits() lib0.Limits
+}
+
+func (s *RangeSet) Encode(encoder RangeEncoder) error {
+	encoder.ResetDsCurVal()
+	if err := encoder.WriteVarUint(uint(len(s.ranges))); err != nil {
+		return err
+	}
+	for _, r := range s.ranges {
+		if r.End > math.MaxUint32 {
+			return fmt.Errorf("range exceeds max clock: %v", r)
+		}
+		if err := encoder.WriteDsClock(uint32(r.Start)); err != nil {
+			return err
+		}
+		if err := encoder.WriteDsLen(uint32(r.End - r.Start)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Decode reads ranges written by Encode and adds them to the set.
+func (s *RangeSet) Decode(decoder RangeDecoder) error {
+	decoder.ResetDsCurVal()
+	len, err := decoder.ReadVarUint()
+	if err != nil {
+		return err
+	}
+	if max := decoder.Limits().MaxElements; max > 0 && len > max {
+		return fmt.Errorf("%w: range count %d is larger than %d", lib0.ErrLimitExceeded, len, max)
+	}
+	for range len {
+		clock, err := decoder.ReadDsClock()
+		if err != nil {
+			return err
+		}
+		rangeLen, err := decoder.ReadDsLen()
+		if err != nil {
+			return err
+		}
+		s.Push(NewRange(uint64(clock), uint64(clock)+uint64(rangeLen)))
+	}
+	return nil
+}
@@ -1,9 +1,14 @@
 package common_test
 
 import (
+	"encoding/hex"
+	"fmt"
+	"math/rand/v2"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"riguz.com/ygo/internal/lib0"
+	"riguz.com/ygo/pkg/ygo"
 	"riguz.com/ygo/pkg/ygo/common"
 )
 
@@ -91,3 +96,183 @@ func assertRange(t *testing.T, expected []uint64, actual *[]common.Range) {
 		i += 1
 	}
 }
+
+func TestRange_halfOpen(t *testing.T) {
+	r := common.NewRange(2, 4)
+	assert.False(t, r.Contains(1))
+	assert.True(t, r.Contains(2))
+	assert.True(t, r.Contains(3))
+	assert.False(t, r.Contains(4))
+	assert.Equal(t, uint64(2), r.Len())
+	empty := common.NewRange(4, 4)
+	assert.True(t, empty.IsEmpty())
+	assert.False(t, empty.Contains(4))
+	assert.Equal(t, uint64(0), empty.Len())
+}
+
+func TestRangeSet_push(t *testing.T) {
+	tests := []struct {
+		name     string
+		ranges   []uint64
+		push     []uint64
+		expected []uint64
+	}{
+		{"append", []uint64{0, 2}, []uint64{3, 4}, []uint64{0, 2, 3, 4}},
+		{"append touching", []uint64{0, 2}, []uint64{2, 4}, []uint64{0, 4}},
+		{"prepend", []uint64{5, 6}, []uint64{0, 2}, []uint64{0, 2, 5, 6}},
+		{"prepend touching", []uint64{5, 6}, []uint64{3, 5}, []uint64{3, 6}},
+		{"between", []uint64{0, 1, 8, 9}, []uint64{4, 5}, []uint64{0, 1, 4, 5, 8, 9}},
+		{"bridge", []uint64{0, 2, 4, 6, 8, 9}, []uint64{2, 4}, []uint64{0, 6, 8, 9}},
+		{"cover all", []uint64{1, 2, 4, 6, 8, 9}, []uint64{0, 10}, []uint64{0, 10}},
+		{"contained", []uint64{0, 10}, []uint64{3, 4}, []uint64{0, 10}},
+		{"empty", []uint64{0, 1}, []uint64{5, 5}, []uint64{0, 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := common.NewRangeSet(toRanges(tt.ranges)...)
+			s.Push(toRanges(tt.push)[0])
+			assert.Equal(t, toRanges(tt.expected), s.GetRanges())
+		})
+	}
+}
+
+func TestRangeSet_iterate(t *testing.T) {
+	s := common.NewRangeSet(common.NewRange(5, 7), common.NewRange(1, 3))
+	var clocks []uint64
+	for clock := range s.Clocks() {
+		clocks = append(clocks, clock)
+	}
+	assert.Equal(t, []uint64{1, 2, 5, 6}, clocks)
+	var ranges []common.Range
+	for r := range s.All() {
+		ranges = append(ranges, r)
+	}
+	assert.Equal(t, s.GetRanges(), ranges)
+	assert.Equal(t, uint64(4), s.Len())
+	assert.Equal(t, 2, s.RangesLength())
+
+	inverted := s.Invert()
+	assert.Equal(t, toRanges([]uint64{0, 1, 3, 5}), inverted.GetRanges())
+}
+
+func TestRangeSet_encode(t *testing.T) {
+	s := common.NewRangeSet(common.NewRange(0, 1), common.NewRange(3, 5))
+	v1 := ygo.NewEncoderV1()
+	assert.Nil(t, s.Encode(&v1))
+	assert.Equal(t, "0200010302", hex.EncodeToString(v1.ToBytes()))
+	decoder := ygo.NewDecoderV1FromBytes(v1.ToBytes())
+	decoded := common.RangeSet{}
+	assert.Nil(t, decoded.Decode(&decoder))
+	assert.Equal(t, s.GetRanges(), decoded.GetRanges())
+
+	v2 := ygo.NewEncoderV2()
+	assert.Nil(t, s.Encode(&v2))
+	bufV2, err := v2.ToBytes()
+	assert.Nil(t, err)
+	decoderV2, err := ygo.NewDecoderV2FromBytes(bufV2)
+	assert.Nil(t, err)
+	decoded = common.RangeSet{}
+	assert.Nil(t, decoded.Decode(&decoderV2))
+	assert.Equal(t, s.GetRanges(), decoded.GetRanges())
+}
+
+func TestRangeSet_decodeWithLimits(t *testing.T) {
+	buf, _ := hex.DecodeString("03000102010401")
+	r := lib0.NewSliceRead(buf)
+	r.SetLimits(lib0.Limits{MaxElements: 2})
+	decoder := ygo.NewDecoderV1WithRead(&r)
+	decoded := common.RangeSet{}
+	assert.ErrorIs(t, decoded.Decode(&decoder), lib0.ErrLimitExceeded)
+
+	r = lib0.NewSliceRead(buf)
+	r.SetLimits(lib0.Limits{MaxElements: 3})
+	decoder = ygo.NewDecoderV1WithRead(&r)
+	assert.Nil(t, decoded.Decode(&decoder))
+	assert.Equal(t, uint64(3), decoded.Len())
+}
+
+// bitset is the naive model the range sets are checked against.
+type bitset [64]bool
+
+func randomRangeSet(rng *rand.Rand) (common.RangeSet, bitset) {
+	var bits bitset
+	s := common.RangeSet{}
+	for range rng.IntN(6) {
+		start := rng.Uint64N(64)
+		end := start + rng.Uint64N(64-start+1)
+		s.Push(common.NewRange(start, end))
+		for clock := start; clock < end; clock++ {
+			bits[clock] = true
+		}
+	}
+	return s, bits
+}
+
+func assertBitset(t *testing.T, expected bitset, actual common.RangeSet) {
+	t.Helper()
+	var bits bitset
+	var len uint64
+	for clock := range actual.Clocks() {
+		bits[clock] = true
+		len++
+	}
+	assert.Equal(t, expected, bits, "%v", actual.GetRanges())
+	assert.Equal(t, len, actual.Len())
+	for clock, set := range expected {
+		assert.Equal(t, set, actual.Contains(uint64(clock)))
+	}
+	// the representation is canonical: sorted, neither empty nor touching
+	ranges := actual.GetRanges()
+	for i, r := range ranges {
+		assert.Less(t, r.Start, r.End)
+		if i > 0 {
+			assert.Less(t, ranges[i-1].End, r.Start)
+		}
+	}
+}
+
+func TestRangeSet_randomized(t *testing.T) {
+	for _, seed := range []uint64{1, 2, 3, 4, 5, 42} {
+		t.Run(fmt.Sprint(seed), func(t *testing.T) {
+			testRandomized(t, seed)
+		})
+	}
+}
+
+func testRandomized(t *testing.T, seed uint64) {
+	rng := rand.New(rand.NewPCG(seed, seed))
+	for range 2000 {
+		a, aBits := randomRangeSet(rng)
+		b, bBits := randomRangeSet(rng)
+		assertBitset(t, aBits, a)
+
+		var union, intersection, difference, inverted bitset
+		last := 0
+		for clock := range aBits {
+			union[clock] = aBits[clock] || bBits[clock]
+			intersection[clock] = aBits[clock] && bBits[clock]
+			difference[clock] = aBits[clock] && !bBits[clock]
+			if aBits[clock] {
+				last = clock + 1
+			}
+		}
+		for clock := range last {
+			inverted[clock] = !aBits[clock]
+		}
+		assertBitset(t, union, a.Union(&b))
+		assertBitset(t, intersection, a.Intersection(&b))
+		assertBitset(t, difference, a.Subtract(&b))
+		assertBitset(t, inverted, a.Invert())
+		if t.Failed() {
+			t.FailNow()
+		}
+	}
+}
+
+func toRanges(clocks []uint64) []common.Range {
+	var result []common.Range
+	for i := 0; i < len(clocks); i += 2 {
+		result = append(result, common.NewRange(clocks[i], clocks[i+1]))
+	}
+	return result
+}
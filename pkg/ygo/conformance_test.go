@@ -0,0 +1,99 @@
+package ygo_test
+
+import (
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"riguz.com/ygo/pkg/ygo"
+)
+
+// fixtures returns the contents of the files matching pattern in testdata,
+// keyed by file name without the given suffix.
+func fixtures(t *testing.T, pattern string, suffix string) map[string][]uint8 {
+	paths, err := filepath.Glob(filepath.Join("testdata", pattern))
+	assert.Nil(t, err)
+	assert.NotEmpty(t, paths, "no fixtures match %v", pattern)
+	result := make(map[string][]uint8)
+	for _, path := range paths {
+		buf, err := os.ReadFile(path)
+		assert.Nil(t, err)
+		result[strings.TrimSuffix(filepath.Base(path), suffix)] = buf
+	}
+	return result
+}
+
+func TestConformance_updates(t *testing.T) {
+	v2Fixtures := fixtures(t, "updates/*.v2.bin", ".v2.bin")
+	for name, v1 := range fixtures(t, "updates/*.v1.bin", ".v1.bin") {
+		t.Run(name, func(t *testing.T) {
+			v2, ok := v2Fixtures[name]
+			assert.True(t, ok, "missing V2 fixture")
+
+			fromV1 := ygo.NewUpdate()
+			assert.Nil(t, fromV1.DecodeV1(v1))
+			encoded, err := fromV1.EncodeV1()
+			assert.Nil(t, err)
+			assert.Equal(t, hex.EncodeToString(v1), hex.EncodeToString(encoded))
+
+			fromV2 := ygo.NewUpdate()
+			assert.Nil(t, fromV2.DecodeV2(v2))
+			encoded, err = fromV2.EncodeV2()
+			assert.Nil(t, err)
+			assert.Equal(t, hex.EncodeToString(v2), hex.EncodeToString(encoded))
+
+			converted, err := ygo.ConvertUpdateFormatV1ToV2(v1)
+			assert.Nil(t, err)
+			assert.Equal(t, hex.EncodeToString(v2), hex.EncodeToString(converted))
+			converted, err = ygo.ConvertUpdateFormatV2ToV1(v2)
+			assert.Nil(t, err)
+			assert.Equal(t, hex.EncodeToString(v1), hex.EncodeToString(converted))
+		})
+	}
+}
+
+func TestConformance_stateVectors(t *testing.T) {
+	updates := fixtures(t, "updates/*.v1.bin", ".v1.bin")
+	for name, buf := range fixtures(t, "state_vectors/*.bin", ".bin") {
+		t.Run(name, func(t *testing.T) {
+			sv, err := ygo.DecodeStateVector(buf)
+			assert.Nil(t, err)
+			encoded, err := sv.EncodeV1()
+			assert.Nil(t, err)
+			assert.Equal(t, hex.EncodeToString(buf), hex.EncodeToString(encoded))
+
+			if update, ok := updates[name]; ok {
+				fromUpdate, err := ygo.EncodeStateVectorFromUpdate(update)
+				assert.Nil(t, err)
+				assert.Equal(t, hex.EncodeToString(buf), hex.EncodeToString(fromUpdate))
+			}
+		})
+	}
+}
+
+func TestConformance_deleteSets(t *testing.T) {
+	for name, buf := range fixtures(t, "delete_sets/*.v1.bin", ".v1.bin") {
+		t.Run(name, func(t *testing.T) {
+			ds := ygo.NewDeleteSet()
+			assert.Nil(t, ds.DecodeV1(buf))
+			encoded, err := ds.EncodeV1()
+			assert.Nil(t, err)
+			assert.Equal(t, hex.EncodeToString(buf), hex.EncodeToString(encoded))
+		})
+	}
+}
+
+func TestConformance_snapshots(t *testing.T) {
+	for name, buf := range fixtures(t, "snapshots/*.v1.bin", ".v1.bin") {
+		t.Run(name, func(t *testing.T) {
+			snapshot, err := ygo.DecodeSnapshot(buf)
+			assert.Nil(t, err)
+			encoded, err := snapshot.EncodeV1()
+			assert.Nil(t, err)
+			assert.Equal(t, hex.EncodeToString(buf), hex.EncodeToString(encoded))
+		})
+	}
+}
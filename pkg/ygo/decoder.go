@@ -4,12 +4,13 @@ import (
 	"fmt"
 	"io"
 	"math"
+	"unicode/utf16"
 
 	"riguz.com/ygo/internal/lib0"
 )
 
 type Decode interface {
-	Decode(decoder *Decoder)
+	Decode(decoder Decoder) error
 	DecodeV1([]uint8) error
 	DecodeV2([]uint8) error
 }
@@ -26,6 +27,7 @@ type Decoder interface {
 	ReadParentInfo() (bool, error)
 	ReadTypeRef() (uint8, error)
 	ReadLen() (uint32, error)
+	ReadJson() (lib0.Any, error)
 	ReadKey() (*string, error)
 }
 
@@ -42,6 +44,23 @@ func NewDecoderV1(reader io.Reader) DecoderV1 {
 	}
 }
 
+// NewDecoderV1FromBytes decodes buf in place, byte arrays read from it are
+// sub-slices of buf.
+func NewDecoderV1FromBytes(buf []uint8) DecoderV1 {
+	r := lib0.NewSliceRead(buf)
+	return DecoderV1{
+		cursor: &r,
+	}
+}
+
+// NewDecoderV1WithRead decodes from r, which makes it possible to use a
+// reader configured with custom lib0.Limits.
+func NewDecoderV1WithRead(r lib0.Read) DecoderV1 {
+	return DecoderV1{
+		cursor: r,
+	}
+}
+
 func (d *DecoderV1) ReadUint8Array(len uint) ([]uint8, error) { return d.cursor.ReadUint8Array(len) }
 func (d *DecoderV1) ReadUint8() (uint8, error)                { return d.cursor.ReadUint8() }
 func (d *DecoderV1) ReadUint16() (uint16, error)              { return d.cursor.ReadUint16() }
@@ -55,7 +74,8 @@ func (d *DecoderV1) ReadVarUint8Array() ([]uint8, error)      { return d.cursor.
 func (d *DecoderV1) ReadVarUint() (uint64, error)             { return d.cursor.ReadVarUint() }
 func (d *DecoderV1) ReadVarInt() (int64, error)               { return d.cursor.ReadVarInt() }
 func (d *DecoderV1) ReadVarString() (string, error)           { return d.cursor.ReadVarString() }
-func (d *DecoderV1) ReadAny() (any, error)                    { return d.cursor.ReadAny() }
+func (d *DecoderV1) ReadAny() (lib0.Any, error)               { return d.cursor.ReadAny() }
+func (d *DecoderV1) Limits() lib0.Limits                      { return d.cursor.Limits() }
 
 func (d *DecoderV1) readVarUint32() (uint32, error) {
 	num, err := d.ReadVarUint()
@@ -121,14 +141,460 @@ func (d *DecoderV1) ReadParentInfo() (bool, error) {
 }
 
 func (d *DecoderV1) ReadTypeRef() (uint8, error) {
-	return d.ReadUint8()
+	v, err := d.readVarUint32()
+	if err != nil {
+		return 0, err
+	}
+	if v > math.MaxUint8 {
+		return 0, fmt.Errorf("type ref exceeds max uint8 range: %v", v)
+	}
+	return uint8(v), nil
 }
 
 func (d *DecoderV1) ReadLen() (uint32, error) {
 	return d.readVarUint32()
 }
 
+func (d *DecoderV1) ReadJson() (lib0.Any, error) {
+	str, err := d.ReadVarString()
+	if err != nil {
+		return lib0.Any{}, err
+	}
+	var value lib0.Any
+	if err := value.UnmarshalJSON([]byte(str)); err != nil {
+		return lib0.Any{}, err
+	}
+	return value, nil
+}
+
 func (d *DecoderV1) ReadKey() (*string, error) {
 	str, err := d.ReadVarString()
 	return &str, err
 }
+
+var _ Decoder = &DecoderV2{}
+
+type DecoderV2 struct {
+	cursor            lib0.Read
+	keys              []string
+	dsCurrVal         uint32
+	keyClockDecoder   *IntDiffOptRleDecoder
+	clientDecoder     *UIntOptRleDecoder
+	leftClockDecoder  *IntDiffOptRleDecoder
+	rightClockDecoder *IntDiffOptRleDecoder
+	infoDecoder       *RleDecoder
+	stringDecoder     *StringDecoder
+	parentInfoDecoder *RleDecoder
+	typeRefDecoder    *UIntOptRleDecoder
+	lenDecoder        *UIntOptRleDecoder
+}
+
+// NewDecoderV2 reads the column headers written by EncoderV2. Everything
+// following the columns is kept as the rest cursor, which is where plain
+// lib0 values (var ints, any, buffers) are read from.
+func NewDecoderV2(reader io.Reader) (DecoderV2, error) {
+	r := lib0.NewBufferRead(reader)
+	return newDecoderV2(&r)
+}
+
+// NewDecoderV2FromBytes decodes buf in place, the columns and byte arrays
+// read from it are sub-slices of buf.
+func NewDecoderV2FromBytes(buf []uint8) (DecoderV2, error) {
+	r := lib0.NewSliceRead(buf)
+	return newDecoderV2(&r)
+}
+
+// NewDecoderV2WithRead decodes from r, which makes it possible to use a
+// reader configured with custom lib0.Limits.
+func NewDecoderV2WithRead(r lib0.Read) (DecoderV2, error) {
+	return newDecoderV2(r)
+}
+
+func newDecoderV2(r lib0.Read) (DecoderV2, error) {
+	// feature flag, currently unused
+	if _, err := r.ReadVarUint(); err != nil {
+		return DecoderV2{}, err
+	}
+	columns := make([][]uint8, 9)
+	for i := range columns {
+		buf, err := r.ReadVarUint8Array()
+		if err != nil {
+			return DecoderV2{}, err
+		}
+		columns[i] = buf
+	}
+	// the columns come from the same input, so they share the limits
+	limits := r.Limits()
+	keyClockDecoder := newIntDiffOptRleDecoder(columns[0], limits)
+	clientDecoder := newUIntOptRleDecoder(columns[1], limits)
+	leftClockDecoder := newIntDiffOptRleDecoder(columns[2], limits)
+	rightClockDecoder := newIntDiffOptRleDecoder(columns[3], limits)
+	infoDecoder := newRleDecoder(columns[4], limits)
+	stringDecoder, err := newStringDecoder(columns[5], limits)
+	if err != nil {
+		return DecoderV2{}, err
+	}
+	parentInfoDecoder := newRleDecoder(columns[6], limits)
+	typeRefDecoder := newUIntOptRleDecoder(columns[7], limits)
+	lenDecoder := newUIntOptRleDecoder(columns[8], limits)
+	return DecoderV2{
+		cursor:            r,
+		keys:              []string{},
+		dsCurrVal:         0,
+		keyClockDecoder:   &keyClockDecoder,
+		clientDecoder:     &clientDecoder,
+		leftClockDecoder:  &leftClockDecoder,
+		rightClockDecoder: &rightClockDecoder,
+		infoDecoder:       &infoDecoder,
+		stringDecoder:     &stringDecoder,
+		parentInfoDecoder: &parentInfoDecoder,
+		typeRefDecoder:    &typeRefDecoder,
+		lenDecoder:        &lenDecoder,
+	}, nil
+}
+
+func (d *DecoderV2) ReadUint8Array(len uint) ([]uint8, error) { return d.cursor.ReadUint8Array(len) }
+func (d *DecoderV2) ReadUint8() (uint8, error)                { return d.cursor.ReadUint8() }
+func (d *DecoderV2) ReadUint16() (uint16, error)              { return d.cursor.ReadUint16() }
+func (d *DecoderV2) ReadUint32() (uint32, error)              { return d.cursor.ReadUint32() }
+func (d *DecoderV2) ReadUint32BigEndian() (uint32, error)     { return d.cursor.ReadUint32BigEndian() }
+func (d *DecoderV2) ReadUint64() (uint64, error)              { return d.cursor.ReadUint64() }
+func (d *DecoderV2) ReadFloat32() (float32, error)            { return d.cursor.ReadFloat32() }
+func (d *DecoderV2) ReadFloat64() (float64, error)            { return d.cursor.ReadFloat64() }
+func (d *DecoderV2) ReadInt64() (int64, error)                { return d.cursor.ReadInt64() }
+func (d *DecoderV2) ReadVarUint8Array() ([]uint8, error)      { return d.cursor.ReadVarUint8Array() }
+func (d *DecoderV2) ReadVarUint() (uint64, error)             { return d.cursor.ReadVarUint() }
+func (d *DecoderV2) ReadVarInt() (int64, error)               { return d.cursor.ReadVarInt() }
+func (d *DecoderV2) ReadAny() (lib0.Any, error)               { return d.cursor.ReadAny() }
+func (d *DecoderV2) Limits() lib0.Limits                      { return d.cursor.Limits() }
+
+// ReadVarString reads from the string column rather than the rest cursor,
+// strings nested in any values are still read from the rest cursor.
+func (d *DecoderV2) ReadVarString() (string, error) { return d.stringDecoder.Read() }
+
+func (d *DecoderV2) readVarUint32() (uint32, error) {
+	num, err := d.ReadVarUint()
+	if err != nil {
+		return 0, err
+	}
+	if num > math.MaxUint32 {
+		return 0, fmt.Errorf("var int exceeds max uint32 range: %v", num)
+	}
+	return uint32(num), err
+}
+
+func (d *DecoderV2) ResetDsCurVal() {
+	d.dsCurrVal = 0
+}
+
+func (d *DecoderV2) ReadDsClock() (uint32, error) {
+	diff, err := d.readVarUint32()
+	if err != nil {
+		return 0, err
+	}
+	d.dsCurrVal += diff
+	return d.dsCurrVal, nil
+}
+
+func (d *DecoderV2) ReadDsLen() (uint32, error) {
+	diff, err := d.readVarUint32()
+	if err != nil {
+		return 0, err
+	}
+	diff += 1
+	d.dsCurrVal += diff
+	return diff, nil
+}
+
+func (d *DecoderV2) ReadLeftId() (ID, error) {
+	client, err := d.ReadClient()
+	if err != nil {
+		return ID{}, err
+	}
+	clock, err := d.leftClockDecoder.Read()
+	if err != nil {
+		return ID{}, err
+	}
+	return ID{Client: client, Clock: clock}, nil
+}
+
+func (d *DecoderV2) ReadRightId() (ID, error) {
+	client, err := d.ReadClient()
+	if err != nil {
+		return ID{}, err
+	}
+	clock, err := d.rightClockDecoder.Read()
+	if err != nil {
+		return ID{}, err
+	}
+	return ID{Client: client, Clock: clock}, nil
+}
+
+func (d *DecoderV2) ReadClient() (ClientID, error) {
+	client, err := d.clientDecoder.Read()
+	return ClientID(client), err
+}
+
+func (d *DecoderV2) ReadInfo() (uint8, error) {
+	return d.infoDecoder.Read()
+}
+
+func (d *DecoderV2) ReadParentInfo() (bool, error) {
+	v, err := d.parentInfoDecoder.Read()
+	if err != nil {
+		return false, err
+	}
+	return v == 1, nil
+}
+
+func (d *DecoderV2) ReadTypeRef() (uint8, error) {
+	v, err := d.typeRefDecoder.Read()
+	if err != nil {
+		return 0, err
+	}
+	if v > math.MaxUint8 {
+		return 0, fmt.Errorf("type ref exceeds max uint8 range: %v", v)
+	}
+	return uint8(v), nil
+}
+
+func (d *DecoderV2) ReadLen() (uint32, error) {
+	len, err := d.lenDecoder.Read()
+	if err != nil {
+		return 0, err
+	}
+	if len > math.MaxUint32 {
+		return 0, fmt.Errorf("len exceeds max uint32 range: %v", len)
+	}
+	return uint32(len), nil
+}
+
+func (d *DecoderV2) ReadJson() (lib0.Any, error) {
+	return d.ReadAny()
+}
+
+func (d *DecoderV2) ReadKey() (*string, error) {
+	keyClock, err := d.keyClockDecoder.Read()
+	if err != nil {
+		return nil, err
+	}
+	if int(keyClock) < len(d.keys) {
+		key := d.keys[keyClock]
+		return &key, nil
+	}
+	key, err := d.stringDecoder.Read()
+	if err != nil {
+		return nil, err
+	}
+	d.keys = append(d.keys, key)
+	return &key, nil
+}
+
+type IntDiffOptRleDecoder struct {
+	buf   lib0.SliceRead
+	last  uint32
+	count uint32
+	diff  int32
+}
+
+func NewIntDiffOptRleDecoder(buf []uint8) IntDiffOptRleDecoder {
+	return newIntDiffOptRleDecoder(buf, lib0.DefaultLimits)
+}
+
+func newIntDiffOptRleDecoder(buf []uint8, limits lib0.Limits) IntDiffOptRleDecoder {
+	return IntDiffOptRleDecoder{
+		buf:   newColumnRead(buf, limits),
+		last:  0,
+		count: 0,
+		diff:  0,
+	}
+}
+
+func (i *IntDiffOptRleDecoder) Read() (uint32, error) {
+	if i.count == 0 {
+		diff, err := i.buf.ReadVarInt()
+		if err != nil {
+			return 0, err
+		}
+		// the lowest bit tells whether a count follows
+		hasCount := diff&1 == 1
+		i.diff = int32(diff >> 1)
+		i.count = 1
+		if hasCount {
+			count, err := readRunCount(&i.buf)
+			if err != nil {
+				return 0, err
+			}
+			i.count = uint32(count) + 2
+		}
+	}
+	i.last = uint32(int32(i.last) + i.diff)
+	i.count -= 1
+	return i.last, nil
+}
+
+type UIntOptRleDecoder struct {
+	buf   lib0.SliceRead
+	last  uint64
+	count uint32
+}
+
+func NewUIntOptRleDecoder(buf []uint8) UIntOptRleDecoder {
+	return newUIntOptRleDecoder(buf, lib0.DefaultLimits)
+}
+
+func newUIntOptRleDecoder(buf []uint8, limits lib0.Limits) UIntOptRleDecoder {
+	return UIntOptRleDecoder{
+		buf:   newColumnRead(buf, limits),
+		last:  0,
+		count: 0,
+	}
+}
+
+func (u *UIntOptRleDecoder) Read() (uint64, error) {
+	if u.count == 0 {
+		value, isNegative, err := u.buf.ReadVarIntWithSign()
+		if err != nil {
+			return 0, err
+		}
+		u.count = 1
+		// a negative value (including negative zero) is followed by a count
+		if isNegative {
+			value = -value
+			count, err := readRunCount(&u.buf)
+			if err != nil {
+				return 0, err
+			}
+			u.count = uint32(count) + 2
+		}
+		u.last = uint64(value)
+	}
+	u.count -= 1
+	return u.last, nil
+}
+
+// same as:
+// var decoder = new decoding.RleDecoder(buf, decoding.readUint8);
+type RleDecoder struct {
+	buf  lib0.SliceRead
+	last uint8
+	// -1 means the last value is repeated forever
+	count int64
+}
+
+func NewRleDecoder(buf []uint8) RleDecoder {
+	return newRleDecoder(buf, lib0.DefaultLimits)
+}
+
+func newRleDecoder(buf []uint8, limits lib0.Limits) RleDecoder {
+	return RleDecoder{
+		buf:   newColumnRead(buf, limits),
+		last:  0,
+		count: 0,
+	}
+}
+
+func (r *RleDecoder) Read() (uint8, error) {
+	if r.count == 0 {
+		value, err := r.buf.ReadUint8()
+		if err != nil {
+			return 0, err
+		}
+		r.last = value
+		if r.buf.HasContent() {
+			// the encoder stores count - 1
+			count, err := readRunCount(&r.buf)
+			if err != nil {
+				return 0, err
+			}
+			r.count = int64(count) + 1
+		} else {
+			r.count = -1
+		}
+	}
+	r.count -= 1
+	return r.last, nil
+}
+
+type StringDecoder struct {
+	str        []uint16
+	pos        int
+	lenDecoder UIntOptRleDecoder
+}
+
+func NewStringDecoder(buf []uint8) (StringDecoder, error) {
+	return newStringDecoder(buf, lib0.DefaultLimits)
+}
+
+func newStringDecoder(buf []uint8, limits lib0.Limits) (StringDecoder, error) {
+	lenDecoder := newUIntOptRleDecoder(buf, limits)
+	str := ""
+	if len(buf) > 0 {
+		s, err := lenDecoder.buf.ReadVarString()
+		if err != nil {
+			return StringDecoder{}, err
+		}
+		str = s
+	}
+	return StringDecoder{
+		str:        utf16.Encode([]rune(str)),
+		pos:        0,
+		lenDecoder: lenDecoder,
+	}, nil
+}
+
+// Read returns the next string, lengths are stored as UTF-16 code units.
+func (s *StringDecoder) Read() (string, error) {
+	utf16Len, err := s.lenDecoder.Read()
+	if err != nil {
+		return "", err
+	}
+	end := s.pos + int(utf16Len)
+	if end > len(s.str) {
+		return "", fmt.Errorf("string decoder out of range: %v > %v", end, len(s.str))
+	}
+	str := string(utf16.Decode(s.str[s.pos:end]))
+	s.pos = end
+	return str, nil
+}
+
+func newColumnRead(buf []uint8, limits lib0.Limits) lib0.SliceRead {
+	r := lib0.NewSliceRead(buf)
+	r.SetLimits(limits)
+	return r
+}
+
+// readRunCount reads the length of a run. A run repeats a value without
+// consuming any more input, so its length is bounded by MaxElements.
+func readRunCount(r *lib0.SliceRead) (uint64, error) {
+	count, err := r.ReadVarUint()
+	if err != nil {
+		return 0, err
+	}
+	if err := checkElements(r, "run length", count); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// readCount reads the number of elements that follow, which must not be
+// larger than the MaxElements limit of the decoder.
+func readCount(decoder Decoder, what string) (uint64, error) {
+	count, err := decoder.ReadVarUint()
+	if err != nil {
+		return 0, err
+	}
+	if err := checkElements(decoder, what, count); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// checkElements fails with lib0.ErrLimitExceeded if a count read from the
+// input is larger than the MaxElements limit of the reader.
+func checkElements(r lib0.Read, what string, count uint64) error {
+	if max := r.Limits().MaxElements; max > 0 && count > max {
+		return fmt.Errorf("%w: %s %d is larger than %d", lib0.ErrLimitExceeded, what, count, max)
+	}
+	return nil
+}
@@ -0,0 +1,161 @@
+package ygo_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"testing"
+
+	"gotest.tools/assert"
+	"riguz.com/ygo/pkg/ygo"
+)
+
+func TestIntDiffOptRleDecoder_read(t *testing.T) {
+	var tests = []struct {
+		hex      string
+		expected []uint32
+	}{
+		{"030142", []uint32{1, 2, 3, 2}},
+		{"0301420104", []uint32{1, 2, 3, 2, 2, 2, 2, 2, 2, 2}},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("read IntDiffOptRleDecoder:%s", tt.hex), func(t *testing.T) {
+			buf, _ := hex.DecodeString(tt.hex)
+			decoder := ygo.NewIntDiffOptRleDecoder(buf)
+			for _, e := range tt.expected {
+				value, err := decoder.Read()
+				assert.NilError(t, err)
+				assert.Equal(t, e, value)
+			}
+		})
+	}
+}
+
+func TestUIntOptRleDecoder_read(t *testing.T) {
+	var tests = []struct {
+		hex      string
+		expected []uint64
+	}{
+		{"01024301", []uint64{1, 2, 3, 3, 3}},
+		{"010203bfff079dcd96938801", []uint64{1, 2, 3, 65535, 18273719133}},
+		// negative zero marks a run of zeros
+		{"400101", []uint64{0, 0, 0, 1}},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("read UIntOptRleDecoder:%s", tt.hex), func(t *testing.T) {
+			buf, _ := hex.DecodeString(tt.hex)
+			decoder := ygo.NewUIntOptRleDecoder(buf)
+			for _, e := range tt.expected {
+				value, err := decoder.Read()
+				assert.NilError(t, err)
+				assert.Equal(t, e, value)
+			}
+		})
+	}
+}
+
+func TestRleDecoder_read(t *testing.T) {
+	var tests = []struct {
+		hex      string
+		expected []uint8
+	}{
+		{"010207", []uint8{1, 1, 1, 7, 7, 7}},
+		{"010002000300ff", []uint8{1, 2, 3, 255, 255}},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("read RleDecoder:%s", tt.hex), func(t *testing.T) {
+			buf, _ := hex.DecodeString(tt.hex)
+			decoder := ygo.NewRleDecoder(buf)
+			for _, e := range tt.expected {
+				value, err := decoder.Read()
+				assert.NilError(t, err)
+				assert.Equal(t, e, value)
+			}
+		})
+	}
+}
+
+func TestStringDecoder_read(t *testing.T) {
+	var tests = []struct {
+		hex      string
+		expected []string
+	}{
+		{"0361626303", []string{"abc"}},
+		{"0c48656c6c6f20776f726c64210c", []string{"Hello world!"}},
+		{"04f09090b702", []string{"𐐷"}},
+		{"1b48656c6c6f2ce4b8ade59bbdefbc81f09090b7f09090b7f09090b7060207", []string{"Hello,", "中国", "！𐐷𐐷𐐷"}},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("read StringDecoder:%s", tt.hex), func(t *testing.T) {
+			buf, _ := hex.DecodeString(tt.hex)
+			decoder, err := ygo.NewStringDecoder(buf)
+			assert.NilError(t, err)
+			for _, e := range tt.expected {
+				value, err := decoder.Read()
+				assert.NilError(t, err)
+				assert.Equal(t, e, value)
+			}
+		})
+	}
+}
+
+func TestDecoderV2_read(t *testing.T) {
+	/*
+		const doc = new Y.Doc()
+		doc.clientID = 1
+		doc.getText("text").insert(0, "abc")
+		console.log(toHexString(Y.encodeStateAsUpdateV2(doc)))
+	*/
+	buf, _ := hex.DecodeString("00000101000001040a077465787461626304030101000001010000")
+	decoder, err := ygo.NewDecoderV2(bytes.NewBuffer(buf))
+	assert.NilError(t, err)
+
+	clients, err := decoder.ReadVarUint()
+	assert.NilError(t, err)
+	assert.Equal(t, uint64(1), clients)
+	structs, err := decoder.ReadVarUint()
+	assert.NilError(t, err)
+	assert.Equal(t, uint64(1), structs)
+	client, err := decoder.ReadClient()
+	assert.NilError(t, err)
+	assert.Equal(t, ygo.ClientID(1), client)
+	clock, err := decoder.ReadVarUint()
+	assert.NilError(t, err)
+	assert.Equal(t, uint64(0), clock)
+	info, err := decoder.ReadInfo()
+	assert.NilError(t, err)
+	assert.Equal(t, ygo.BLOCK_ITEM_STRING_REF_NUMBER, info)
+	isYKey, err := decoder.ReadParentInfo()
+	assert.NilError(t, err)
+	assert.Equal(t, true, isYKey)
+	parent, err := decoder.ReadVarString()
+	assert.NilError(t, err)
+	assert.Equal(t, "text", parent)
+	str, err := decoder.ReadVarString()
+	assert.NilError(t, err)
+	assert.Equal(t, "abc", str)
+	deleteSetClients, err := decoder.ReadVarUint()
+	assert.NilError(t, err)
+	assert.Equal(t, uint64(0), deleteSetClients)
+}
+
+func TestDecoderV2_readDeleteSet(t *testing.T) {
+	// clock 3, len 2 followed by clock 10, len 1, delta encoded
+	buf, _ := hex.DecodeString("0000000000000000000003010500")
+	decoder, err := ygo.NewDecoderV2(bytes.NewBuffer(buf))
+	assert.NilError(t, err)
+
+	decoder.ResetDsCurVal()
+	clock, err := decoder.ReadDsClock()
+	assert.NilError(t, err)
+	assert.Equal(t, uint32(3), clock)
+	len, err := decoder.ReadDsLen()
+	assert.NilError(t, err)
+	assert.Equal(t, uint32(2), len)
+	clock, err = decoder.ReadDsClock()
+	assert.NilError(t, err)
+	assert.Equal(t, uint32(10), clock)
+	len, err = decoder.ReadDsLen()
+	assert.NilError(t, err)
+	assert.Equal(t, uint32(1), len)
+}
@@ -68,6 +68,74 @@ func NewDocWithOptions(options DocOptions) *Doc {
 	return &Doc{
 		clientId: options.ClientId,
 		options:  options,
-		// todo
+		store:    NewDocStore(),
 	}
 }
+
+// ApplyUpdate applies a V1 encoded update to the document. Parts of the
+// update which depend on updates that weren't applied yet are kept until
+// those arrive, see Pending.
+func (d *Doc) ApplyUpdate(update []uint8) error {
+	decoded := NewUpdate()
+	if err := decoded.DecodeV1(update); err != nil {
+		return err
+	}
+	return d.applyUpdate(decoded)
+}
+
+// ApplyUpdateV2 works like ApplyUpdate for V2 encoded updates.
+func (d *Doc) ApplyUpdateV2(update []uint8) error {
+	decoded := NewUpdate()
+	if err := decoded.DecodeV2(update); err != nil {
+		return err
+	}
+	return d.applyUpdate(decoded)
+}
+
+func (d *Doc) applyUpdate(update *Update) error {
+	before := d.store.Blocks.GetStateVector()
+	deleted, err := d.store.ApplyUpdate(update)
+	if err != nil {
+		return err
+	}
+	d.store.Cleanup(&deleted, &before, d.options.Gc)
+	return nil
+}
+
+// GetText returns the root type called name as text.
+func (d *Doc) GetText(name string) (*Text, error) {
+	branch, err := d.store.GetOrCreateType(name, TYPE_REFS_TEXT)
+	if err != nil {
+		return nil, err
+	}
+	return NewText(branch), nil
+}
+
+// GetArray returns the root type called name as array.
+func (d *Doc) GetArray(name string) (*Array, error) {
+	branch, err := d.store.GetOrCreateType(name, TYPE_REFS_ARRAY)
+	if err != nil {
+		return nil, err
+	}
+	return NewArray(branch), nil
+}
+
+// GetMap returns the root type called name as map.
+func (d *Doc) GetMap(name string) (*Map, error) {
+	branch, err := d.store.GetOrCreateType(name, TYPE_REFS_MAP)
+	if err != nil {
+		return nil, err
+	}
+	return NewMap(branch), nil
+}
+
+// Pending returns the blocks waiting for missing updates, or nil if there
+// are none. Its Missing state vector tells which updates to request.
+func (d *Doc) Pending() *PendingUpdate {
+	return d.store.PendingStructs
+}
+
+// StateVector returns the state of the blocks integrated into the document.
+func (d *Doc) StateVector() StateVector {
+	return d.store.Blocks.GetStateVector()
+}
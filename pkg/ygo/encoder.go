@@ -1,13 +1,13 @@
 package ygo
 
 import (
-	"unicode/utf16"
+	"fmt"
 
 	"riguz.com/ygo/internal/lib0"
 )
 
 type Encode interface {
-	Encode(encoder *Encoder)
+	Encode(encoder Encoder) error
 	EncodeV1() ([]uint8, error)
 	EncodeV2() ([]uint8, error)
 }
@@ -41,6 +41,15 @@ func NewEncoderV1() EncoderV1 {
 	}
 }
 
+// NewEncoderV1WithWrite encodes into w, which allows V1 updates to be
+// streamed with a lib0.StreamWrite. ToBytes only returns the bytes if w is
+// a lib0.BufferWrite.
+func NewEncoderV1WithWrite(w lib0.Write) EncoderV1 {
+	return EncoderV1{
+		buf: w,
+	}
+}
+
 func (e *EncoderV1) WriteUint8Array(buf []uint8) error     { return e.buf.WriteUint8Array(buf) }
 func (e *EncoderV1) WriteUint8(num uint8) error            { return e.buf.WriteUint8(num) }
 func (e *EncoderV1) WriteUint16(num uint16) error          { return e.buf.WriteUint16(num) }
@@ -63,13 +72,22 @@ func (e *EncoderV1) WriteVarInt64(num int64) error         { return e.buf.WriteV
 func (e *EncoderV1) WriteVarUint8Array(buf []uint8) error  { return e.buf.WriteVarUint8Array(buf) }
 func (e *EncoderV1) WriteVarString(str *string) error      { return e.buf.WriteVarString(str) }
 func (e *EncoderV1) WriteAny(a any) error                  { return e.buf.WriteAny(a) }
-func (e *EncoderV1) ToBytes() []uint8                      { return e.buf.ToBytes() }
+func (e *EncoderV1) Flush() error                          { return e.buf.Flush() }
+
+// ToBytes returns the encoded bytes, or nil if they were written to a
+// stream.
+func (e *EncoderV1) ToBytes() []uint8 {
+	if w, ok := e.buf.(*lib0.BufferWrite); ok {
+		return w.ToBytes()
+	}
+	return nil
+}
 
 func (e *EncoderV1) WriteId(id ID) error {
-	if err := e.buf.WriteVarInt(int(id.Client)); err != nil {
+	if err := e.buf.WriteVarUint64(uint64(id.Client)); err != nil {
 		return err
 	}
-	return e.buf.WriteVarInt(int(id.Clock))
+	return e.buf.WriteVarUint32(id.Clock)
 }
 
 func (e *EncoderV1) ResetDsCurVal() {
@@ -93,7 +111,7 @@ func (e *EncoderV1) WriteRightId(id ID) error {
 }
 
 func (e *EncoderV1) WriteClient(client ClientID) error {
-	return e.buf.WriteVarInt64(int64(client))
+	return e.buf.WriteVarUint64(uint64(client))
 }
 
 func (e *EncoderV1) WriteInfo(info uint8) error {
@@ -109,15 +127,25 @@ func (e *EncoderV1) WriteParentInfo(isYKey bool) error {
 }
 
 func (e *EncoderV1) WriteTypeRef(info uint8) error {
-	return e.buf.WriteUint8(info)
+	return e.buf.WriteVarUint8(info)
 }
 
 func (e *EncoderV1) WriteLen(len uint32) error {
 	return e.buf.WriteVarUint32(len)
 }
 
+// WriteJson writes data, an Any or a plain Go value, as a JSON string.
 func (e *EncoderV1) WriteJson(data any) error {
-	panic("todo")
+	value, err := lib0.AnyFromGo(data)
+	if err != nil {
+		return err
+	}
+	buf, err := value.MarshalJSON()
+	if err != nil {
+		return err
+	}
+	str := string(buf)
+	return e.buf.WriteVarString(&str)
 }
 
 func (e *EncoderV1) WriteKey(key *string) error {
@@ -222,7 +250,8 @@ func (u *UIntOptRleEncoder) flush() error {
 		if u.count == 1 {
 			return u.buf.WriteVarInt64(int64(u.last))
 		} else {
-			if err := u.buf.WriteVarInt64(-int64(u.last)); err != nil {
+			// the sign marks that a count follows, even for zero (-0)
+			if err := u.buf.WriteVarIntWithSign(int64(u.last), true); err != nil {
 				return err
 			}
 			if err := u.buf.WriteVarUint32(u.count - 2); err != nil {
@@ -299,17 +328,16 @@ func (s *StringEncoder) ToBytes() ([]uint8, error) {
 }
 
 func (s *StringEncoder) Write(str *string) error {
-	utf16Units := utf16.Encode([]rune(*str))
-	utf16Len := len(utf16Units)
 	s.str += *str
-	return s.lenEncoder.Write(uint64(utf16Len))
+	return s.lenEncoder.Write(uint64(utf16Len(*str)))
 }
 
+var _ Encoder = &EncoderV2{}
+
 type EncoderV2 struct {
-	buf               lib0.Write
-	keyTable          map[string]uint32
+	buf               *lib0.BufferWrite
 	dsCurrVal         uint32
-	seqeuncer         uint32
+	keyClock          uint32
 	keyClockEncoder   *IntDiffOptRleEncoder
 	clientEncoder     *UIntOptRleEncoder
 	leftClockEncoder  *IntDiffOptRleEncoder
@@ -321,7 +349,7 @@ type EncoderV2 struct {
 	lenEncoder        *UIntOptRleEncoder
 }
 
-func NewEncoerV2() EncoderV2 {
+func NewEncoderV2() EncoderV2 {
 	w := lib0.NewBufferWrite()
 	keyClockEncoder := NewIntDiffOptRleEncoder()
 	clientEncoder := NewUIntOptRleEncoder()
@@ -334,8 +362,7 @@ func NewEncoerV2() EncoderV2 {
 	lenEncoder := NewUIntOptRleEncoder()
 	return EncoderV2{
 		buf:               &w,
-		keyTable:          map[string]uint32{},
-		seqeuncer:         0,
+		keyClock:          0,
 		dsCurrVal:         0,
 		keyClockEncoder:   &keyClockEncoder,
 		clientEncoder:     &clientEncoder,
@@ -349,6 +376,113 @@ func NewEncoerV2() EncoderV2 {
 	}
 }
 
+func (e *EncoderV2) WriteUint8Array(buf []uint8) error     { return e.buf.WriteUint8Array(buf) }
+func (e *EncoderV2) WriteUint8(num uint8) error            { return e.buf.WriteUint8(num) }
+func (e *EncoderV2) WriteUint16(num uint16) error          { return e.buf.WriteUint16(num) }
+func (e *EncoderV2) WriteUint32(num uint32) error          { return e.buf.WriteUint32(num) }
+func (e *EncoderV2) WriteUint32BigEndian(num uint32) error { return e.buf.WriteUint32BigEndian(num) }
+func (e *EncoderV2) WriteUint64(num uint64) error          { return e.buf.WriteUint64(num) }
+func (e *EncoderV2) WriteFloat32(num float32) error        { return e.buf.WriteFloat32(num) }
+func (e *EncoderV2) WriteFloat64(num float64) error        { return e.buf.WriteFloat64(num) }
+func (e *EncoderV2) WriteInt64(num int64) error            { return e.buf.WriteInt64(num) }
+func (e *EncoderV2) WriteVarUint(num uint) error           { return e.buf.WriteVarUint(num) }
+func (e *EncoderV2) WriteVarUint8(num uint8) error         { return e.buf.WriteVarUint8(num) }
+func (e *EncoderV2) WriteVarUint16(num uint16) error       { return e.buf.WriteVarUint16(num) }
+func (e *EncoderV2) WriteVarUint32(num uint32) error       { return e.buf.WriteVarUint32(num) }
+func (e *EncoderV2) WriteVarUint64(num uint64) error       { return e.buf.WriteVarUint64(num) }
+func (e *EncoderV2) WriteVarInt(num int) error             { return e.buf.WriteVarInt(num) }
+func (e *EncoderV2) WriteVarInt8(num int8) error           { return e.buf.WriteVarInt8(num) }
+func (e *EncoderV2) WriteVarInt16(num int16) error         { return e.buf.WriteVarInt16(num) }
+func (e *EncoderV2) WriteVarInt32(num int32) error         { return e.buf.WriteVarInt32(num) }
+func (e *EncoderV2) WriteVarInt64(num int64) error         { return e.buf.WriteVarInt64(num) }
+func (e *EncoderV2) WriteVarUint8Array(buf []uint8) error  { return e.buf.WriteVarUint8Array(buf) }
+func (e *EncoderV2) WriteAny(a any) error                  { return e.buf.WriteAny(a) }
+
+// WriteVarString writes to the string column rather than the rest buffer,
+// strings nested in any values are still written to the rest buffer.
+func (e *EncoderV2) WriteVarString(str *string) error { return e.stringEncoder.Write(str) }
+
+func (e *EncoderV2) ResetDsCurVal() {
+	e.dsCurrVal = 0
+}
+
+func (e *EncoderV2) WriteDsClock(clock uint32) error {
+	diff := clock - e.dsCurrVal
+	e.dsCurrVal = clock
+	return e.buf.WriteVarUint32(diff)
+}
+
+func (e *EncoderV2) WriteDsLen(len uint32) error {
+	if len == 0 {
+		return fmt.Errorf("delete set range length must not be zero")
+	}
+	if err := e.buf.WriteVarUint32(len - 1); err != nil {
+		return err
+	}
+	e.dsCurrVal += len
+	return nil
+}
+
+func (e *EncoderV2) WriteLeftId(id ID) error {
+	if err := e.clientEncoder.Write(uint64(id.Client)); err != nil {
+		return err
+	}
+	return e.leftClockEncoder.Write(id.Clock)
+}
+
+func (e *EncoderV2) WriteRightId(id ID) error {
+	if err := e.clientEncoder.Write(uint64(id.Client)); err != nil {
+		return err
+	}
+	return e.rightClockEncoder.Write(id.Clock)
+}
+
+func (e *EncoderV2) WriteClient(client ClientID) error {
+	return e.clientEncoder.Write(uint64(client))
+}
+
+func (e *EncoderV2) WriteInfo(info uint8) error {
+	return e.infoEncoder.Write(info)
+}
+
+func (e *EncoderV2) WriteParentInfo(isYKey bool) error {
+	var i uint8 = 0
+	if isYKey {
+		i = 1
+	}
+	return e.parentInfoEncoder.Write(i)
+}
+
+func (e *EncoderV2) WriteTypeRef(info uint8) error {
+	return e.typeRefEncoder.Write(uint64(info))
+}
+
+func (e *EncoderV2) WriteLen(len uint32) error {
+	return e.lenEncoder.Write(uint64(len))
+}
+
+// Flush does nothing, V2 updates can't be streamed as the columns are only
+// known once everything has been written.
+func (e *EncoderV2) Flush() error {
+	return nil
+}
+
+func (e *EncoderV2) WriteJson(data any) error {
+	return e.buf.WriteAny(data)
+}
+
+func (e *EncoderV2) WriteKey(key *string) error {
+	// Yjs never reuses the keys it wrote (it would break older decoders),
+	// so every key is written out with a new clock. We do the same to stay
+	// byte-compatible.
+	if err := e.keyClockEncoder.Write(e.keyClock); err != nil {
+		return err
+	}
+	e.keyClock += 1
+	return e.stringEncoder.Write(key)
+}
+
+// ToBytes flushes all columns, followed by the rest buffer.
 func (e *EncoderV2) ToBytes() ([]uint8, error) {
 	keyClock, err := e.keyClockEncoder.ToBytes()
 	if err != nil {
@@ -380,16 +514,20 @@ func (e *EncoderV2) ToBytes() ([]uint8, error) {
 	if err != nil {
 		return nil, err
 	}
-	rest := e.buf.ToBytes()
 	writer := lib0.NewBufferWrite()
-	if err := writer.WriteUint8(0); err != nil {
+	// feature flag, currently unused
+	if err := writer.WriteVarUint(0); err != nil {
 		return nil, err
 	}
 	for _, arr := range [][]uint8{keyClock, client, leftClock, rightClock,
-		info, str, parentInfo, typeRef, len, rest} {
+		info, str, parentInfo, typeRef, len} {
 		if err := writer.WriteVarUint8Array(arr); err != nil {
 			return nil, err
 		}
 	}
+	// the rest buffer is appended without a length prefix
+	if err := writer.WriteUint8Array(e.buf.ToBytes()); err != nil {
+		return nil, err
+	}
 	return writer.ToBytes(), nil
 }
@@ -1,11 +1,13 @@
 package ygo_test
 
 import (
+	"bytes"
 	"encoding/hex"
 	"fmt"
 	"testing"
 
 	"gotest.tools/assert"
+	"riguz.com/ygo/internal/lib0"
 	"riguz.com/ygo/pkg/ygo"
 )
 
@@ -40,6 +42,7 @@ func TestUIntOptRleEncoder_write(t *testing.T) {
 		{[]uint64{}, ""},
 		{[]uint64{1, 2, 3, 3, 3}, "01024301"},
 		{[]uint64{1, 2, 3, 65535, 18273719133}, "010203bfff079dcd96938801"},
+		{[]uint64{0, 0, 0, 1}, "400101"},
 	}
 	for _, tt := range tests {
 		t.Run(fmt.Sprintf("write UIntOptRleEncoder:%s", tt.expected), func(t *testing.T) {
@@ -99,3 +102,113 @@ func TestStringEncoder_write(t *testing.T) {
 		})
 	}
 }
+
+func TestEncoderV2_write(t *testing.T) {
+	/*
+		const doc = new Y.Doc()
+		doc.clientID = 1
+		doc.getText("text").insert(0, "abc")
+		console.log(toHexString(Y.encodeStateAsUpdateV2(doc)))
+	*/
+	encoder := ygo.NewEncoderV2()
+	parent := "text"
+	str := "abc"
+	assert.NilError(t, encoder.WriteVarUint(1))
+	assert.NilError(t, encoder.WriteVarUint(1))
+	assert.NilError(t, encoder.WriteClient(1))
+	assert.NilError(t, encoder.WriteVarUint(0))
+	assert.NilError(t, encoder.WriteInfo(ygo.BLOCK_ITEM_STRING_REF_NUMBER))
+	assert.NilError(t, encoder.WriteParentInfo(true))
+	assert.NilError(t, encoder.WriteVarString(&parent))
+	assert.NilError(t, encoder.WriteVarString(&str))
+	assert.NilError(t, encoder.WriteVarUint(0))
+
+	buf, err := encoder.ToBytes()
+	assert.NilError(t, err)
+	assert.Equal(t, "00000101000001040a077465787461626304030101000001010000", hex.EncodeToString(buf))
+}
+
+func TestEncoderV2_writeDeleteSet(t *testing.T) {
+	encoder := ygo.NewEncoderV2()
+	encoder.ResetDsCurVal()
+	assert.NilError(t, encoder.WriteDsClock(3))
+	assert.NilError(t, encoder.WriteDsLen(2))
+	assert.NilError(t, encoder.WriteDsClock(10))
+	assert.NilError(t, encoder.WriteDsLen(1))
+	assert.ErrorContains(t, encoder.WriteDsLen(0), "must not be zero")
+
+	buf, err := encoder.ToBytes()
+	assert.NilError(t, err)
+	assert.Equal(t, "000000000000010000000003010500", hex.EncodeToString(buf))
+}
+
+func TestEncoderV2_writeIds(t *testing.T) {
+	encoder := ygo.NewEncoderV2()
+	assert.NilError(t, encoder.WriteLeftId(ygo.ID{Client: 1, Clock: 2}))
+	assert.NilError(t, encoder.WriteRightId(ygo.ID{Client: 1, Clock: 4}))
+	assert.NilError(t, encoder.WriteLeftId(ygo.ID{Client: 7, Clock: 3}))
+
+	buf, err := encoder.ToBytes()
+	assert.NilError(t, err)
+	decoder, err := ygo.NewDecoderV2(bytes.NewBuffer(buf))
+	assert.NilError(t, err)
+	left, err := decoder.ReadLeftId()
+	assert.NilError(t, err)
+	assert.Equal(t, ygo.ID{Client: 1, Clock: 2}, left)
+	right, err := decoder.ReadRightId()
+	assert.NilError(t, err)
+	assert.Equal(t, ygo.ID{Client: 1, Clock: 4}, right)
+	left, err = decoder.ReadLeftId()
+	assert.NilError(t, err)
+	assert.Equal(t, ygo.ID{Client: 7, Clock: 3}, left)
+}
+
+func TestEncoderV2_writeKey(t *testing.T) {
+	encoder := ygo.NewEncoderV2()
+	bold := "bold"
+	italic := "italic"
+	assert.NilError(t, encoder.WriteKey(&bold))
+	assert.NilError(t, encoder.WriteKey(&italic))
+	assert.NilError(t, encoder.WriteKey(&bold))
+
+	buf, err := encoder.ToBytes()
+	assert.NilError(t, err)
+	decoder, err := ygo.NewDecoderV2(bytes.NewBuffer(buf))
+	assert.NilError(t, err)
+	for _, expected := range []string{bold, italic, bold} {
+		key, err := decoder.ReadKey()
+		assert.NilError(t, err)
+		assert.Equal(t, expected, *key)
+	}
+}
+
+func TestEncoderV2_writeJson(t *testing.T) {
+	encoder := ygo.NewEncoderV2()
+	assert.NilError(t, encoder.WriteJson(map[string]any{"bold": true}))
+
+	buf, err := encoder.ToBytes()
+	assert.NilError(t, err)
+	decoder, err := ygo.NewDecoderV2(bytes.NewBuffer(buf))
+	assert.NilError(t, err)
+	value, err := decoder.ReadAny()
+	assert.NilError(t, err)
+	assert.DeepEqual(t, map[string]any{"bold": true}, value.ToGo())
+}
+
+func TestEncoderV1_stream(t *testing.T) {
+	update := ygo.NewUpdate()
+	root := "text"
+	update.Push(ygo.NewItem(ygo.ID{Client: 1, Clock: 0}, nil, nil, nil, nil,
+		ygo.TypePtr{Named: &root}, nil, &ygo.StringContent{Str: "abc"}))
+	update.DeleteSet().Insert(ygo.ID{Client: 1, Clock: 1}, 1)
+	expected, err := update.EncodeV1()
+	assert.NilError(t, err)
+
+	out := &bytes.Buffer{}
+	w := lib0.NewStreamWrite(out)
+	defer w.Close()
+	encoder := ygo.NewEncoderV1WithWrite(&w)
+	assert.NilError(t, update.Encode(&encoder))
+	assert.NilError(t, encoder.Flush())
+	assert.Equal(t, hex.EncodeToString(expected), hex.EncodeToString(out.Bytes()))
+}
@@ -1 +1,264 @@
 package ygo
+
+import (
+	"cmp"
+	"fmt"
+	"math"
+	"slices"
+
+	"riguz.com/ygo/pkg/ygo/common"
+)
+
+// IdSet holds clock ranges per client. Ranges are half-open, [Start, End).
+type IdSet struct {
+	clients map[ClientID][]common.Range
+}
+
+// DeleteSet is the IdSet of the deleted blocks, every update ends with one.
+type DeleteSet = IdSet
+
+var _ Encode = &IdSet{}
+var _ Decode = &IdSet{}
+
+func NewIdSet() IdSet {
+	return IdSet{
+		clients: make(map[ClientID][]common.Range),
+	}
+}
+
+func NewDeleteSet() DeleteSet {
+	return NewIdSet()
+}
+
+// NewDeleteSetFromStore collects the deleted blocks of store, adjacent
+// deleted blocks form a single range.
+func NewDeleteSetFromStore(store *BlockStore) DeleteSet {
+	ds := NewDeleteSet()
+	for client, list := range store.clients {
+		var ranges []common.Range
+		for i := 0; i < list.Len(); i++ {
+			block := list.Get(i)
+			if !block.IsDeleted() {
+				continue
+			}
+			start := uint64(block.Id().Clock)
+			end := start + uint64(block.Len())
+			for i+1 < list.Len() && list.Get(i+1).IsDeleted() {
+				i++
+				end += uint64(list.Get(i).Len())
+			}
+			ranges = append(ranges, common.NewRange(start, end))
+		}
+		if len(ranges) > 0 {
+			ds.clients[client] = ranges
+		}
+	}
+	return ds
+}
+
+func (s *IdSet) IsEmpty() bool {
+	for _, ranges := range s.clients {
+		if len(ranges) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Insert adds len clocks starting at id. Ranges are appended as is, they
+// are neither sorted nor joined until Squash is called.
+func (s *IdSet) Insert(id ID, len uint32) {
+	if len == 0 {
+		return
+	}
+	start := uint64(id.Clock)
+	s.clients[id.Client] = append(s.clients[id.Client], common.NewRange(start, start+uint64(len)))
+}
+
+// Get returns the ranges of a client.
+func (s *IdSet) Get(client ClientID) []common.Range {
+	return s.clients[client]
+}
+
+// Clients returns the clients with ranges, in descending order.
+func (s *IdSet) Clients() []ClientID {
+	clients := sortedClients(s.clients)
+	return slices.DeleteFunc(clients, func(c ClientID) bool {
+		return len(s.clients[c]) == 0
+	})
+}
+
+// Contains reports whether id is part of a range. The set has to be
+// squashed, as the ranges are binary searched.
+func (s *IdSet) Contains(id ID) bool {
+	ranges := s.clients[id.Client]
+	clock := uint64(id.Clock)
+	i, found := slices.BinarySearchFunc(ranges, clock, func(r common.Range, clock uint64) int {
+		return cmp.Compare(r.Start, clock)
+	})
+	if found {
+		return true
+	}
+	return i > 0 && clock < ranges[i-1].End
+}
+
+// Merge adds all ranges of other to this set. Call Squash afterwards to
+// sort and join the ranges.
+func (s *IdSet) Merge(other *IdSet) {
+	for client, ranges := range other.clients {
+		s.clients[client] = append(s.clients[client], ranges...)
+	}
+}
+
+// Squash sorts the ranges of each client and joins the ranges which overlap
+// or are adjacent to each other.
+func (s *IdSet) Squash() {
+	for client, ranges := range s.clients {
+		if len(ranges) == 0 {
+			delete(s.clients, client)
+			continue
+		}
+		s.clients[client] = squashRanges(ranges)
+	}
+}
+
+func squashRanges(ranges []common.Range) []common.Range {
+	set := common.NewRangeSet(ranges...)
+	return set.GetRanges()
+}
+
+// Clone returns a copy which doesn't share ranges with s.
+func (s *IdSet) Clone() IdSet {
+	clone := NewIdSet()
+	for client, ranges := range s.clients {
+		clone.clients[client] = slices.Clone(ranges)
+	}
+	return clone
+}
+
+// Union returns a squashed set of the clocks in s or other.
+func (s *IdSet) Union(other *IdSet) IdSet {
+	union := s.Clone()
+	union.Merge(other)
+	union.Squash()
+	return union
+}
+
+// Difference returns a squashed set of the clocks in s but not in other.
+func (s *IdSet) Difference(other *IdSet) IdSet {
+	return s.combine(other, (*common.RangeSet).Subtract)
+}
+
+// Intersection returns a squashed set of the clocks in both s and other.
+func (s *IdSet) Intersection(other *IdSet) IdSet {
+	return s.combine(other, (*common.RangeSet).Intersection)
+}
+
+func (s *IdSet) combine(other *IdSet, op func(a, b *common.RangeSet) common.RangeSet) IdSet {
+	result := NewIdSet()
+	for client, ranges := range s.clients {
+		a := common.NewRangeSet(ranges...)
+		b := common.NewRangeSet(other.clients[client]...)
+		combined := op(&a, &b)
+		if !combined.IsEmpty() {
+			result.clients[client] = combined.GetRanges()
+		}
+	}
+	return result
+}
+
+// Encode writes the ranges of each client sorted and joined, the V2
+// encoding writes the clocks as deltas. The set itself is left as it is.
+func (s *IdSet) Encode(encoder Encoder) error {
+	clients := s.Clients()
+	if err := encoder.WriteVarUint(uint(len(clients))); err != nil {
+		return err
+	}
+	for _, client := range clients {
+		ranges := squashRanges(s.clients[client])
+		encoder.ResetDsCurVal()
+		if err := encoder.WriteVarUint64(uint64(client)); err != nil {
+			return err
+		}
+		if err := encoder.WriteVarUint(uint(len(ranges))); err != nil {
+			return err
+		}
+		for _, r := range ranges {
+			if r.End > math.MaxUint32 {
+				return fmt.Errorf("delete set range exceeds max clock: %v", r)
+			}
+			if err := encoder.WriteDsClock(uint32(r.Start)); err != nil {
+				return err
+			}
+			if err := encoder.WriteDsLen(uint32(r.End - r.Start)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *IdSet) EncodeV1() ([]uint8, error) {
+	encoder := NewEncoderV1()
+	if err := s.Encode(&encoder); err != nil {
+		return nil, err
+	}
+	return encoder.ToBytes(), nil
+}
+
+func (s *IdSet) EncodeV2() ([]uint8, error) {
+	encoder := NewEncoderV2()
+	if err := s.Encode(&encoder); err != nil {
+		return nil, err
+	}
+	return encoder.ToBytes()
+}
+
+func (s *IdSet) Decode(decoder Decoder) error {
+	if s.clients == nil {
+		s.clients = make(map[ClientID][]common.Range)
+	}
+	numClients, err := readCount(decoder, "client count")
+	if err != nil {
+		return err
+	}
+	for range numClients {
+		decoder.ResetDsCurVal()
+		client, err := decoder.ReadVarUint()
+		if err != nil {
+			return err
+		}
+		numRanges, err := readCount(decoder, "range count")
+		if err != nil {
+			return err
+		}
+		for range numRanges {
+			clock, err := decoder.ReadDsClock()
+			if err != nil {
+				return err
+			}
+			len, err := decoder.ReadDsLen()
+			if err != nil {
+				return err
+			}
+			if uint64(clock)+uint64(len) > math.MaxUint32 {
+				return fmt.Errorf("delete set range exceeds max clock: %v + %v", clock, len)
+			}
+			s.Insert(ID{Client: ClientID(client), Clock: clock}, len)
+		}
+	}
+	return nil
+}
+
+func (s *IdSet) DecodeV1(buf []uint8) error {
+	decoder := NewDecoderV1FromBytes(buf)
+	return s.Decode(&decoder)
+}
+
+func (s *IdSet) DecodeV2(buf []uint8) error {
+	decoder, err := NewDecoderV2FromBytes(buf)
+	if err != nil {
+		return err
+	}
+	return s.Decode(&decoder)
+}
@@ -0,0 +1,154 @@
+package ygo_test
+
+import (
+	"encoding/hex"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"riguz.com/ygo/pkg/ygo"
+	"riguz.com/ygo/pkg/ygo/common"
+)
+
+// idSet builds a set of client 1 from start, end pairs.
+func idSet(clocks ...uint32) *ygo.IdSet {
+	s := ygo.NewIdSet()
+	for i := 0; i < len(clocks); i += 2 {
+		s.Insert(ygo.ID{Client: 1, Clock: clocks[i]}, clocks[i+1]-clocks[i])
+	}
+	return &s
+}
+
+func ranges(clocks ...uint64) []common.Range {
+	var result []common.Range
+	for i := 0; i < len(clocks); i += 2 {
+		result = append(result, common.NewRange(clocks[i], clocks[i+1]))
+	}
+	return result
+}
+
+func TestIdSet_squash(t *testing.T) {
+	s := idSet(8, 10, 0, 2, 1, 3, 3, 4, 6, 7)
+	s.Squash()
+	assert.Equal(t, ranges(0, 4, 6, 7, 8, 10), s.Get(1))
+
+	for _, clock := range []uint32{0, 3, 6, 8, 9} {
+		assert.True(t, s.Contains(ygo.ID{Client: 1, Clock: clock}), "clock %v", clock)
+	}
+	for _, clock := range []uint32{4, 5, 7, 10} {
+		assert.False(t, s.Contains(ygo.ID{Client: 1, Clock: clock}), "clock %v", clock)
+	}
+	assert.False(t, s.Contains(ygo.ID{Client: 2, Clock: 0}))
+}
+
+func TestIdSet_algebra(t *testing.T) {
+	tests := []struct {
+		name         string
+		a, b         *ygo.IdSet
+		union        []common.Range
+		difference   []common.Range
+		intersection []common.Range
+	}{
+		{"disjoint", idSet(0, 2), idSet(4, 6),
+			ranges(0, 2, 4, 6), ranges(0, 2), nil},
+		{"adjacent", idSet(0, 2), idSet(2, 4),
+			ranges(0, 4), ranges(0, 2), nil},
+		{"overlapping", idSet(0, 5), idSet(3, 8),
+			ranges(0, 8), ranges(0, 3), ranges(3, 5)},
+		{"contained", idSet(0, 10), idSet(2, 4, 6, 7),
+			ranges(0, 10), ranges(0, 2, 4, 6, 7, 10), ranges(2, 4, 6, 7)},
+		{"containing", idSet(2, 4, 6, 7), idSet(0, 10),
+			ranges(0, 10), nil, ranges(2, 4, 6, 7)},
+		{"interleaved", idSet(0, 3, 5, 8, 10, 12), idSet(2, 6, 7, 11),
+			ranges(0, 12), ranges(0, 2, 6, 7, 11, 12), ranges(2, 3, 5, 6, 7, 8, 10, 11)},
+		{"unsorted", idSet(5, 8, 0, 3), idSet(7, 9, 1, 2),
+			ranges(0, 3, 5, 9), ranges(0, 1, 2, 3, 5, 7), ranges(1, 2, 7, 8)},
+		{"empty", idSet(0, 3), idSet(),
+			ranges(0, 3), ranges(0, 3), nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			union := tt.a.Union(tt.b)
+			assert.Equal(t, tt.union, union.Get(1))
+			difference := tt.a.Difference(tt.b)
+			assert.Equal(t, tt.difference, difference.Get(1))
+			intersection := tt.a.Intersection(tt.b)
+			assert.Equal(t, tt.intersection, intersection.Get(1))
+			if tt.intersection == nil {
+				assert.True(t, intersection.IsEmpty())
+			}
+		})
+	}
+}
+
+func TestIdSet_algebraKeepsOperands(t *testing.T) {
+	a := idSet(5, 8, 0, 3)
+	b := ygo.NewIdSet()
+	b.Insert(ygo.ID{Client: 2, Clock: 0}, 1)
+	union := a.Union(&b)
+	assert.Equal(t, []ygo.ClientID{2, 1}, union.Clients())
+	assert.Equal(t, ranges(5, 8, 0, 3), a.Get(1))
+	difference := a.Difference(&b)
+	assert.Equal(t, ranges(0, 3, 5, 8), difference.Get(1))
+	assert.Equal(t, []ygo.ClientID{1}, difference.Clients())
+}
+
+func TestIdSet_encode(t *testing.T) {
+	s := ygo.NewIdSet()
+	s.Insert(ygo.ID{Client: 2, Clock: 0}, 2)
+	s.Insert(ygo.ID{Client: 1, Clock: 3}, 2)
+	s.Insert(ygo.ID{Client: 1, Clock: 0}, 1)
+	s.Squash()
+
+	v1, err := s.EncodeV1()
+	assert.Nil(t, err)
+	assert.Equal(t, "0202010002010200010302", hex.EncodeToString(v1))
+	decoded := ygo.NewIdSet()
+	assert.Nil(t, decoded.DecodeV1(v1))
+	assert.Equal(t, s.Get(1), decoded.Get(1))
+	assert.Equal(t, s.Get(2), decoded.Get(2))
+
+	// the V2 clocks are deltas to the end of the previous range, the
+	// lengths are written minus one
+	v2, err := s.EncodeV2()
+	assert.Nil(t, err)
+	decoded = ygo.NewIdSet()
+	assert.Nil(t, decoded.DecodeV2(v2))
+	assert.Equal(t, s.Get(1), decoded.Get(1))
+	assert.Equal(t, s.Get(2), decoded.Get(2))
+	assert.Equal(t, "0202010001010200000201", hex.EncodeToString(v2[len(v2)-11:]))
+}
+
+func TestIdSet_encodeUnsquashed(t *testing.T) {
+	s := idSet(3, 5, 0, 1, 4, 6)
+	squashed := idSet(3, 5, 0, 1, 4, 6)
+	squashed.Squash()
+	for _, encode := range []func(s *ygo.IdSet) ([]uint8, error){(*ygo.IdSet).EncodeV1, (*ygo.IdSet).EncodeV2} {
+		buf, err := encode(s)
+		assert.Nil(t, err)
+		expected, err := encode(squashed)
+		assert.Nil(t, err)
+		assert.Equal(t, hex.EncodeToString(expected), hex.EncodeToString(buf))
+	}
+	// encoding doesn't squash the set itself
+	assert.Equal(t, ranges(3, 5, 0, 1, 4, 6), s.Get(1))
+
+	s = idSet()
+	s.Insert(ygo.ID{Client: 1, Clock: math.MaxUint32 - 1}, 2)
+	_, err := s.EncodeV1()
+	assert.Error(t, err)
+}
+
+func TestNewDeleteSetFromStore(t *testing.T) {
+	store := ygo.NewBlockStore()
+	items := typeRun(t, &store, "a", "bc", "d", "e")
+	items[1].MarkAsDeleted()
+	items[2].MarkAsDeleted()
+	assert.Nil(t, store.Push(ygo.NewGC(ygo.ID{Client: 1, Clock: 5}, 2)))
+	assert.Nil(t, store.Push(ygo.NewGC(ygo.ID{Client: 2, Clock: 0}, 1)))
+
+	ds := ygo.NewDeleteSetFromStore(&store)
+	assert.Equal(t, []ygo.ClientID{2, 1}, ds.Clients())
+	assert.Equal(t, ranges(1, 4, 5, 7), ds.Get(1))
+	assert.Equal(t, ranges(0, 1), ds.Get(2))
+}
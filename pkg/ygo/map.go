@@ -0,0 +1,51 @@
+package ygo
+
+// Map is a view of a Branch holding keyed values.
+type Map struct {
+	branch *Branch
+}
+
+func NewMap(branch *Branch) *Map {
+	return &Map{branch: branch}
+}
+
+func (m *Map) Branch() *Branch {
+	return m.branch
+}
+
+// Len returns the number of keys.
+func (m *Map) Len() int {
+	count := 0
+	for range m.branch.Entries() {
+		count++
+	}
+	return count
+}
+
+// Get returns the value of key, see Array.ToSlice for the types of values.
+func (m *Map) Get(key string) (any, bool) {
+	item, ok := m.branch.Get(key)
+	if !ok {
+		return nil, false
+	}
+	return lastValue(item), true
+}
+
+// Keys returns the keys in ascending order.
+func (m *Map) Keys() []string {
+	var keys []string
+	for key := range m.branch.Entries() {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// lastValue returns the value of a map entry, which is the last value of
+// its content.
+func lastValue(item *Item) any {
+	values := contentValues(item.Content)
+	if len(values) == 0 {
+		return nil
+	}
+	return values[len(values)-1]
+}
@@ -0,0 +1,58 @@
+package ygo
+
+// Snapshot captures the state of a document at some point in time: the
+// state vector tells which blocks it contains, the delete set which of them
+// were deleted.
+type Snapshot struct {
+	DeleteSet   DeleteSet
+	StateVector StateVector
+}
+
+func NewSnapshot(ds DeleteSet, sv StateVector) Snapshot {
+	return Snapshot{
+		DeleteSet:   ds,
+		StateVector: sv,
+	}
+}
+
+// Encode writes the delete set followed by the state vector, the same layout
+// Y.encodeSnapshot uses.
+func (s *Snapshot) Encode(encoder Encoder) error {
+	if err := s.DeleteSet.Encode(encoder); err != nil {
+		return err
+	}
+	return s.StateVector.Encode(encoder)
+}
+
+// EncodeV1 encodes the snapshot like Y.encodeSnapshot. There is no V2
+// counterpart: Y.encodeSnapshotV2 writes the delete set without the column
+// headers of an update, which EncoderV2 always adds.
+func (s *Snapshot) EncodeV1() ([]uint8, error) {
+	encoder := NewEncoderV1()
+	if err := s.Encode(&encoder); err != nil {
+		return nil, err
+	}
+	return encoder.ToBytes(), nil
+}
+
+func (s *Snapshot) Decode(decoder Decoder) error {
+	if err := s.DeleteSet.Decode(decoder); err != nil {
+		return err
+	}
+	return s.StateVector.Decode(decoder)
+}
+
+// DecodeV1 reads a snapshot encoded by Y.encodeSnapshot.
+func (s *Snapshot) DecodeV1(buf []uint8) error {
+	decoder := NewDecoderV1FromBytes(buf)
+	return s.Decode(&decoder)
+}
+
+// DecodeSnapshot reads a V1 encoded snapshot.
+func DecodeSnapshot(buf []uint8) (Snapshot, error) {
+	snapshot := NewSnapshot(NewDeleteSet(), NewStateVector())
+	if err := snapshot.DecodeV1(buf); err != nil {
+		return Snapshot{}, err
+	}
+	return snapshot, nil
+}
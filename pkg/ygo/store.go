@@ -1,3 +1,367 @@
 package ygo
 
-type DocStore struct{}
+import (
+	"fmt"
+	"slices"
+)
+
+type DocStore struct {
+	Blocks BlockStore
+	// Types holds the root types by name.
+	Types map[string]*Branch
+	// PendingStructs holds the blocks which could not be integrated yet,
+	// because blocks they depend on have not been received.
+	PendingStructs *PendingUpdate
+	// PendingDeleteSet holds the deletions of blocks which have not been
+	// received yet.
+	PendingDeleteSet *DeleteSet
+}
+
+// PendingUpdate is the part of the received updates which is waiting for
+// missing blocks.
+type PendingUpdate struct {
+	// Missing holds for each client the lowest clock which is missing,
+	// which is the state of the client in the store. Providers can use it
+	// as state vector to request the missing updates.
+	Missing StateVector
+	Update  *Update
+}
+
+func NewDocStore() *DocStore {
+	return &DocStore{
+		Blocks: NewBlockStore(),
+		Types:  make(map[string]*Branch),
+	}
+}
+
+// GetOrCreateType returns the root type called name, creating it if it
+// doesn't exist yet. A type created by a received update takes on typeRef,
+// requesting a type with a different type ref fails. TYPE_REFS_UNDEFINED
+// matches any type.
+func (s *DocStore) GetOrCreateType(name string, typeRef uint8) (*Branch, error) {
+	branch, ok := s.Types[name]
+	if !ok {
+		branch = NewBranch(typeRef)
+		branch.Name = &name
+		s.Types[name] = branch
+		return branch, nil
+	}
+	if branch.TypeRef == TYPE_REFS_UNDEFINED {
+		branch.TypeRef = typeRef
+	} else if typeRef != TYPE_REFS_UNDEFINED && typeRef != branch.TypeRef {
+		return nil, fmt.Errorf("type %v is defined with type ref %v, not %v", name, branch.TypeRef, typeRef)
+	}
+	return branch, nil
+}
+
+// parentBranch returns the type item belongs to.
+func (s *DocStore) parentBranch(item *Item) (*Branch, error) {
+	parent := item.Parent
+	switch {
+	case parent.Branch != nil:
+		return parent.Branch, nil
+	case parent.Named != nil:
+		return s.GetOrCreateType(*parent.Named, TYPE_REFS_UNDEFINED)
+	case parent.ID != nil:
+		if typeItem, ok := s.Blocks.Get(*parent.ID).(*Item); ok {
+			if c, ok := typeItem.Content.(*TypeContent); ok && c.Branch != nil {
+				return c.Branch, nil
+			}
+		}
+		return nil, fmt.Errorf("parent %v of item %v is not a type", *parent.ID, item.ID)
+	}
+	return nil, fmt.Errorf("parent of item %v is unknown", item.ID)
+}
+
+// Cleanup runs at the end of a transaction which deleted ds: with gc the
+// deleted content is collected, then the blocks around ds and the blocks
+// added since before are merged.
+func (s *DocStore) Cleanup(ds *DeleteSet, before *StateVector, gc bool) {
+	if gc {
+		s.Blocks.GarbageCollect(ds)
+	}
+	s.Blocks.SquashDeleteSet(ds)
+	s.Blocks.SquashSince(before)
+}
+
+// ApplyUpdate integrates the blocks of update and applies its deletions.
+// Blocks depending on blocks which have not been received yet are parked in
+// PendingStructs, deletions of such blocks in PendingDeleteSet. Both are
+// retried as soon as the blocks they wait for arrive. The observers of the
+// changed types are called before it returns the deletions which were
+// applied.
+func (s *DocStore) ApplyUpdate(update *Update) (DeleteSet, error) {
+	txn := NewTransaction(s)
+	if err := s.applyUpdate(txn, update); err != nil {
+		return DeleteSet{}, err
+	}
+	txn.DeleteSet.Squash()
+	txn.Commit()
+	return txn.DeleteSet, nil
+}
+
+func (s *DocStore) applyUpdate(txn *Transaction, update *Update) error {
+	rest, err := s.integrateBlocks(txn, update)
+	if err != nil {
+		return err
+	}
+	retry := false
+	if s.PendingStructs != nil {
+		// the missing blocks may have been part of this update
+		for client, clock := range s.PendingStructs.Missing.vector {
+			if clock < s.Blocks.GetState(client) {
+				retry = true
+				break
+			}
+		}
+		if rest != nil {
+			for client, clock := range rest.Missing.vector {
+				s.PendingStructs.Missing.SetMin(client, clock)
+			}
+			s.PendingStructs.Update = mergeUpdates([]*Update{s.PendingStructs.Update, rest.Update})
+		}
+	} else {
+		s.PendingStructs = rest
+	}
+
+	restDs, err := s.applyDeleteSet(txn, update.DeleteSet())
+	if err != nil {
+		return err
+	}
+	if s.PendingDeleteSet != nil {
+		pendingDs, err := s.applyDeleteSet(txn, s.PendingDeleteSet)
+		if err != nil {
+			return err
+		}
+		restDs.Merge(&pendingDs)
+		restDs.Squash()
+	}
+	s.PendingDeleteSet = nil
+	if !restDs.IsEmpty() {
+		s.PendingDeleteSet = &restDs
+	}
+
+	if retry {
+		pending := s.PendingStructs.Update
+		s.PendingStructs = nil
+		return s.applyUpdate(txn, pending)
+	}
+	return nil
+}
+
+// blockQueue holds the blocks of a client which are not integrated yet.
+type blockQueue struct {
+	blocks []Block
+	next   int
+}
+
+func (q *blockQueue) hasNext() bool {
+	return q.next < len(q.blocks)
+}
+
+// integrateBlocks integrates all blocks of update whose dependencies are
+// known, following Yjs' integrateStructs: when a block depends on a block of
+// another client, that client's blocks are integrated first. The blocks
+// which can't be integrated are returned together with the clocks they
+// wait for, or nil if everything was integrated.
+func (s *DocStore) integrateBlocks(txn *Transaction, update *Update) (*PendingUpdate, error) {
+	queues := make(map[ClientID]*blockQueue)
+	// clients are processed from the highest to the lowest
+	clients := update.Clients()
+	slices.Reverse(clients)
+	for _, client := range clients {
+		queues[client] = &blockQueue{blocks: update.Blocks(client)}
+	}
+	nextTarget := func() *blockQueue {
+		for len(clients) > 0 {
+			queue := queues[clients[len(clients)-1]]
+			if queue != nil && queue.hasNext() {
+				return queue
+			}
+			clients = clients[:len(clients)-1]
+		}
+		return nil
+	}
+
+	rest := NewUpdate()
+	missing := NewStateVector()
+	var stack []Block
+	// moveStackToRest gives up on the blocks on the stack, and on all blocks
+	// of their clients which follow them
+	moveStackToRest := func() {
+		// the first block of each client on the stack, the blocks of a
+		// client are stacked in any order when dependencies are cyclic
+		first := make(map[ClientID]int)
+		for _, block := range stack {
+			// the blocks waiting on the stack have to be retried as soon as
+			// their dependencies progress
+			if dependency, ok := missingDependency(block, &s.Blocks); ok {
+				missing.SetMin(dependency.Client, s.Blocks.GetState(dependency.Client))
+			}
+			client := block.Id().Client
+			queue, ok := queues[client]
+			if !ok {
+				continue
+			}
+			index := slices.Index(queue.blocks[:queue.next], block)
+			if prev, ok := first[client]; index >= 0 && (!ok || index < prev) {
+				first[client] = index
+			}
+		}
+		for _, block := range stack {
+			client := block.Id().Client
+			queue, ok := queues[client]
+			if !ok {
+				// moved together with an earlier block of its client
+				continue
+			}
+			index, ok := first[client]
+			if !ok {
+				rest.Push(block)
+				index = queue.next
+			}
+			for _, b := range queue.blocks[index:] {
+				rest.Push(b)
+			}
+			// the queue may still be the current target
+			queue.blocks = nil
+			queue.next = 0
+			delete(queues, client)
+			clients = slices.DeleteFunc(clients, func(c ClientID) bool { return c == client })
+		}
+		stack = stack[:0]
+	}
+
+	target := nextTarget()
+	if target == nil {
+		return nil, nil
+	}
+	head := target.blocks[target.next]
+	target.next++
+	for {
+		if _, isSkip := head.(*Skip); !isSkip {
+			id := head.Id()
+			state := s.Blocks.GetState(id.Client)
+			if id.Clock > state {
+				// a previous update of the same client is missing
+				stack = append(stack, head)
+				missing.SetMin(id.Client, state)
+				moveStackToRest()
+			} else if dependency, ok := missingDependency(head, &s.Blocks); ok {
+				stack = append(stack, head)
+				queue := queues[dependency.Client]
+				if queue == nil || !queue.hasNext() {
+					// this update depends on an update which wasn't received
+					missing.SetMin(dependency.Client, s.Blocks.GetState(dependency.Client))
+					moveStackToRest()
+				} else {
+					head = queue.blocks[queue.next]
+					queue.next++
+					continue
+				}
+			} else if offset := state - id.Clock; offset < head.Len() {
+				if err := s.integrateBlock(txn, head, offset); err != nil {
+					return nil, err
+				}
+			}
+		}
+		if len(stack) > 0 {
+			head = stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+		} else if target != nil && target.hasNext() {
+			head = target.blocks[target.next]
+			target.next++
+		} else if target = nextTarget(); target != nil {
+			head = target.blocks[target.next]
+			target.next++
+		} else {
+			break
+		}
+	}
+	if rest.IsEmpty() {
+		return nil, nil
+	}
+	return &PendingUpdate{Missing: missing, Update: rest}, nil
+}
+
+// integrateBlock adds block to the store, leaving out its first offset
+// elements which are already known. An item whose parent was garbage
+// collected is added as a GC block.
+func (s *DocStore) integrateBlock(txn *Transaction, block Block, offset uint32) error {
+	if item, ok := block.(*Item); ok {
+		if err := item.Repair(&s.Blocks); err != nil {
+			return err
+		}
+		if item.Parent.Unknown == nil {
+			if err := item.Integrate(txn, offset); err != nil {
+				return err
+			}
+			return s.Blocks.Push(item)
+		}
+		block = NewGC(item.ID, item.Length)
+	}
+	if offset > 0 {
+		block = block.Splice(offset)
+	}
+	return s.Blocks.Push(block)
+}
+
+// missingDependency returns the block a received block waits for, see
+// Item.GetMissing.
+func missingDependency(block Block, store *BlockStore) (ID, bool) {
+	if item, ok := block.(*Item); ok {
+		return item.GetMissing(store)
+	}
+	return ID{}, false
+}
+
+// applyDeleteSet deletes the items in ds within txn. It returns the ranges
+// of blocks which are not in the store yet.
+func (s *DocStore) applyDeleteSet(txn *Transaction, ds *DeleteSet) (DeleteSet, error) {
+	rest := NewDeleteSet()
+	for _, client := range ds.Clients() {
+		state := uint64(s.Blocks.GetState(client))
+		for _, r := range ds.Get(client) {
+			if r.Start >= state {
+				rest.Insert(ID{Client: client, Clock: uint32(r.Start)}, uint32(r.End-r.Start))
+				continue
+			}
+			if state < r.End {
+				rest.Insert(ID{Client: client, Clock: uint32(state)}, uint32(r.End-state))
+			}
+			end := min(r.End, state)
+			if err := s.deleteRange(txn, ID{Client: client, Clock: uint32(r.Start)}, uint32(end-r.Start)); err != nil {
+				return DeleteSet{}, err
+			}
+		}
+	}
+	return rest, nil
+}
+
+// deleteRange deletes the items of len elements starting at id, splitting
+// the items at both ends of the range if needed.
+func (s *DocStore) deleteRange(txn *Transaction, id ID, len uint32) error {
+	last := ID{Client: id.Client, Clock: id.Clock + len - 1}
+	if _, err := s.Blocks.GetItemCleanStart(id); err != nil {
+		return err
+	}
+	if _, err := s.Blocks.GetItemCleanEnd(last); err != nil {
+		return err
+	}
+	list := s.Blocks.GetClient(id.Client)
+	index, _ := list.FindIndex(id.Clock)
+	for ; index < list.Len(); index++ {
+		item, ok := list.Get(index).(*Item)
+		if !ok {
+			if list.Get(index).Id().Clock > last.Clock {
+				break
+			}
+			continue
+		}
+		if item.ID.Clock > last.Clock {
+			break
+		}
+		item.Delete(txn)
+	}
+	return nil
+}
@@ -0,0 +1,237 @@
+package ygo_test
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"riguz.com/ygo/pkg/ygo"
+	"riguz.com/ygo/pkg/ygo/common"
+)
+
+func newDoc(t *testing.T, opts ...func(*ygo.DocOptions)) *ygo.Doc {
+	options, err := ygo.NewDocOptions(opts...)
+	assert.Nil(t, err)
+	return ygo.NewDocWithOptions(*options)
+}
+
+func TestDoc_applyUpdate(t *testing.T) {
+	doc := newDoc(t)
+	buf, _ := hex.DecodeString(concurrentUpdateV1)
+	assert.Nil(t, doc.ApplyUpdate(buf))
+	assert.Nil(t, doc.Pending())
+	sv := doc.StateVector()
+	assert.True(t, sv.Equal(stateVector(map[ygo.ClientID]uint32{1: 2, 2: 1})))
+
+	// applying it again changes nothing
+	assert.Nil(t, doc.ApplyUpdate(buf))
+	sv = doc.StateVector()
+	assert.True(t, sv.Equal(stateVector(map[ygo.ClientID]uint32{1: 2, 2: 1})))
+}
+
+func TestDoc_applyUpdateMergesBlocks(t *testing.T) {
+	doc := newDoc(t)
+	var origin *ygo.ID
+	for i, str := range []string{"a", "b", "c", "d", "e"} {
+		assert.Nil(t, doc.ApplyUpdate(encodeV1(t, []ygo.Block{textItem(1, uint32(i), origin, str)}, nil)))
+		origin = &ygo.ID{Client: 1, Clock: uint32(i)}
+	}
+	text, err := doc.GetText("text")
+	assert.Nil(t, err)
+	assert.Equal(t, "abcde", text.String())
+	start := text.Branch().Start
+	assert.Equal(t, uint32(5), start.Length)
+	assert.Nil(t, start.Right)
+}
+
+func TestDocStore_cleanupMergesMapEntries(t *testing.T) {
+	root := "map"
+	key := "key"
+	first := ygo.ID{Client: 1, Clock: 0}
+	store := ygo.NewDocStore()
+	before := store.Blocks.GetStateVector()
+	deleted := applyV1(t, store, encodeV1(t, []ygo.Block{
+		ygo.NewItem(first, nil, nil, nil, nil,
+			ygo.TypePtr{Named: &root}, &key, &ygo.AnyContent{Values: anyValues(1)}),
+		ygo.NewItem(ygo.ID{Client: 1, Clock: 1}, nil, &first, nil, nil,
+			ygo.TypePtr{Unknown: &ygo.Unknown{}}, &key, &ygo.AnyContent{Values: anyValues(2)}),
+	}, nil))
+	store.Cleanup(&deleted, &before, false)
+	assert.Equal(t, 2, store.Blocks.GetClient(1).Len())
+
+	// deleting the key allows merging the entries, the map follows
+	before = store.Blocks.GetStateVector()
+	deleted = applyV1(t, store, encodeV1(t, nil, []ygo.BlockRange{{ID: ygo.ID{Client: 1, Clock: 1}, Len: 1}}))
+	store.Cleanup(&deleted, &before, false)
+	assert.Equal(t, 1, store.Blocks.GetClient(1).Len())
+	branch := rootType(t, store, "map")
+	assert.Same(t, store.Blocks.Get(first), branch.Map["key"])
+	_, ok := branch.Get("key")
+	assert.False(t, ok)
+}
+
+func TestDoc_applyMergedMapUpdate(t *testing.T) {
+	root := "map"
+	key := "a"
+	first := ygo.ID{Client: 1, Clock: 0}
+	merged, err := ygo.MergeUpdates([][]uint8{
+		encodeV1(t, []ygo.Block{ygo.NewItem(first, nil, nil, nil, nil,
+			ygo.TypePtr{Named: &root}, &key, &ygo.AnyContent{Values: anyValues(int64(1))})}, nil),
+		encodeV1(t, []ygo.Block{ygo.NewItem(ygo.ID{Client: 1, Clock: 1}, nil, &first, nil, nil,
+			ygo.TypePtr{Unknown: &ygo.Unknown{}}, &key, &ygo.AnyContent{Values: anyValues(int64(2))})},
+			[]ygo.BlockRange{{ID: first, Len: 1}}),
+	})
+	assert.Nil(t, err)
+	update := ygo.NewUpdate()
+	assert.Nil(t, update.DecodeV1(merged))
+	assert.Len(t, update.Blocks(1), 1)
+
+	doc := newDoc(t)
+	assert.Nil(t, doc.ApplyUpdate(merged))
+	m, err := doc.GetMap("map")
+	assert.Nil(t, err)
+	value, ok := m.Get("a")
+	assert.True(t, ok)
+	assert.Equal(t, mustAny(int64(2)), value)
+}
+
+func TestDocStore_pendingOtherClient(t *testing.T) {
+	store := ygo.NewDocStore()
+	origin := ygo.ID{Client: 1, Clock: 1}
+	update := ygo.NewUpdate()
+	update.Push(textItem(2, 0, &origin, "c"))
+	update.Push(textItem(2, 1, &ygo.ID{Client: 2, Clock: 0}, "d"))
+	_, err := store.ApplyUpdate(update)
+	assert.Nil(t, err)
+
+	assert.NotNil(t, store.PendingStructs)
+	assert.True(t, store.PendingStructs.Missing.Equal(stateVector(map[ygo.ClientID]uint32{1: 0})))
+	assert.Equal(t, uint32(0), store.Blocks.GetState(2))
+
+	// the missing item arrives, without the one client 2 depends on
+	update = ygo.NewUpdate()
+	update.Push(textItem(1, 0, nil, "a"))
+	_, err = store.ApplyUpdate(update)
+	assert.Nil(t, err)
+	assert.NotNil(t, store.PendingStructs)
+	assert.True(t, store.PendingStructs.Missing.Equal(stateVector(map[ygo.ClientID]uint32{1: 1})))
+
+	update = ygo.NewUpdate()
+	update.Push(textItem(1, 1, &ygo.ID{Client: 1, Clock: 0}, "b"))
+	_, err = store.ApplyUpdate(update)
+	assert.Nil(t, err)
+	assert.Nil(t, store.PendingStructs)
+	sv := store.Blocks.GetStateVector()
+	assert.True(t, sv.Equal(stateVector(map[ygo.ClientID]uint32{1: 2, 2: 2})))
+}
+
+func TestDocStore_pendingSameClient(t *testing.T) {
+	store := ygo.NewDocStore()
+	update := ygo.NewUpdate()
+	update.Push(textItem(1, 2, &ygo.ID{Client: 1, Clock: 1}, "c"))
+	_, err := store.ApplyUpdate(update)
+	assert.Nil(t, err)
+	// clocks 0 and 1 are missing
+	assert.True(t, store.PendingStructs.Missing.Equal(stateVector(map[ygo.ClientID]uint32{1: 0})))
+
+	// overlaps with nothing, but leaves the gap at clock 2 open
+	update = ygo.NewUpdate()
+	update.Push(textItem(1, 0, nil, "a"))
+	_, err = store.ApplyUpdate(update)
+	assert.Nil(t, err)
+	assert.NotNil(t, store.PendingStructs)
+	assert.Equal(t, uint32(1), store.Blocks.GetState(1))
+
+	// overlaps with the known "a"
+	update = ygo.NewUpdate()
+	update.Push(textItem(1, 0, nil, "ab"))
+	_, err = store.ApplyUpdate(update)
+	assert.Nil(t, err)
+	assert.Nil(t, store.PendingStructs)
+	assert.Equal(t, uint32(3), store.Blocks.GetState(1))
+	list := store.Blocks.GetClient(1)
+	assert.Equal(t, 3, list.Len())
+	b, err := list.Get(1).AsItem()
+	assert.Nil(t, err)
+	assert.Equal(t, "b", b.Content.(*ygo.StringContent).Str)
+}
+
+func TestDocStore_pendingCycle(t *testing.T) {
+	// 3:0 waits for 2:0, which waits for 3:1, which waits for 3:0
+	store := ygo.NewDocStore()
+	update := ygo.NewUpdate()
+	update.Push(textItem(3, 0, &ygo.ID{Client: 2, Clock: 0}, "a"))
+	update.Push(textItem(3, 1, &ygo.ID{Client: 3, Clock: 0}, "b"))
+	update.Push(textItem(3, 2, &ygo.ID{Client: 3, Clock: 1}, "c"))
+	update.Push(textItem(2, 0, &ygo.ID{Client: 3, Clock: 1}, "x"))
+	_, err := store.ApplyUpdate(update)
+	assert.Nil(t, err)
+
+	// nothing can be integrated, and no block is lost
+	assert.Equal(t, uint32(0), store.Blocks.GetState(3))
+	pending := store.PendingStructs.Update
+	var ids []ygo.ID
+	for _, client := range pending.Clients() {
+		for _, block := range pending.Blocks(client) {
+			ids = append(ids, block.Id())
+		}
+	}
+	assert.Equal(t, []ygo.ID{{Client: 3, Clock: 0}, {Client: 3, Clock: 1}, {Client: 3, Clock: 2}, {Client: 2, Clock: 0}}, ids)
+}
+
+func TestDocStore_pendingDeleteSet(t *testing.T) {
+	store := ygo.NewDocStore()
+	update := ygo.NewUpdate()
+	update.DeleteSet().Insert(ygo.ID{Client: 1, Clock: 1}, 2)
+	deleted, err := store.ApplyUpdate(update)
+	assert.Nil(t, err)
+	assert.True(t, deleted.IsEmpty())
+	assert.NotNil(t, store.PendingDeleteSet)
+
+	// only the first deleted element arrives
+	update = ygo.NewUpdate()
+	update.Push(textItem(1, 0, nil, "ab"))
+	deleted, err = store.ApplyUpdate(update)
+	assert.Nil(t, err)
+	assert.Equal(t, []common.Range{common.NewRange(1, 2)}, deleted.Get(1))
+	assert.Equal(t, []common.Range{common.NewRange(2, 3)}, store.PendingDeleteSet.Get(1))
+
+	list := store.Blocks.GetClient(1)
+	assert.Equal(t, 2, list.Len())
+	assert.False(t, list.Get(0).IsDeleted())
+	assert.True(t, list.Get(1).IsDeleted())
+
+	update = ygo.NewUpdate()
+	update.Push(textItem(1, 2, &ygo.ID{Client: 1, Clock: 1}, "cd"))
+	deleted, err = store.ApplyUpdate(update)
+	assert.Nil(t, err)
+	assert.Equal(t, []common.Range{common.NewRange(2, 3)}, deleted.Get(1))
+	assert.Nil(t, store.PendingDeleteSet)
+	assert.Equal(t, 4, list.Len())
+	assert.True(t, list.Get(2).IsDeleted())
+	assert.False(t, list.Get(3).IsDeleted())
+}
+
+func TestDocStore_pendingDependencyWithGap(t *testing.T) {
+	store := ygo.NewDocStore()
+	apply := func(item *ygo.Item) {
+		update := ygo.NewUpdate()
+		update.Push(item)
+		_, err := store.ApplyUpdate(update)
+		assert.Nil(t, err)
+	}
+	// "s" of client 3 waits for client 2, as well as for its own clocks 0
+	// and 1
+	s := textItem(3, 2, &ygo.ID{Client: 2, Clock: 1}, "s")
+	s.RightOrigin = &ygo.ID{Client: 2, Clock: 2}
+	apply(s)
+	apply(textItem(2, 10, &ygo.ID{Client: 2, Clock: 9}, "x"))
+	// "s" is retried, it waits for client 2 whose blocks have a gap
+	apply(textItem(3, 0, nil, "zz"))
+	assert.True(t, store.PendingStructs.Missing.Equal(stateVector(map[ygo.ClientID]uint32{2: 0})))
+
+	// the blocks "s" depends on arrive, only the gap before "x" remains
+	apply(textItem(2, 0, nil, "fff"))
+	assert.Equal(t, uint32(3), store.Blocks.GetState(3))
+	assert.True(t, store.PendingStructs.Missing.Equal(stateVector(map[ygo.ClientID]uint32{2: 3})))
+}
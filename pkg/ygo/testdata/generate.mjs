@@ -0,0 +1,87 @@
+// Regenerates the conformance fixtures with Yjs, see README.md.
+import * as fs from 'node:fs'
+import * as Y from 'yjs'
+import * as encoding from 'lib0/encoding'
+
+const write = (path, buf) => fs.writeFileSync(new URL(path, import.meta.url), buf)
+
+const newDoc = () => {
+  const doc = new Y.Doc({ gc: false })
+  doc.clientID = 1
+  return doc
+}
+
+const docs = {
+  text: doc => doc.getText('text').insert(0, 'abc'),
+  text_delete: doc => {
+    doc.getText('text').insert(0, 'abc')
+    doc.getText('text').delete(1, 1)
+  },
+  array: doc => doc.getArray('array').insert(0, [1, 'two', true]),
+  map: doc => {
+    doc.getMap('map').set('key', 'value')
+    doc.getMap('map').set('key', 2)
+  },
+  xml: doc => {
+    const p = new Y.XmlElement('p')
+    doc.getXmlFragment('xml').insert(0, [p])
+    p.insert(0, [new Y.XmlText('hi')])
+  }
+}
+
+for (const [name, edit] of Object.entries(docs)) {
+  const doc = newDoc()
+  edit(doc)
+  write(`updates/${name}.v1.bin`, Y.encodeStateAsUpdate(doc))
+  write(`updates/${name}.v2.bin`, Y.encodeStateAsUpdateV2(doc))
+  write(`state_vectors/${name}.bin`, Y.encodeStateVector(doc))
+  if (name === 'text_delete') {
+    write(`snapshots/${name}.v1.bin`, Y.encodeSnapshot(Y.snapshot(doc)))
+  }
+}
+
+// client 2 inserts "c" after "ab" of client 1, then client 1 deletes "a"
+{
+  const doc1 = newDoc()
+  doc1.getText('text').insert(0, 'ab')
+  const doc2 = new Y.Doc({ gc: false })
+  doc2.clientID = 2
+  Y.applyUpdate(doc2, Y.encodeStateAsUpdate(doc1))
+  doc2.getText('text').insert(2, 'c')
+  Y.applyUpdate(doc1, Y.encodeStateAsUpdate(doc2))
+  doc1.getText('text').delete(0, 1)
+  write('updates/concurrent.v1.bin', Y.encodeStateAsUpdate(doc1))
+  write('updates/concurrent.v2.bin', Y.encodeStateAsUpdateV2(doc1))
+  write('state_vectors/concurrent.bin', Y.encodeStateVector(doc1))
+}
+
+write('snapshots/empty.v1.bin', Y.encodeSnapshot(Y.emptySnapshot))
+
+const anys = {
+  undefined: undefined,
+  null: null,
+  true: true,
+  false: false,
+  integer: 42,
+  negative_integer: -1,
+  float32: 1.5,
+  float32_large_integer: 2147483648,
+  float64: 0.1,
+  bigint: 9223372036854775807n,
+  string: 'hello',
+  string_emoji: '😀',
+  array: [1, 'a', null],
+  object: { a: 1, b: [true] },
+  buffer: new Uint8Array([1, 2, 3]),
+  empty_object: {},
+  empty_array: []
+}
+for (const [name, value] of Object.entries(anys)) {
+  const encoder = encoding.createEncoder()
+  encoding.writeAny(encoder, value)
+  write(`../../../internal/lib0/testdata/any/${name}.bin`, encoding.toUint8Array(encoder))
+  // JSON.stringify can't represent undefined and bigints
+  if (value !== undefined && typeof value !== 'bigint') {
+    write(`../../../internal/lib0/testdata/any/${name}.json`, JSON.stringify(value) + '\n')
+  }
+}
@@ -0,0 +1,33 @@
+package ygo
+
+import "strings"
+
+// Text is a view of a Branch holding text.
+type Text struct {
+	branch *Branch
+}
+
+func NewText(branch *Branch) *Text {
+	return &Text{branch: branch}
+}
+
+func (t *Text) Branch() *Branch {
+	return t.branch
+}
+
+// Len returns the length of the text in UTF-16 code units, the unit Yjs
+// uses for indices into a text.
+func (t *Text) Len() uint32 {
+	return t.branch.BlockLen
+}
+
+// String returns the text without embeds and formatting attributes.
+func (t *Text) String() string {
+	var sb strings.Builder
+	for item := range t.branch.Items() {
+		if c, ok := item.Content.(*StringContent); ok {
+			sb.WriteString(c.Str)
+		}
+	}
+	return sb.String()
+}
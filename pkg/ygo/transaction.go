@@ -0,0 +1,81 @@
+package ygo
+
+// Transaction collects the changes applied to a document in one batch, such
+// as a received update.
+type Transaction struct {
+	store *DocStore
+	// DeleteSet holds the items deleted by the transaction.
+	DeleteSet DeleteSet
+	// BeforeState is the state of the store when the transaction started.
+	BeforeState StateVector
+	// changed holds the events of the changed types in the order they were
+	// first changed.
+	changed []*Event
+	events  map[*Branch]*Event
+}
+
+func NewTransaction(store *DocStore) *Transaction {
+	return &Transaction{
+		store:       store,
+		DeleteSet:   NewDeleteSet(),
+		BeforeState: store.Blocks.GetStateVector(),
+		events:      make(map[*Branch]*Event),
+	}
+}
+
+// addChanged records a change of the entry parentSub of branch, or of its
+// sequence if parentSub is nil. Types created by the transaction itself
+// or deleted have no observers to tell.
+func (t *Transaction) addChanged(branch *Branch, parentSub *string) {
+	if item := branch.Item; item != nil && (item.ID.Clock >= t.BeforeState.Get(item.ID.Client) || item.IsDeleted()) {
+		return
+	}
+	event, ok := t.events[branch]
+	if !ok {
+		event = &Event{Target: branch, Keys: make(map[string]struct{})}
+		t.events[branch] = event
+		t.changed = append(t.changed, event)
+	}
+	if parentSub != nil {
+		event.Keys[*parentSub] = struct{}{}
+	} else {
+		event.SequenceChanged = true
+	}
+}
+
+// Commit calls the observers of the types changed by the transaction, then
+// the deep observers of those types and their parents.
+func (t *Transaction) Commit() {
+	var deep []*Branch
+	deepEvents := make(map[*Branch][]*Event)
+	for _, event := range t.changed {
+		if event.Target.IsDeleted() {
+			continue
+		}
+		for _, f := range event.Target.observers.all() {
+			f(event)
+		}
+		for b := event.Target; b != nil; b = b.Parent() {
+			if _, ok := deepEvents[b]; !ok {
+				deep = append(deep, b)
+			}
+			path, _ := b.PathTo(event.Target)
+			deepEvents[b] = append(deepEvents[b], &Event{
+				Target:          event.Target,
+				Keys:            event.Keys,
+				SequenceChanged: event.SequenceChanged,
+				Path:            path,
+			})
+		}
+	}
+	for _, b := range deep {
+		if b.IsDeleted() {
+			continue
+		}
+		for _, f := range b.deepObservers.all() {
+			f(deepEvents[b])
+		}
+	}
+	t.changed = nil
+	clear(t.events)
+}
@@ -1,8 +1,256 @@
 package ygo
 
+import (
+	"encoding/json"
+	"iter"
+	"slices"
+
+	"riguz.com/ygo/internal/lib0"
+)
+
+const (
+	TYPE_REFS_ARRAY        uint8 = 0
+	TYPE_REFS_MAP          uint8 = 1
+	TYPE_REFS_TEXT         uint8 = 2
+	TYPE_REFS_XML_ELEMENT  uint8 = 3
+	TYPE_REFS_XML_FRAGMENT uint8 = 4
+	TYPE_REFS_XML_HOOK     uint8 = 5
+	TYPE_REFS_XML_TEXT     uint8 = 6
+	TYPE_REFS_DOC          uint8 = 9
+	TYPE_REFS_UNDEFINED    uint8 = 15
+)
+
+// Branch holds the content of a shared type: a sequence of items and a map
+// of keyed entries. Text, Array, Map and XmlElement are views of it.
 type Branch struct {
+	// Start is the first item of the sequence, deleted items included.
+	Start *Item
+	// Map holds the current entry of every key. The entries it replaced are
+	// linked to its left.
+	Map map[string]*Item
+	// BlockLen is the number of elements in the visible sequence, strings
+	// are measured in UTF-16 code units like in Yjs.
+	BlockLen uint32
+	// ContentLen is the length of the visible sequence with strings
+	// measured in UTF-8 bytes.
+	ContentLen uint32
+	// Item is the item containing the type, nil for root types.
+	Item *Item
+	// Name is the name of a root type.
+	Name *string
+	// TypeRef is one of the TYPE_REFS constants. Root types created by a
+	// received update are TYPE_REFS_UNDEFINED until they are requested as
+	// a concrete type.
+	TypeRef uint8
+
+	observers     observers[func(*Event)]
+	deepObservers observers[func([]*Event)]
+}
+
+func NewBranch(typeRef uint8) *Branch {
+	return &Branch{
+		Map:     make(map[string]*Item),
+		TypeRef: typeRef,
+	}
+}
+
+// Parent returns the type containing b, or nil for a root type.
+func (b *Branch) Parent() *Branch {
+	if b.Item == nil {
+		return nil
+	}
+	return b.Item.Parent.Branch
+}
+
+// IsDeleted reports whether the item containing b was deleted.
+func (b *Branch) IsDeleted() bool {
+	return b.Item != nil && b.Item.IsDeleted()
+}
+
+// Items iterates over the visible items of the sequence, which are neither
+// deleted nor formatting attributes.
+func (b *Branch) Items() iter.Seq[*Item] {
+	return func(yield func(*Item) bool) {
+		for item := b.Start; item != nil; item = item.Right {
+			if !item.IsDeleted() && item.IsCountable() && !yield(item) {
+				return
+			}
+		}
+	}
+}
+
+// Entries iterates over the keys and current entries of the map which are
+// not deleted, ordered by key.
+func (b *Branch) Entries() iter.Seq2[string, *Item] {
+	return func(yield func(string, *Item) bool) {
+		keys := make([]string, 0, len(b.Map))
+		for key := range b.Map {
+			keys = append(keys, key)
+		}
+		slices.Sort(keys)
+		for _, key := range keys {
+			if item := b.Map[key]; !item.IsDeleted() && !yield(key, item) {
+				return
+			}
+		}
+	}
+}
+
+// Get returns the current entry of key, unless it's deleted.
+func (b *Branch) Get(key string) (*Item, bool) {
+	item, ok := b.Map[key]
+	if !ok || item.IsDeleted() {
+		return nil, false
+	}
+	return item, true
+}
+
+// firstEntry returns the oldest entry of key which is still linked, the
+// start of the entries the current one replaced.
+func (b *Branch) firstEntry(key string) *Item {
+	item := b.Map[key]
+	for item != nil && item.Left != nil {
+		item = item.Left
+	}
+	return item
+}
+
+// PathSegment leads from a type to a nested type, either by the Key of a
+// map entry or by the Index in the sequence.
+type PathSegment struct {
+	Key   *string
+	Index uint32
+}
+
+// PathTo returns the path from b to descendant, which is empty if both are
+// the same type. It reports false if descendant isn't nested in b.
+func (b *Branch) PathTo(descendant *Branch) ([]PathSegment, bool) {
+	var path []PathSegment
+	for child := descendant; child != b; child = child.Parent() {
+		if child == nil || child.Item == nil {
+			return nil, false
+		}
+		item := child.Item
+		if item.ParentSub != nil {
+			path = append(path, PathSegment{Key: item.ParentSub})
+			continue
+		}
+		var index uint32
+		for left := item.Left; left != nil; left = left.Left {
+			if !left.IsDeleted() && left.IsCountable() {
+				index += left.Length
+			}
+		}
+		path = append(path, PathSegment{Index: index})
+	}
+	slices.Reverse(path)
+	return path, true
+}
+
+// Event describes the changes a transaction made to a type.
+type Event struct {
+	Target *Branch
+	// Keys holds the map keys whose entries changed.
+	Keys map[string]struct{}
+	// SequenceChanged reports whether items of the sequence were inserted
+	// or deleted.
+	SequenceChanged bool
+	// Path leads from the type observed by ObserveDeep to Target, it's
+	// empty for events passed to Observe.
+	Path []PathSegment
+}
+
+// Observe registers f to be called with the changes of every transaction
+// which changed b. It returns a function which removes f again.
+func (b *Branch) Observe(f func(*Event)) func() {
+	return b.observers.add(f)
+}
+
+// ObserveDeep registers f to be called with the changes of every
+// transaction which changed b or a type nested in it. It returns a
+// function which removes f again.
+func (b *Branch) ObserveDeep(f func([]*Event)) func() {
+	return b.deepObservers.add(f)
+}
+
+// observers is a list of callbacks which can be removed again.
+type observers[F any] struct {
+	list []*F
+}
+
+func (o *observers[F]) add(f F) func() {
+	entry := &f
+	o.list = append(o.list, entry)
+	return func() {
+		o.list = slices.DeleteFunc(o.list, func(e *F) bool { return e == entry })
+	}
+}
+
+// all returns the callbacks, later changes of the list don't affect it.
+func (o *observers[F]) all() []F {
+	result := make([]F, len(o.list))
+	for i, f := range o.list {
+		result[i] = *f
+	}
+	return result
+}
+
+// typeView returns the view matching the type ref of b, or b itself if
+// there is none.
+func typeView(b *Branch) any {
+	switch b.TypeRef {
+	case TYPE_REFS_TEXT, TYPE_REFS_XML_TEXT:
+		return &Text{branch: b}
+	case TYPE_REFS_ARRAY:
+		return &Array{branch: b}
+	case TYPE_REFS_MAP:
+		return &Map{branch: b}
+	case TYPE_REFS_XML_ELEMENT:
+		return &XmlElement{branch: b}
+	default:
+		return b
+	}
+}
+
+// contentValues returns the values of the content of a visible item:
+// lib0.Any for JSON like values, []uint8 for binary content and a view for
+// a nested type.
+func contentValues(content ItemContent) []any {
+	switch c := content.(type) {
+	case *AnyContent:
+		values := make([]any, len(c.Values))
+		for i, v := range c.Values {
+			values[i] = v
+		}
+		return values
+	case *JsonContent:
+		values := make([]any, len(c.Values))
+		for i, str := range c.Values {
+			var v lib0.Any
+			if err := json.Unmarshal([]byte(str), &v); err != nil {
+				// undefined is written as is
+				v = lib0.Any{Kind: lib0.ANY_UNDEFINED}
+			}
+			values[i] = v
+		}
+		return values
+	case *BinaryContent:
+		return []any{c.Data}
+	case *EmbedContent:
+		return []any{c.Embed}
+	case *StringContent:
+		return []any{c.Str}
+	case *TypeContent:
+		return []any{typeView(c.Branch)}
+	case *DocContent:
+		return []any{c}
+	}
+	return nil
 }
 
+// TypePtr refers to the parent of an item. Received items refer to it by
+// root name or by the ID of the item holding the type, or not at all if it
+// can be copied from their neighbours. Integrating the item sets Branch.
 type TypePtr struct {
 	Unknown *Unknown
 	Branch  *Branch
@@ -0,0 +1,164 @@
+package ygo_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"riguz.com/ygo/internal/lib0"
+	"riguz.com/ygo/pkg/ygo"
+)
+
+func id(client ygo.ClientID, clock uint32) *ygo.ID {
+	return &ygo.ID{Client: client, Clock: clock}
+}
+
+// nestedDocUpdate holds an array with the values 1, 2 and a <p class="x">
+// element containing the text "hi", a map with k: true and a text "ab".
+func nestedDocUpdate(t *testing.T) []uint8 {
+	array, mapName, text := "array", "map", "text"
+	name, class, key := "p", "class", "k"
+	unknown := ygo.TypePtr{Unknown: &ygo.Unknown{}}
+	return encodeV1(t, []ygo.Block{
+		ygo.NewItem(*id(1, 0), nil, nil, nil, nil, ygo.TypePtr{Named: &array}, nil,
+			&ygo.AnyContent{Values: anyValues(1, 2)}),
+		ygo.NewItem(*id(1, 2), nil, id(1, 1), nil, nil, unknown, nil,
+			&ygo.TypeContent{TypeRef: ygo.TYPE_REFS_XML_ELEMENT, Name: &name}),
+		ygo.NewItem(*id(1, 3), nil, nil, nil, nil, ygo.TypePtr{ID: id(1, 2)}, &class,
+			&ygo.AnyContent{Values: anyValues("x")}),
+		ygo.NewItem(*id(1, 4), nil, nil, nil, nil, ygo.TypePtr{ID: id(1, 2)}, nil,
+			&ygo.TypeContent{TypeRef: ygo.TYPE_REFS_XML_TEXT}),
+		ygo.NewItem(*id(1, 5), nil, nil, nil, nil, ygo.TypePtr{ID: id(1, 4)}, nil,
+			&ygo.StringContent{Str: "hi"}),
+		ygo.NewItem(*id(1, 7), nil, nil, nil, nil, ygo.TypePtr{Named: &mapName}, &key,
+			&ygo.AnyContent{Values: anyValues(true)}),
+		ygo.NewItem(*id(1, 8), nil, nil, nil, nil, ygo.TypePtr{Named: &text}, nil,
+			&ygo.StringContent{Str: "ab"}),
+	}, nil)
+}
+
+func TestBranch_views(t *testing.T) {
+	doc := newDoc(t)
+	assert.Nil(t, doc.ApplyUpdate(nestedDocUpdate(t)))
+
+	text, err := doc.GetText("text")
+	assert.Nil(t, err)
+	assert.Equal(t, "ab", text.String())
+	assert.Equal(t, uint32(2), text.Len())
+
+	m, err := doc.GetMap("map")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, m.Len())
+	assert.Equal(t, []string{"k"}, m.Keys())
+	value, ok := m.Get("k")
+	assert.True(t, ok)
+	assert.Equal(t, lib0.NewAnyBool(true), value)
+	_, ok = m.Get("other")
+	assert.False(t, ok)
+
+	array, err := doc.GetArray("array")
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(3), array.Len())
+	values := array.ToSlice()
+	assert.Len(t, values, 3)
+	assert.Equal(t, anyValues(1, 2), []lib0.Any{values[0].(lib0.Any), values[1].(lib0.Any)})
+	second, ok := array.Get(1)
+	assert.True(t, ok)
+	assert.Equal(t, mustAny(2), second)
+	_, ok = array.Get(3)
+	assert.False(t, ok)
+
+	element := values[2].(*ygo.XmlElement)
+	assert.Equal(t, "p", element.Name())
+	class, ok := element.GetAttribute("class")
+	assert.True(t, ok)
+	assert.Equal(t, mustAny("x"), class)
+	children := element.Children()
+	assert.Len(t, children, 1)
+	assert.Equal(t, "hi", children[0].(*ygo.Text).String())
+
+	// the root types keep the type they were requested as first
+	_, err = doc.GetText("map")
+	assert.NotNil(t, err)
+}
+
+func TestBranch_pathTo(t *testing.T) {
+	store := ygo.NewDocStore()
+	applyV1(t, store, nestedDocUpdate(t))
+	array := rootType(t, store, "array")
+	element := array.Start.Right.Content.(*ygo.TypeContent).Branch
+	text := element.Start.Content.(*ygo.TypeContent).Branch
+	assert.Equal(t, array, element.Parent())
+	assert.Nil(t, array.Parent())
+
+	path, ok := array.PathTo(text)
+	assert.True(t, ok)
+	assert.Equal(t, []ygo.PathSegment{{Index: 2}, {Index: 0}}, path)
+	path, ok = element.PathTo(text)
+	assert.True(t, ok)
+	assert.Equal(t, []ygo.PathSegment{{Index: 0}}, path)
+	path, ok = array.PathTo(array)
+	assert.True(t, ok)
+	assert.Empty(t, path)
+	_, ok = text.PathTo(array)
+	assert.False(t, ok)
+	_, ok = rootType(t, store, "map").PathTo(text)
+	assert.False(t, ok)
+
+	// deleted items before a type don't count
+	applyV1(t, store, encodeV1(t, nil, []ygo.BlockRange{{ID: *id(1, 0), Len: 1}}))
+	path, _ = array.PathTo(text)
+	assert.Equal(t, []ygo.PathSegment{{Index: 1}, {Index: 0}}, path)
+}
+
+func TestBranch_observe(t *testing.T) {
+	store := ygo.NewDocStore()
+	array := rootType(t, store, "array")
+	var events []*ygo.Event
+	var deepEvents [][]*ygo.Event
+	array.Observe(func(e *ygo.Event) { events = append(events, e) })
+	unobserve := array.ObserveDeep(func(e []*ygo.Event) { deepEvents = append(deepEvents, e) })
+
+	// the nested types are created by the same transaction, only the root
+	// type changed for its observers
+	applyV1(t, store, nestedDocUpdate(t))
+	assert.Len(t, events, 1)
+	assert.Equal(t, array, events[0].Target)
+	assert.True(t, events[0].SequenceChanged)
+	assert.Len(t, deepEvents, 1)
+	assert.Len(t, deepEvents[0], 1)
+
+	element := array.Start.Right.Content.(*ygo.TypeContent).Branch
+	text := element.Start.Content.(*ygo.TypeContent).Branch
+	var elementEvents []*ygo.Event
+	element.Observe(func(e *ygo.Event) { elementEvents = append(elementEvents, e) })
+	class := "class"
+	applyV1(t, store, encodeV1(t, []ygo.Block{
+		// "!" is appended to "hi" and the class is set again
+		ygo.NewItem(*id(2, 0), nil, id(1, 6), nil, nil, ygo.TypePtr{Unknown: &ygo.Unknown{}}, nil,
+			&ygo.StringContent{Str: "!"}),
+		ygo.NewItem(*id(2, 1), nil, id(1, 3), nil, nil, ygo.TypePtr{Unknown: &ygo.Unknown{}}, &class,
+			&ygo.AnyContent{Values: anyValues("y")}),
+	}, nil))
+	assert.Len(t, events, 1)
+	assert.Len(t, elementEvents, 1)
+	assert.Equal(t, map[string]struct{}{"class": {}}, elementEvents[0].Keys)
+	assert.False(t, elementEvents[0].SequenceChanged)
+	assert.Empty(t, elementEvents[0].Path)
+
+	assert.Len(t, deepEvents, 2)
+	deep := deepEvents[1]
+	assert.Len(t, deep, 2)
+	assert.Equal(t, text, deep[0].Target)
+	assert.True(t, deep[0].SequenceChanged)
+	assert.Equal(t, []ygo.PathSegment{{Index: 2}, {Index: 0}}, deep[0].Path)
+	assert.Equal(t, element, deep[1].Target)
+	assert.Equal(t, []ygo.PathSegment{{Index: 2}}, deep[1].Path)
+
+	// no more deep events after unobserving, deleting the element is a
+	// change of the array
+	unobserve()
+	applyV1(t, store, encodeV1(t, nil, []ygo.BlockRange{{ID: *id(1, 2), Len: 1}}))
+	assert.Len(t, deepEvents, 2)
+	assert.Len(t, events, 2)
+	assert.Len(t, elementEvents, 1)
+}
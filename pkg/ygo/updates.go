@@ -1 +1,728 @@
 package ygo
+
+import (
+	"cmp"
+	"fmt"
+	"math"
+	"slices"
+)
+
+// Update is a decoded update: the blocks of each client ordered by clock,
+// followed by the delete set. Decoding an update doesn't need a Doc, the
+// blocks are kept exactly as they were read, including Skip blocks.
+type Update struct {
+	blocks    map[ClientID][]Block
+	deleteSet DeleteSet
+}
+
+var _ Encode = &Update{}
+var _ Decode = &Update{}
+
+func NewUpdate() *Update {
+	return &Update{
+		blocks:    make(map[ClientID][]Block),
+		deleteSet: NewDeleteSet(),
+	}
+}
+
+func (u *Update) IsEmpty() bool {
+	return len(u.Clients()) == 0 && u.deleteSet.IsEmpty()
+}
+
+// Clients returns the clients which have blocks in this update, in
+// descending order, which is the order they are encoded in.
+func (u *Update) Clients() []ClientID {
+	clients := sortedClients(u.blocks)
+	return slices.DeleteFunc(clients, func(c ClientID) bool {
+		return len(u.blocks[c]) == 0
+	})
+}
+
+// Blocks returns the blocks of a client, ordered by clock.
+func (u *Update) Blocks(client ClientID) []Block {
+	return u.blocks[client]
+}
+
+func (u *Update) DeleteSet() *DeleteSet {
+	return &u.deleteSet
+}
+
+// Push appends a block to the blocks of its client. The block must start
+// where the last block of the client ends.
+func (u *Update) Push(block Block) {
+	client := block.Id().Client
+	u.blocks[client] = append(u.blocks[client], block)
+}
+
+func (u *Update) Encode(encoder Encoder) error {
+	clients := u.Clients()
+	if err := encoder.WriteVarUint(uint(len(clients))); err != nil {
+		return err
+	}
+	for _, client := range clients {
+		blocks := u.blocks[client]
+		if err := encoder.WriteVarUint(uint(len(blocks))); err != nil {
+			return err
+		}
+		if err := encoder.WriteClient(client); err != nil {
+			return err
+		}
+		if err := encoder.WriteVarUint32(blocks[0].Id().Clock); err != nil {
+			return err
+		}
+		for _, block := range blocks {
+			if err := block.Encode(encoder, 0); err != nil {
+				return err
+			}
+		}
+	}
+	return u.deleteSet.Encode(encoder)
+}
+
+func (u *Update) EncodeV1() ([]uint8, error) {
+	encoder := NewEncoderV1()
+	if err := u.Encode(&encoder); err != nil {
+		return nil, err
+	}
+	return encoder.ToBytes(), nil
+}
+
+func (u *Update) EncodeV2() ([]uint8, error) {
+	encoder := NewEncoderV2()
+	if err := u.Encode(&encoder); err != nil {
+		return nil, err
+	}
+	return encoder.ToBytes()
+}
+
+func (u *Update) Decode(decoder Decoder) error {
+	if u.blocks == nil {
+		u.blocks = make(map[ClientID][]Block)
+	}
+	err := readBlocks(decoder, func(block Block) error {
+		client := block.Id().Client
+		u.blocks[client] = append(u.blocks[client], block)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if u.deleteSet.clients == nil {
+		u.deleteSet = NewDeleteSet()
+	}
+	return u.deleteSet.Decode(decoder)
+}
+
+// readBlocks decodes the blocks section of an update and hands every block,
+// including skips, to f in the order they were written. The delete set that
+// follows is left in the decoder.
+func readBlocks(decoder Decoder, f func(block Block) error) error {
+	numClients, err := readCount(decoder, "client count")
+	if err != nil {
+		return err
+	}
+	for range numClients {
+		numBlocks, err := readCount(decoder, "block count")
+		if err != nil {
+			return err
+		}
+		client, err := decoder.ReadClient()
+		if err != nil {
+			return err
+		}
+		clock, err := decoder.ReadVarUint()
+		if err != nil {
+			return err
+		}
+		if clock > math.MaxUint32 {
+			return fmt.Errorf("var int exceeds max uint32 range: %v", clock)
+		}
+		id := ID{Client: client, Clock: uint32(clock)}
+		for range numBlocks {
+			block, err := decodeBlock(decoder, id)
+			if err != nil {
+				return err
+			}
+			if uint64(id.Clock)+uint64(block.Len()) > math.MaxUint32 {
+				return fmt.Errorf("block %v exceeds max clock", id)
+			}
+			if err := f(block); err != nil {
+				return err
+			}
+			id.Clock += block.Len()
+		}
+	}
+	return nil
+}
+
+// DecodeV1 reads a V1 encoded update. buf is copied first, binary contents
+// would share its memory otherwise, so the caller is free to reuse it.
+func (u *Update) DecodeV1(buf []uint8) error {
+	decoder := NewDecoderV1FromBytes(slices.Clone(buf))
+	return u.Decode(&decoder)
+}
+
+// DecodeV2 reads a V2 encoded update, buf is copied like in DecodeV1.
+func (u *Update) DecodeV2(buf []uint8) error {
+	decoder, err := NewDecoderV2FromBytes(slices.Clone(buf))
+	if err != nil {
+		return err
+	}
+	return u.Decode(&decoder)
+}
+
+func decodeBlock(decoder Decoder, id ID) (Block, error) {
+	info, err := decoder.ReadInfo()
+	if err != nil {
+		return nil, err
+	}
+	switch info & CONTENT_REF_MASK {
+	case BLOCK_GC_REF_NUMBER:
+		len, err := decoder.ReadLen()
+		if err != nil {
+			return nil, err
+		}
+		return NewGC(id, len), nil
+	case BLOCK_SKIP_REF_NUMBER:
+		len, err := decoder.ReadVarUint()
+		if err != nil {
+			return nil, err
+		}
+		if len > math.MaxUint32 {
+			return nil, fmt.Errorf("var int exceeds max uint32 range: %v", len)
+		}
+		return NewSkip(id, uint32(len)), nil
+	default:
+		return decodeItem(decoder, id, info)
+	}
+}
+
+func decodeItem(decoder Decoder, id ID, info uint8) (*Item, error) {
+	var origin *ID
+	if info&HAS_ORIGIN != 0 {
+		left, err := decoder.ReadLeftId()
+		if err != nil {
+			return nil, err
+		}
+		origin = &left
+	}
+	var rightOrigin *ID
+	if info&HAS_RIGHT_ORIGIN != 0 {
+		right, err := decoder.ReadRightId()
+		if err != nil {
+			return nil, err
+		}
+		rightOrigin = &right
+	}
+	// the parent is only written if it can't be copied from the origins
+	parent := TypePtr{Unknown: &Unknown{}}
+	var parentSub *string
+	if info&(HAS_ORIGIN|HAS_RIGHT_ORIGIN) == 0 {
+		isYKey, err := decoder.ReadParentInfo()
+		if err != nil {
+			return nil, err
+		}
+		if isYKey {
+			name, err := decoder.ReadVarString()
+			if err != nil {
+				return nil, err
+			}
+			parent = TypePtr{Named: &name}
+		} else {
+			parentId, err := decoder.ReadLeftId()
+			if err != nil {
+				return nil, err
+			}
+			parent = TypePtr{ID: &parentId}
+		}
+		if info&HAS_PARENT_SUB != 0 {
+			sub, err := decoder.ReadVarString()
+			if err != nil {
+				return nil, err
+			}
+			parentSub = &sub
+		}
+	} else if info&HAS_PARENT_SUB != 0 {
+		// the key is copied from the origins as well, until then an empty
+		// one keeps the item marked as a map entry when it's encoded again
+		parentSub = new(string)
+	}
+	content, err := DecodeItemContent(decoder, info&CONTENT_REF_MASK)
+	if err != nil {
+		return nil, err
+	}
+	if content.Len() == 0 {
+		return nil, fmt.Errorf("item %v has empty content", id)
+	}
+	return NewItem(id, nil, origin, nil, rightOrigin, parent, parentSub, content), nil
+}
+
+// sortedClients returns the keys of a client map in descending order, the
+// order in which Yjs writes clients.
+func sortedClients[V any](m map[ClientID]V) []ClientID {
+	clients := make([]ClientID, 0, len(m))
+	for client := range m {
+		clients = append(clients, client)
+	}
+	slices.SortFunc(clients, func(a, b ClientID) int {
+		if a > b {
+			return -1
+		} else if a < b {
+			return 1
+		}
+		return 0
+	})
+	return clients
+}
+
+// MergeUpdates merges V1 updates into a single V1 update, without
+// creating a Doc. This is the equivalent of Y.mergeUpdates.
+func MergeUpdates(updates [][]uint8) ([]uint8, error) {
+	if len(updates) == 1 {
+		return updates[0], nil
+	}
+	decoded := make([]*Update, len(updates))
+	for i, buf := range updates {
+		decoded[i] = NewUpdate()
+		if err := decoded[i].DecodeV1(buf); err != nil {
+			return nil, err
+		}
+	}
+	return mergeUpdates(decoded).EncodeV1()
+}
+
+// MergeUpdatesV2 merges V2 updates into a single V2 update, without
+// creating a Doc. This is the equivalent of Y.mergeUpdatesV2.
+func MergeUpdatesV2(updates [][]uint8) ([]uint8, error) {
+	if len(updates) == 1 {
+		return updates[0], nil
+	}
+	decoded := make([]*Update, len(updates))
+	for i, buf := range updates {
+		decoded[i] = NewUpdate()
+		if err := decoded[i].DecodeV2(buf); err != nil {
+			return nil, err
+		}
+	}
+	return mergeUpdates(decoded).EncodeV2()
+}
+
+// mergeUpdates walks the blocks of all updates at once, higher clients
+// first and ordered by clock within a client. Overlapping blocks are
+// written once, gaps are filled with Skip blocks and adjacent blocks are
+// squashed where possible. The blocks of the given updates are consumed.
+func mergeUpdates(updates []*Update) *Update {
+	result := NewUpdate()
+	cursors := make([]*blockCursor, 0, len(updates))
+	for _, update := range updates {
+		result.deleteSet.Merge(&update.deleteSet)
+		cursors = append(cursors, newBlockCursor(update))
+	}
+	result.deleteSet.Squash()
+
+	var currWrite Block
+	for {
+		cursors = slices.DeleteFunc(cursors, func(c *blockCursor) bool {
+			return c.current() == nil
+		})
+		if len(cursors) == 0 {
+			break
+		}
+		slices.SortStableFunc(cursors, compareBlockCursors)
+		cursor := cursors[0]
+		firstClient := cursor.current().Id().Client
+
+		if currWrite != nil {
+			curr := cursor.current()
+			iterated := false
+			// skip everything that has already been written, remember that
+			// higher clients are written first
+			for curr != nil &&
+				curr.Id().Clock+curr.Len() <= currWrite.Id().Clock+currWrite.Len() &&
+				curr.Id().Client >= currWrite.Id().Client {
+				curr = cursor.advance()
+				iterated = true
+			}
+			if curr == nil ||
+				curr.Id().Client != firstClient ||
+				(iterated && curr.Id().Clock > currWrite.Id().Clock+currWrite.Len()) {
+				// another cursor might have blocks of firstClient we're missing
+				continue
+			}
+
+			currWriteEnd := currWrite.Id().Clock + currWrite.Len()
+			if firstClient != currWrite.Id().Client {
+				result.Push(currWrite)
+				currWrite = curr
+				cursor.advance()
+			} else if currWriteEnd < curr.Id().Clock {
+				if skip, ok := currWrite.(*Skip); ok {
+					skip.Length = curr.Id().Clock + curr.Len() - skip.ID.Clock
+				} else {
+					result.Push(currWrite)
+					currWrite = NewSkip(ID{Client: firstClient, Clock: currWriteEnd}, curr.Id().Clock-currWriteEnd)
+				}
+			} else {
+				diff := currWriteEnd - curr.Id().Clock
+				if diff > 0 {
+					if skip, ok := currWrite.(*Skip); ok {
+						// prefer to cut the skip, the other block carries more information
+						skip.Length -= diff
+					} else {
+						curr = curr.Splice(diff)
+					}
+				}
+				if !squashUpdateBlocks(currWrite, curr) {
+					result.Push(currWrite)
+					currWrite = curr
+					cursor.advance()
+				}
+			}
+		} else {
+			currWrite = cursor.current()
+			cursor.advance()
+		}
+
+		for next := cursor.current(); next != nil &&
+			next.Id().Client == firstClient &&
+			next.Id().Clock == currWrite.Id().Clock+currWrite.Len(); next = cursor.advance() {
+			if !squashUpdateBlocks(currWrite, next) {
+				result.Push(currWrite)
+				currWrite = next
+			}
+		}
+	}
+	if currWrite != nil {
+		result.Push(currWrite)
+	}
+	return result
+}
+
+// squashUpdateBlocks appends right to left if right directly continues left.
+// Unlike items in a document, items of an update aren't linked, so items are
+// squashed when right was inserted right after left within the same bounds.
+func squashUpdateBlocks(left Block, right Block) bool {
+	if !left.SameType(right) {
+		return false
+	}
+	switch l := left.(type) {
+	case *GC, *Skip:
+		return left.TryMerge(right)
+	case *Item:
+		r := right.(*Item)
+		if l.ID.Client != r.ID.Client ||
+			l.ID.Clock+l.Length != r.ID.Clock ||
+			r.Origin == nil || *r.Origin != l.LastId() ||
+			!equalIdPtr(l.RightOrigin, r.RightOrigin) ||
+			l.IsDeleted() != r.IsDeleted() ||
+			!l.Content.TryMerge(r.Content) {
+			return false
+		}
+		if r.Info.IsKeep() {
+			l.Info.Set(ITEM_FLAG_KEEP)
+		}
+		l.Length += r.Length
+		return true
+	default:
+		return false
+	}
+}
+
+func equalIdPtr(a *ID, b *ID) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+// blockCursor iterates over the blocks of an update in the order they are
+// encoded: higher clients first, ordered by clock. Skip blocks are left out.
+type blockCursor struct {
+	blocks []Block
+	pos    int
+}
+
+func newBlockCursor(update *Update) *blockCursor {
+	blocks := []Block{}
+	for _, client := range update.Clients() {
+		for _, block := range update.blocks[client] {
+			if _, ok := block.(*Skip); !ok {
+				blocks = append(blocks, block)
+			}
+		}
+	}
+	return &blockCursor{blocks: blocks, pos: 0}
+}
+
+func (c *blockCursor) current() Block {
+	if c.pos < len(c.blocks) {
+		return c.blocks[c.pos]
+	}
+	return nil
+}
+
+func (c *blockCursor) advance() Block {
+	if c.pos < len(c.blocks) {
+		c.pos += 1
+	}
+	return c.current()
+}
+
+func compareBlockCursors(a *blockCursor, b *blockCursor) int {
+	x := a.current().Id()
+	y := b.current().Id()
+	if x.Client == y.Client {
+		return cmp.Compare(x.Clock, y.Clock)
+	}
+	return cmp.Compare(y.Client, x.Client)
+}
+
+// DiffUpdate returns the part of a V1 update which is missing from the
+// encoded state vector sv. This is the equivalent of Y.diffUpdate.
+func DiffUpdate(update []uint8, sv []uint8) ([]uint8, error) {
+	state := NewStateVector()
+	if err := state.DecodeV1(sv); err != nil {
+		return nil, err
+	}
+	decoded := NewUpdate()
+	if err := decoded.DecodeV1(update); err != nil {
+		return nil, err
+	}
+	return diffUpdate(decoded, &state).EncodeV1()
+}
+
+// DiffUpdateV2 returns the part of a V2 update which is missing from the
+// encoded state vector sv. The state vector is V1 encoded, the same as for
+// DiffUpdate. This is the equivalent of Y.diffUpdateV2.
+func DiffUpdateV2(update []uint8, sv []uint8) ([]uint8, error) {
+	state := NewStateVector()
+	if err := state.DecodeV1(sv); err != nil {
+		return nil, err
+	}
+	decoded := NewUpdate()
+	if err := decoded.DecodeV2(update); err != nil {
+		return nil, err
+	}
+	return diffUpdate(decoded, &state).EncodeV2()
+}
+
+// diffUpdate drops the blocks of each client that sv already contains. The
+// first block which is partially known is sliced at the state of its
+// client, everything after it is kept as is. The delete set is always kept
+// as a whole. The blocks of the given update are consumed.
+func diffUpdate(update *Update, sv *StateVector) *Update {
+	result := NewUpdate()
+	for _, client := range update.Clients() {
+		blocks := update.blocks[client]
+		i := 0
+		for i < len(blocks) {
+			_, isSkip := blocks[i].(*Skip)
+			if !isSkip && !sv.Contains(blocks[i].LastId()) {
+				break
+			}
+			i++
+		}
+		if i == len(blocks) {
+			continue
+		}
+		first := blocks[i]
+		if clock := sv.Get(client); clock > first.Id().Clock {
+			first = first.Splice(clock - first.Id().Clock)
+		}
+		result.Push(first)
+		for _, block := range blocks[i+1:] {
+			result.Push(block)
+		}
+	}
+	result.deleteSet = update.deleteSet
+	return result
+}
+
+// ConvertUpdateFormatV1ToV2 transcodes a V1 update into a V2 update. This
+// is the equivalent of Y.convertUpdateFormatV1ToV2.
+func ConvertUpdateFormatV1ToV2(update []uint8) ([]uint8, error) {
+	decoder := NewDecoderV1FromBytes(update)
+	encoder := NewEncoderV2()
+	if err := convertUpdateFormat(&decoder, &encoder); err != nil {
+		return nil, err
+	}
+	return encoder.ToBytes()
+}
+
+// ConvertUpdateFormatV2ToV1 transcodes a V2 update into a V1 update. This
+// is the equivalent of Y.convertUpdateFormatV2ToV1.
+func ConvertUpdateFormatV2ToV1(update []uint8) ([]uint8, error) {
+	decoder, err := NewDecoderV2FromBytes(update)
+	if err != nil {
+		return nil, err
+	}
+	encoder := NewEncoderV1()
+	if err := convertUpdateFormat(&decoder, &encoder); err != nil {
+		return nil, err
+	}
+	return encoder.ToBytes(), nil
+}
+
+// convertUpdateFormat streams blocks from the decoder to the encoder one at
+// a time, the update is never decoded as a whole.
+func convertUpdateFormat(decoder Decoder, encoder Encoder) error {
+	numClients, err := readCount(decoder, "client count")
+	if err != nil {
+		return err
+	}
+	if err := encoder.WriteVarUint64(numClients); err != nil {
+		return err
+	}
+	for range numClients {
+		numBlocks, err := readCount(decoder, "block count")
+		if err != nil {
+			return err
+		}
+		client, err := decoder.ReadClient()
+		if err != nil {
+			return err
+		}
+		clock, err := decoder.ReadVarUint()
+		if err != nil {
+			return err
+		}
+		if clock > math.MaxUint32 {
+			return fmt.Errorf("var int exceeds max uint32 range: %v", clock)
+		}
+		if err := encoder.WriteVarUint64(numBlocks); err != nil {
+			return err
+		}
+		if err := encoder.WriteClient(client); err != nil {
+			return err
+		}
+		if err := encoder.WriteVarUint64(clock); err != nil {
+			return err
+		}
+		id := ID{Client: client, Clock: uint32(clock)}
+		for range numBlocks {
+			block, err := decodeBlock(decoder, id)
+			if err != nil {
+				return err
+			}
+			if err := block.Encode(encoder, 0); err != nil {
+				return err
+			}
+			id.Clock += block.Len()
+		}
+	}
+	ds := NewDeleteSet()
+	if err := ds.Decode(decoder); err != nil {
+		return err
+	}
+	return ds.Encode(encoder)
+}
+
+// EncodeStateVectorFromUpdate computes the state vector of a V1 update
+// without applying it to a document. Only the blocks starting at clock 0 and
+// not interrupted by a skip count towards the state of a client. The state
+// vector is always V1 encoded.
+func EncodeStateVectorFromUpdate(update []uint8) ([]uint8, error) {
+	decoder := NewDecoderV1FromBytes(update)
+	return encodeStateVectorFromUpdate(&decoder)
+}
+
+// EncodeStateVectorFromUpdateV2 is the V2 version of
+// EncodeStateVectorFromUpdate.
+func EncodeStateVectorFromUpdateV2(update []uint8) ([]uint8, error) {
+	decoder, err := NewDecoderV2FromBytes(update)
+	if err != nil {
+		return nil, err
+	}
+	return encodeStateVectorFromUpdate(&decoder)
+}
+
+func encodeStateVectorFromUpdate(decoder Decoder) ([]uint8, error) {
+	// clients are kept in the order of the update, which is how Yjs
+	// writes them
+	var clients []ClientID
+	clocks := make(map[ClientID]uint32)
+	var current ClientID
+	stopCounting := false
+	err := readBlocks(decoder, func(block Block) error {
+		id := block.Id()
+		if len(clients) == 0 || current != id.Client {
+			if _, seen := clocks[id.Client]; !seen {
+				clients = append(clients, id.Client)
+				clocks[id.Client] = 0
+			}
+			current = id.Client
+			stopCounting = id.Clock != clocks[id.Client]
+		}
+		if _, ok := block.(*Skip); ok {
+			stopCounting = true
+		}
+		if !stopCounting {
+			clocks[id.Client] = id.Clock + block.Len()
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	encoder := NewEncoderV1()
+	var size uint64
+	for _, client := range clients {
+		if clocks[client] > 0 {
+			size++
+		}
+	}
+	if err := encoder.WriteVarUint64(size); err != nil {
+		return nil, err
+	}
+	for _, client := range clients {
+		if clocks[client] == 0 {
+			continue
+		}
+		if err := encoder.WriteVarUint64(uint64(client)); err != nil {
+			return nil, err
+		}
+		if err := encoder.WriteVarUint32(clocks[client]); err != nil {
+			return nil, err
+		}
+	}
+	return encoder.ToBytes(), nil
+}
+
+// UpdateMeta describes the clock range of every client in an update: From
+// holds the clock of the first block and To the clock right after the last
+// block, skips included.
+type UpdateMeta struct {
+	From StateVector
+	To   StateVector
+}
+
+// ParseUpdateMeta reads the client clock ranges of a V1 update without
+// applying it to a document. This is the equivalent of Y.parseUpdateMeta.
+func ParseUpdateMeta(update []uint8) (UpdateMeta, error) {
+	decoder := NewDecoderV1FromBytes(update)
+	return parseUpdateMeta(&decoder)
+}
+
+// ParseUpdateMetaV2 is the V2 version of ParseUpdateMeta.
+func ParseUpdateMetaV2(update []uint8) (UpdateMeta, error) {
+	decoder, err := NewDecoderV2FromBytes(update)
+	if err != nil {
+		return UpdateMeta{}, err
+	}
+	return parseUpdateMeta(&decoder)
+}
+
+func parseUpdateMeta(decoder Decoder) (UpdateMeta, error) {
+	meta := UpdateMeta{
+		From: NewStateVector(),
+		To:   NewStateVector(),
+	}
+	err := readBlocks(decoder, func(block Block) error {
+		id := block.Id()
+		meta.From.SetMin(id.Client, id.Clock)
+		meta.To.SetMax(id.Client, id.Clock+block.Len())
+		return nil
+	})
+	return meta, err
+}
@@ -0,0 +1,601 @@
+package ygo_test
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"riguz.com/ygo/internal/lib0"
+	"riguz.com/ygo/pkg/ygo"
+)
+
+/*
+const doc = new Y.Doc()
+doc.clientID = 1
+doc.getText("text").insert(0, "abc")
+console.log(toHexString(Y.encodeStateAsUpdate(doc)))
+*/
+const textUpdateV1 = "01010100040104746578740361626300"
+
+// client 2 inserted "c" after "ab" of client 1, client 1's "a" is deleted:
+//
+//	client 2: item (2,0) origin (1,1) "c"
+//	client 1: item (1,0) parent "text" "ab"
+//	delete set: client 1 [0, 1)
+const concurrentUpdateV1 = "020102008401010163010100040104746578740261620101010001"
+const concurrentUpdateV2 = "000003024100010200038400040b0763746578746162010402010100" +
+	"0002010001000101010000"
+
+func TestUpdate_decodeV1(t *testing.T) {
+	buf, _ := hex.DecodeString(textUpdateV1)
+	update := ygo.NewUpdate()
+
+	err := update.DecodeV1(buf)
+
+	assert.Nil(t, err)
+	assert.Equal(t, []ygo.ClientID{1}, update.Clients())
+	blocks := update.Blocks(1)
+	assert.Equal(t, 1, len(blocks))
+	item, err := blocks[0].AsItem()
+	assert.Nil(t, err)
+	assert.Equal(t, ygo.ID{Client: 1, Clock: 0}, item.ID)
+	assert.Equal(t, uint32(3), item.Len())
+	assert.Equal(t, "text", *item.Parent.Named)
+	assert.Nil(t, item.Origin)
+	assert.Nil(t, item.RightOrigin)
+	assert.Equal(t, &ygo.StringContent{Str: "abc"}, item.Content)
+	assert.Equal(t, true, item.IsCountable())
+	assert.Equal(t, true, update.DeleteSet().IsEmpty())
+
+	encoded, err := update.EncodeV1()
+	assert.Nil(t, err)
+	assert.Equal(t, textUpdateV1, hex.EncodeToString(encoded))
+}
+
+func TestUpdate_decodeWithDeleteSet(t *testing.T) {
+	v1, _ := hex.DecodeString(concurrentUpdateV1)
+	v2, _ := hex.DecodeString(concurrentUpdateV2)
+	for _, decode := range []func(*ygo.Update) error{
+		func(u *ygo.Update) error { return u.DecodeV1(v1) },
+		func(u *ygo.Update) error { return u.DecodeV2(v2) },
+	} {
+		update := ygo.NewUpdate()
+		assert.Nil(t, decode(update))
+
+		assert.Equal(t, []ygo.ClientID{2, 1}, update.Clients())
+		item, err := update.Blocks(2)[0].AsItem()
+		assert.Nil(t, err)
+		assert.Equal(t, &ygo.ID{Client: 1, Clock: 1}, item.Origin)
+		assert.NotNil(t, item.Parent.Unknown)
+		assert.Equal(t, &ygo.StringContent{Str: "c"}, item.Content)
+		assert.Equal(t, []ygo.ClientID{1}, update.DeleteSet().Clients())
+		ranges := update.DeleteSet().Get(1)
+		assert.Equal(t, 1, len(ranges))
+		assert.Equal(t, uint64(0), ranges[0].Start)
+		assert.Equal(t, uint64(1), ranges[0].End)
+
+		encoded, err := update.EncodeV1()
+		assert.Nil(t, err)
+		assert.Equal(t, concurrentUpdateV1, hex.EncodeToString(encoded))
+		encoded, err = update.EncodeV2()
+		assert.Nil(t, err)
+		assert.Equal(t, concurrentUpdateV2, hex.EncodeToString(encoded))
+	}
+}
+
+func TestUpdate_roundTripAllBlocks(t *testing.T) {
+	root := "root"
+	key := "key"
+	name := "p"
+	update := ygo.NewUpdate()
+	client := ygo.ClientID(7)
+	parent := ygo.TypePtr{Named: &root}
+	contents := []ygo.ItemContent{
+		&ygo.DeletedContent{Length: 2},
+		&ygo.JsonContent{Values: []string{`{"a":1}`, "undefined"}},
+		&ygo.BinaryContent{Data: []uint8{1, 2, 3}},
+		&ygo.StringContent{Str: "Hello,中国！𐐷"},
+		&ygo.EmbedContent{Embed: mustAny(map[string]any{"image": "a.png"})},
+		&ygo.FormatContent{Key: "bold", Value: lib0.NewAnyBool(true)},
+		&ygo.TypeContent{TypeRef: ygo.TYPE_REFS_XML_ELEMENT, Name: &name},
+		&ygo.AnyContent{Values: anyValues("x", int64(1), true, nil)},
+		&ygo.DocContent{Guid: "guid", Opts: lib0.NewAnyObject()},
+		&ygo.MoveContent{Start: ygo.ID{Client: 7, Clock: 1}, End: ygo.ID{Client: 7, Clock: 3}, EndAfter: true},
+	}
+	clock := uint32(0)
+	update.Push(ygo.NewGC(ygo.ID{Client: client, Clock: clock}, 3))
+	clock += 3
+	for i, content := range contents {
+		var origin *ygo.ID
+		var sub *string
+		if i%2 == 1 {
+			origin = &ygo.ID{Client: client, Clock: clock - 1}
+		}
+		if i == 4 {
+			sub = &key
+		}
+		update.Push(ygo.NewItem(ygo.ID{Client: client, Clock: clock}, nil, origin, nil, nil, parent, sub, content))
+		clock += content.Len()
+	}
+	update.Push(ygo.NewSkip(ygo.ID{Client: client, Clock: clock}, 5))
+	update.DeleteSet().Insert(ygo.ID{Client: client, Clock: 0}, 3)
+	update.DeleteSet().Insert(ygo.ID{Client: client, Clock: 5}, 2)
+
+	v1, err := update.EncodeV1()
+	assert.Nil(t, err)
+	v2, err := update.EncodeV2()
+	assert.Nil(t, err)
+
+	fromV1 := ygo.NewUpdate()
+	assert.Nil(t, fromV1.DecodeV1(v1))
+	fromV2 := ygo.NewUpdate()
+	assert.Nil(t, fromV2.DecodeV2(v2))
+	for _, decoded := range []*ygo.Update{fromV1, fromV2} {
+		blocks := decoded.Blocks(client)
+		assert.Equal(t, len(contents)+2, len(blocks))
+		assert.Equal(t, true, blocks[0].IsGc())
+		assert.Equal(t, uint32(3), blocks[0].Len())
+		for i, content := range contents {
+			item, err := blocks[i+1].AsItem()
+			assert.Nil(t, err)
+			assert.Equal(t, content, item.Content)
+		}
+		skip := blocks[len(blocks)-1]
+		assert.Equal(t, false, skip.IsItem())
+		assert.Equal(t, false, skip.IsGc())
+		assert.Equal(t, uint32(5), skip.Len())
+		assert.Equal(t, update.DeleteSet().Get(client), decoded.DeleteSet().Get(client))
+
+		reencoded, err := decoded.EncodeV1()
+		assert.Nil(t, err)
+		assert.Equal(t, hex.EncodeToString(v1), hex.EncodeToString(reencoded))
+		reencoded, err = decoded.EncodeV2()
+		assert.Nil(t, err)
+		assert.Equal(t, hex.EncodeToString(v2), hex.EncodeToString(reencoded))
+	}
+}
+
+func TestUpdate_decodeCopiesBuffer(t *testing.T) {
+	root := "array"
+	update := ygo.NewUpdate()
+	update.Push(ygo.NewItem(ygo.ID{Client: 1, Clock: 0}, nil, nil, nil, nil,
+		ygo.TypePtr{Named: &root}, nil, &ygo.BinaryContent{Data: []uint8{1, 2, 3}}))
+	v1, err := update.EncodeV1()
+	assert.Nil(t, err)
+	v2, err := update.EncodeV2()
+	assert.Nil(t, err)
+
+	fromV1 := ygo.NewUpdate()
+	assert.Nil(t, fromV1.DecodeV1(v1))
+	fromV2 := ygo.NewUpdate()
+	assert.Nil(t, fromV2.DecodeV2(v2))
+	// the caller reuses its buffers
+	clear(v1)
+	clear(v2)
+	for _, decoded := range []*ygo.Update{fromV1, fromV2} {
+		item := decoded.Blocks(1)[0].(*ygo.Item)
+		assert.Equal(t, []uint8{1, 2, 3}, item.Content.(*ygo.BinaryContent).Data)
+	}
+}
+
+func TestUpdate_decodeInvalid(t *testing.T) {
+	buf, _ := hex.DecodeString("01010100040104746578")
+	update := ygo.NewUpdate()
+	assert.ErrorIs(t, update.DecodeV1(buf), lib0.ErrUnexpectedEOF)
+}
+
+func TestUpdate_decodeWithLimits(t *testing.T) {
+	buf, _ := hex.DecodeString(textUpdateV1)
+	r := lib0.NewSliceRead(buf)
+	r.SetLimits(lib0.Limits{MaxStringLen: 3})
+	decoder := ygo.NewDecoderV1WithRead(&r)
+	update := ygo.NewUpdate()
+	err := update.Decode(&decoder)
+	assert.ErrorIs(t, err, lib0.ErrLimitExceeded)
+	var decodeErr *lib0.DecodeError
+	assert.ErrorAs(t, err, &decodeErr)
+	// "abc" would fit, but the root type name "text" does not
+	assert.Equal(t, int64(6), decodeErr.Offset)
+
+	r = lib0.NewSliceRead(buf)
+	r.SetLimits(lib0.Limits{MaxStringLen: 4})
+	decoder = ygo.NewDecoderV1WithRead(&r)
+	assert.Nil(t, ygo.NewUpdate().Decode(&decoder))
+}
+
+func TestUpdate_decodeHostile(t *testing.T) {
+	tests := []struct {
+		name string
+		v2   bool
+		hex  string
+	}{
+		{
+			// one json item at 1:0 in root "a" with a length column of
+			// 2,000,000 and a run of as many empty strings
+			name: "json content length",
+			v2:   true,
+			hex: "00" + "00" + "0101" + "00" + "00" + "0102" + "07016101" + "40fe897a" +
+				"0101" + "00" + "048092f401" + "01010000",
+		},
+		{
+			// same as above, but only 5 strings are read from the run
+			name: "string length run",
+			v2:   true,
+			hex: "00" + "00" + "0101" + "00" + "00" + "0102" + "07016101" + "40fe897a" +
+				"0101" + "00" + "0105" + "01010000",
+		},
+		{
+			// no blocks, a delete set with 1000 ranges for client 1
+			name: "delete set range count",
+			hex:  "00" + "0101e807",
+		},
+		{
+			name: "block count",
+			hex:  "01e80701",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf, _ := hex.DecodeString(tt.hex)
+			r := lib0.NewSliceRead(buf)
+			r.SetLimits(lib0.Limits{MaxElements: 10})
+			var decoder ygo.Decoder
+			if tt.v2 {
+				d, err := ygo.NewDecoderV2WithRead(&r)
+				assert.Nil(t, err)
+				decoder = &d
+			} else {
+				d := ygo.NewDecoderV1WithRead(&r)
+				decoder = &d
+			}
+			err := ygo.NewUpdate().Decode(decoder)
+			assert.ErrorIs(t, err, lib0.ErrLimitExceeded)
+		})
+	}
+}
+
+func textItem(client ygo.ClientID, clock uint32, origin *ygo.ID, str string) *ygo.Item {
+	var parent ygo.TypePtr
+	if origin == nil {
+		root := "text"
+		parent = ygo.TypePtr{Named: &root}
+	} else {
+		parent = ygo.TypePtr{Unknown: &ygo.Unknown{}}
+	}
+	return ygo.NewItem(ygo.ID{Client: client, Clock: clock}, nil, origin, nil, nil,
+		parent, nil, &ygo.StringContent{Str: str})
+}
+
+func encodeV1(t *testing.T, blocks []ygo.Block, deletes []ygo.BlockRange) []uint8 {
+	update := ygo.NewUpdate()
+	for _, b := range blocks {
+		update.Push(b)
+	}
+	for _, d := range deletes {
+		update.DeleteSet().Insert(d.ID, d.Len)
+	}
+	buf, err := update.EncodeV1()
+	assert.Nil(t, err)
+	return buf
+}
+
+func decodeUpdateV1(t *testing.T, hexUpdate string) *ygo.Update {
+	buf, err := hex.DecodeString(hexUpdate)
+	assert.Nil(t, err)
+	update := ygo.NewUpdate()
+	assert.Nil(t, update.DecodeV1(buf))
+	return update
+}
+
+func TestMergeUpdates_squashesAdjacentItems(t *testing.T) {
+	u1 := encodeV1(t, []ygo.Block{textItem(1, 0, nil, "ab")}, nil)
+	u2 := encodeV1(t, []ygo.Block{textItem(1, 2, &ygo.ID{Client: 1, Clock: 1}, "c")}, nil)
+
+	merged, err := ygo.MergeUpdates([][]uint8{u1, u2})
+	assert.Nil(t, err)
+	assert.Equal(t, textUpdateV1, hex.EncodeToString(merged))
+
+	merged, err = ygo.MergeUpdates([][]uint8{u2, u1})
+	assert.Nil(t, err)
+	assert.Equal(t, textUpdateV1, hex.EncodeToString(merged))
+}
+
+func TestMergeUpdates_overlapping(t *testing.T) {
+	u1 := encodeV1(t, []ygo.Block{textItem(1, 0, nil, "ab")}, nil)
+	u2 := encodeV1(t, []ygo.Block{textItem(1, 0, nil, "abc")}, nil)
+
+	merged, err := ygo.MergeUpdates([][]uint8{u1, u2, u1})
+	assert.Nil(t, err)
+	assert.Equal(t, textUpdateV1, hex.EncodeToString(merged))
+}
+
+func TestMergeUpdates_fillsGapsWithSkip(t *testing.T) {
+	u1 := encodeV1(t, []ygo.Block{textItem(1, 0, nil, "ab")}, nil)
+	u2 := encodeV1(t, []ygo.Block{textItem(1, 5, &ygo.ID{Client: 1, Clock: 4}, "f")}, nil)
+
+	merged, err := ygo.MergeUpdates([][]uint8{u2, u1})
+	assert.Nil(t, err)
+	update := ygo.NewUpdate()
+	assert.Nil(t, update.DecodeV1(merged))
+	blocks := update.Blocks(1)
+	assert.Equal(t, 3, len(blocks))
+	assert.Equal(t, true, blocks[0].IsItem())
+	assert.Equal(t, ygo.ID{Client: 1, Clock: 2}, blocks[1].Id())
+	assert.Equal(t, uint32(3), blocks[1].Len())
+	assert.Equal(t, false, blocks[1].IsItem())
+	assert.Equal(t, false, blocks[1].IsGc())
+	assert.Equal(t, ygo.ID{Client: 1, Clock: 5}, blocks[2].Id())
+}
+
+func TestMergeUpdates_multipleClientsAndDeleteSets(t *testing.T) {
+	u1 := encodeV1(t,
+		[]ygo.Block{textItem(1, 0, nil, "ab"), ygo.NewGC(ygo.ID{Client: 1, Clock: 2}, 2)},
+		[]ygo.BlockRange{{ID: ygo.ID{Client: 1, Clock: 0}, Len: 1}})
+	u2 := encodeV1(t,
+		[]ygo.Block{ygo.NewGC(ygo.ID{Client: 1, Clock: 4}, 1), textItem(2, 0, &ygo.ID{Client: 1, Clock: 1}, "x")},
+		[]ygo.BlockRange{{ID: ygo.ID{Client: 1, Clock: 1}, Len: 1}, {ID: ygo.ID{Client: 2, Clock: 0}, Len: 1}})
+
+	merged, err := ygo.MergeUpdates([][]uint8{u1, u2})
+	assert.Nil(t, err)
+	update := ygo.NewUpdate()
+	assert.Nil(t, update.DecodeV1(merged))
+
+	assert.Equal(t, []ygo.ClientID{2, 1}, update.Clients())
+	assert.Equal(t, 1, len(update.Blocks(2)))
+	blocks := update.Blocks(1)
+	assert.Equal(t, 2, len(blocks))
+	assert.Equal(t, true, blocks[1].IsGc())
+	assert.Equal(t, ygo.ID{Client: 1, Clock: 2}, blocks[1].Id())
+	assert.Equal(t, uint32(3), blocks[1].Len())
+
+	ranges := update.DeleteSet().Get(1)
+	assert.Equal(t, 1, len(ranges))
+	assert.Equal(t, uint64(0), ranges[0].Start)
+	assert.Equal(t, uint64(2), ranges[0].End)
+	assert.Equal(t, 1, len(update.DeleteSet().Get(2)))
+}
+
+func TestMergeUpdates_mapEntries(t *testing.T) {
+	root := "map"
+	entry := func(client ygo.ClientID, clock uint32, origin *ygo.ID, key string, value int64) ygo.Block {
+		parent := ygo.TypePtr{Named: &root}
+		if origin != nil {
+			parent = ygo.TypePtr{Unknown: &ygo.Unknown{}}
+		}
+		return ygo.NewItem(ygo.ID{Client: client, Clock: clock}, nil, origin, nil, nil,
+			parent, &key, &ygo.AnyContent{Values: anyValues(value)})
+	}
+	// a is set three times in a row, each entry deletes the previous one
+	updates := [][]uint8{
+		encodeV1(t, []ygo.Block{entry(1, 0, nil, "a", 1)}, nil),
+		encodeV1(t, []ygo.Block{entry(1, 1, &ygo.ID{Client: 1, Clock: 0}, "a", 2)},
+			[]ygo.BlockRange{{ID: ygo.ID{Client: 1, Clock: 0}, Len: 1}}),
+		encodeV1(t, []ygo.Block{entry(2, 0, nil, "b", 4)}, nil),
+		encodeV1(t, []ygo.Block{entry(1, 2, &ygo.ID{Client: 1, Clock: 1}, "a", 3)},
+			[]ygo.BlockRange{{ID: ygo.ID{Client: 1, Clock: 1}, Len: 1}}),
+	}
+	merged, err := ygo.MergeUpdates(updates)
+	assert.Nil(t, err)
+	update := ygo.NewUpdate()
+	assert.Nil(t, update.DecodeV1(merged))
+	assert.Equal(t, 1, len(update.Blocks(1)))
+
+	separate := newDoc(t)
+	for _, u := range updates {
+		assert.Nil(t, separate.ApplyUpdate(u))
+	}
+	doc := newDoc(t)
+	assert.Nil(t, doc.ApplyUpdate(merged))
+	for _, d := range []*ygo.Doc{separate, doc} {
+		m, err := d.GetMap("map")
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"a", "b"}, m.Keys())
+		a, _ := m.Get("a")
+		assert.Equal(t, mustAny(int64(3)), a)
+		b, _ := m.Get("b")
+		assert.Equal(t, mustAny(int64(4)), b)
+	}
+}
+
+func TestMergeUpdatesV2(t *testing.T) {
+	v1 := [][]uint8{
+		encodeV1(t, []ygo.Block{textItem(1, 0, nil, "ab")}, nil),
+		encodeV1(t, []ygo.Block{textItem(1, 2, &ygo.ID{Client: 1, Clock: 1}, "c"), textItem(2, 0, nil, "x")},
+			[]ygo.BlockRange{{ID: ygo.ID{Client: 1, Clock: 0}, Len: 1}}),
+	}
+	v2 := make([][]uint8, len(v1))
+	for i, buf := range v1 {
+		update := ygo.NewUpdate()
+		assert.Nil(t, update.DecodeV1(buf))
+		encoded, err := update.EncodeV2()
+		assert.Nil(t, err)
+		v2[i] = encoded
+	}
+
+	mergedV1, err := ygo.MergeUpdates(v1)
+	assert.Nil(t, err)
+	mergedV2, err := ygo.MergeUpdatesV2(v2)
+	assert.Nil(t, err)
+
+	update := ygo.NewUpdate()
+	assert.Nil(t, update.DecodeV2(mergedV2))
+	asV1, err := update.EncodeV1()
+	assert.Nil(t, err)
+	assert.Equal(t, hex.EncodeToString(mergedV1), hex.EncodeToString(asV1))
+}
+
+func TestDiffUpdate(t *testing.T) {
+	update, _ := hex.DecodeString(textUpdateV1)
+	var tests = []struct {
+		name     string
+		sv       string
+		expected string
+	}{
+		{"empty state vector", "00", textUpdateV1},
+		{"other client", "010201", textUpdateV1},
+		// (1,1) "bc" with origin (1,0)
+		{"partially known", "010101", "0101010184010002626300"},
+		{"fully known", "010103", "0000"},
+		{"ahead", "010105", "0000"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sv, _ := hex.DecodeString(tt.sv)
+			diff, err := ygo.DiffUpdate(update, sv)
+			assert.Nil(t, err)
+			assert.Equal(t, tt.expected, hex.EncodeToString(diff))
+		})
+	}
+}
+
+func TestDiffUpdate_keepsDeleteSetAndSkipsKnownBlocks(t *testing.T) {
+	update := ygo.NewUpdate()
+	update.Push(textItem(1, 0, nil, "ab"))
+	update.Push(ygo.NewSkip(ygo.ID{Client: 1, Clock: 2}, 2))
+	update.Push(textItem(1, 4, &ygo.ID{Client: 1, Clock: 3}, "ef"))
+	update.Push(ygo.NewGC(ygo.ID{Client: 1, Clock: 6}, 1))
+	update.Push(textItem(2, 0, nil, "x"))
+	update.DeleteSet().Insert(ygo.ID{Client: 1, Clock: 0}, 1)
+	buf, err := update.EncodeV1()
+	assert.Nil(t, err)
+
+	// knows client 1 up to clock 5, nothing of client 2
+	sv, _ := hex.DecodeString("010105")
+	diff, err := ygo.DiffUpdate(buf, sv)
+	assert.Nil(t, err)
+
+	result := ygo.NewUpdate()
+	assert.Nil(t, result.DecodeV1(diff))
+	assert.Equal(t, []ygo.ClientID{2, 1}, result.Clients())
+	blocks := result.Blocks(1)
+	assert.Equal(t, 2, len(blocks))
+	item, err := blocks[0].AsItem()
+	assert.Nil(t, err)
+	assert.Equal(t, ygo.ID{Client: 1, Clock: 5}, item.ID)
+	assert.Equal(t, &ygo.ID{Client: 1, Clock: 4}, item.Origin)
+	assert.Equal(t, &ygo.StringContent{Str: "f"}, item.Content)
+	assert.Equal(t, true, blocks[1].IsGc())
+	assert.Equal(t, 1, len(result.Blocks(2)))
+	assert.Equal(t, update.DeleteSet().Get(1), result.DeleteSet().Get(1))
+}
+
+func TestDiffUpdateV2(t *testing.T) {
+	buf, _ := hex.DecodeString(concurrentUpdateV2)
+	sv, _ := hex.DecodeString("0201010200")
+	diff, err := ygo.DiffUpdateV2(buf, sv)
+	assert.Nil(t, err)
+
+	result := ygo.NewUpdate()
+	assert.Nil(t, result.DecodeV2(diff))
+	assert.Equal(t, []ygo.ClientID{2, 1}, result.Clients())
+	item, err := result.Blocks(1)[0].AsItem()
+	assert.Nil(t, err)
+	assert.Equal(t, &ygo.StringContent{Str: "b"}, item.Content)
+	item, err = result.Blocks(2)[0].AsItem()
+	assert.Nil(t, err)
+	assert.Equal(t, &ygo.StringContent{Str: "c"}, item.Content)
+	assert.Equal(t, false, result.DeleteSet().IsEmpty())
+}
+
+func TestConvertUpdateFormat(t *testing.T) {
+	v1, _ := hex.DecodeString(concurrentUpdateV1)
+	v2, _ := hex.DecodeString(concurrentUpdateV2)
+
+	converted, err := ygo.ConvertUpdateFormatV1ToV2(v1)
+	assert.Nil(t, err)
+	assert.Equal(t, concurrentUpdateV2, hex.EncodeToString(converted))
+
+	converted, err = ygo.ConvertUpdateFormatV2ToV1(v2)
+	assert.Nil(t, err)
+	assert.Equal(t, concurrentUpdateV1, hex.EncodeToString(converted))
+}
+
+func TestConvertUpdateFormat_roundTrip(t *testing.T) {
+	name := "p"
+	update := ygo.NewUpdate()
+	update.Push(textItem(1, 0, nil, "Hello,中国！𐐷"))
+	update.Push(ygo.NewSkip(ygo.ID{Client: 1, Clock: 12}, 3))
+	update.Push(ygo.NewGC(ygo.ID{Client: 1, Clock: 15}, 2))
+	update.Push(ygo.NewItem(ygo.ID{Client: 1, Clock: 17}, nil, &ygo.ID{Client: 1, Clock: 16}, nil, nil,
+		ygo.TypePtr{Unknown: &ygo.Unknown{}}, nil, &ygo.FormatContent{Key: "bold", Value: lib0.NewAnyBool(true)}))
+	update.Push(ygo.NewItem(ygo.ID{Client: 3, Clock: 0}, nil, nil, nil, &ygo.ID{Client: 1, Clock: 0},
+		ygo.TypePtr{Unknown: &ygo.Unknown{}}, nil, &ygo.TypeContent{TypeRef: ygo.TYPE_REFS_XML_HOOK, Name: &name}))
+	update.DeleteSet().Insert(ygo.ID{Client: 1, Clock: 3}, 2)
+	update.DeleteSet().Insert(ygo.ID{Client: 1, Clock: 9}, 1)
+	update.DeleteSet().Insert(ygo.ID{Client: 3, Clock: 0}, 1)
+	v1, err := update.EncodeV1()
+	assert.Nil(t, err)
+	v2, err := update.EncodeV2()
+	assert.Nil(t, err)
+
+	converted, err := ygo.ConvertUpdateFormatV1ToV2(v1)
+	assert.Nil(t, err)
+	assert.Equal(t, hex.EncodeToString(v2), hex.EncodeToString(converted))
+	converted, err = ygo.ConvertUpdateFormatV2ToV1(v2)
+	assert.Nil(t, err)
+	assert.Equal(t, hex.EncodeToString(v1), hex.EncodeToString(converted))
+}
+
+func TestEncodeStateVectorFromUpdate(t *testing.T) {
+	gapped := ygo.NewUpdate()
+	gapped.Push(textItem(1, 0, nil, "abc"))
+	gapped.Push(ygo.NewSkip(ygo.ID{Client: 1, Clock: 3}, 2))
+	gapped.Push(textItem(1, 5, nil, "de"))
+	gapped.Push(textItem(3, 4, nil, "f"))
+
+	tests := []struct {
+		name   string
+		update *ygo.Update
+		sv     string
+	}{
+		{"empty", ygo.NewUpdate(), "00"},
+		{"text", decodeUpdateV1(t, textUpdateV1), "010103"},
+		{"concurrent", decodeUpdateV1(t, concurrentUpdateV1), "0202010102"},
+		{"skips and gaps", gapped, "010103"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v1, err := tt.update.EncodeV1()
+			assert.Nil(t, err)
+			sv, err := ygo.EncodeStateVectorFromUpdate(v1)
+			assert.Nil(t, err)
+			assert.Equal(t, tt.sv, hex.EncodeToString(sv))
+
+			v2, err := tt.update.EncodeV2()
+			assert.Nil(t, err)
+			sv, err = ygo.EncodeStateVectorFromUpdateV2(v2)
+			assert.Nil(t, err)
+			assert.Equal(t, tt.sv, hex.EncodeToString(sv))
+		})
+	}
+}
+
+func TestParseUpdateMeta(t *testing.T) {
+	update := ygo.NewUpdate()
+	update.Push(textItem(1, 0, nil, "abc"))
+	update.Push(ygo.NewSkip(ygo.ID{Client: 1, Clock: 3}, 2))
+	update.Push(textItem(1, 5, nil, "de"))
+	update.Push(textItem(3, 4, nil, "f"))
+	update.DeleteSet().Insert(ygo.ID{Client: 5, Clock: 0}, 3)
+
+	check := func(meta ygo.UpdateMeta) {
+		assert.Equal(t, 2, meta.From.Len())
+		assert.Equal(t, uint32(0), meta.From.Get(1))
+		assert.Equal(t, uint32(4), meta.From.Get(3))
+		assert.Equal(t, 2, meta.To.Len())
+		assert.Equal(t, uint32(7), meta.To.Get(1))
+		assert.Equal(t, uint32(5), meta.To.Get(3))
+	}
+
+	v1, err := update.EncodeV1()
+	assert.Nil(t, err)
+	meta, err := ygo.ParseUpdateMeta(v1)
+	assert.Nil(t, err)
+	check(meta)
+
+	v2, err := update.EncodeV2()
+	assert.Nil(t, err)
+	meta, err = ygo.ParseUpdateMetaV2(v2)
+	assert.Nil(t, err)
+	check(meta)
+}
@@ -0,0 +1,43 @@
+package ygo
+
+// XmlElement is a view of a Branch holding an xml element: its attributes
+// are the map entries, its children the sequence.
+type XmlElement struct {
+	branch *Branch
+}
+
+func NewXmlElement(branch *Branch) *XmlElement {
+	return &XmlElement{branch: branch}
+}
+
+func (x *XmlElement) Branch() *Branch {
+	return x.branch
+}
+
+// Name returns the node name, which is empty for a root type.
+func (x *XmlElement) Name() string {
+	if x.branch.Item != nil {
+		if c, ok := x.branch.Item.Content.(*TypeContent); ok && c.Name != nil {
+			return *c.Name
+		}
+	}
+	return ""
+}
+
+// GetAttribute returns the value of the attribute key.
+func (x *XmlElement) GetAttribute(key string) (any, bool) {
+	item, ok := x.branch.Get(key)
+	if !ok {
+		return nil, false
+	}
+	return lastValue(item), true
+}
+
+// Children returns the views of the child nodes.
+func (x *XmlElement) Children() []any {
+	var children []any
+	for item := range x.branch.Items() {
+		children = append(children, contentValues(item.Content)...)
+	}
+	return children
+}